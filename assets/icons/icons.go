@@ -42,6 +42,12 @@ var (
 	FileAttachment          = icons.FileAttachment
 	ActionBook              = icons.ActionBook
 	ActionCheckCircle       = icons.ActionCheckCircle
+	SocialGroup             = icons.SocialGroup
+	ContentClear            = icons.ContentClear
+	ContentRemove           = icons.ContentRemove
+	FileCreateNewFolder     = icons.FileCreateNewFolder
+	SocialMood              = icons.SocialMood
+	HardwareHeadset         = icons.HardwareHeadset
 )
 
 var ActionDoneIcon, _ = widget.NewIcon(icons.ActionDone)
@@ -78,3 +84,9 @@ var VideoIcon, _ = widget.NewIcon(Video)
 var ApkIcon, _ = widget.NewIcon(Apk)
 var FileExportIcon, _ = widget.NewIcon(FileExport)
 var CheckCircleIcon, _ = widget.NewIcon(icons.ActionCheckCircle)
+var ClearIcon, _ = widget.NewIcon(icons.ContentClear)
+var RemoveIcon, _ = widget.NewIcon(icons.ContentRemove)
+var UnarchiveIcon, _ = widget.NewIcon(icons.ContentUnarchive)
+var PrevIcon, _ = widget.NewIcon(icons.NavigationChevronLeft)
+var NextIcon, _ = widget.NewIcon(icons.NavigationChevronRight)
+var ShareIcon, _ = widget.NewIcon(icons.SocialShare)
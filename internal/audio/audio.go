@@ -3,10 +3,13 @@ package audio
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/CoyAce/opus/ogg"
@@ -34,6 +37,7 @@ type StreamConfig struct {
 	DeviceType               malgo.DeviceType
 	MalgoContext             malgo.Context
 	CaptureDeviceID          *malgo.DeviceID
+	PlaybackDeviceID         *malgo.DeviceID
 }
 
 func (config StreamConfig) asDeviceConfig(deviceType malgo.DeviceType) malgo.DeviceConfig {
@@ -73,6 +77,9 @@ func (config StreamConfig) asDeviceConfig(deviceType malgo.DeviceType) malgo.Dev
 	if config.CaptureDeviceID != nil {
 		deviceConfig.Capture.DeviceID = config.CaptureDeviceID.Pointer()
 	}
+	if config.PlaybackDeviceID != nil {
+		deviceConfig.Playback.DeviceID = config.PlaybackDeviceID.Pointer()
+	}
 	return deviceConfig
 }
 
@@ -96,7 +103,41 @@ func (config *StreamConfig) SetCaptureDeviceByName(mctx *malgo.Context, name str
 	return false, nil
 }
 
+// stream runs a device until ctx is done or a callback aborts. When its
+// device is unplugged, stops, or the selected or default device changes,
+// it is opened again on the device to use now.
 func stream(ctx context.Context, abortChan chan error, config StreamConfig, deviceCallbacks malgo.DeviceCallbacks) error {
+	deviceCallbacks.Stop = func() {
+		select {
+		case abortChan <- errDeviceChanged:
+		default:
+		}
+	}
+	for {
+		err := streamDevice(ctx, abortChan, config, deviceCallbacks)
+		if !errors.Is(err, errDeviceChanged) || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("audio device changed, reopening")
+		// let a device that is going away settle
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+		// closing the device called Stop, that is not a change of the next one
+		select {
+		case err = <-abortChan:
+			if !errors.Is(err, errDeviceChanged) {
+				return err
+			}
+		default:
+		}
+	}
+}
+
+func streamDevice(ctx context.Context, abortChan chan error, config StreamConfig, deviceCallbacks malgo.DeviceCallbacks) error {
+	opened := resolveDevice(&config, config.DeviceType)
 	deviceConfig := config.asDeviceConfig(malgo.Capture)
 	device, err := malgo.InitDevice(config.MalgoContext, deviceConfig, deviceCallbacks)
 	if err != nil {
@@ -109,18 +150,20 @@ func stream(ctx context.Context, abortChan chan error, config StreamConfig, devi
 		return err
 	}
 
-	ctxChan := ctx.Done()
-	if ctxChan != nil {
-		select {
-		case <-ctxChan:
-			err = ctx.Err()
-		case err = <-abortChan:
-		}
-	} else {
-		err = <-abortChan
+	var changed <-chan struct{}
+	if !opened.explicit {
+		var remove func()
+		changed, remove = devicesWatch.add(config.MalgoContext, opened)
+		defer remove()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-abortChan:
+		return err
+	case <-changed:
+		return errDeviceChanged
 	}
-
-	return err
 }
 
 // ListCaptureDevices returns all available capture devices with their names and IDs.
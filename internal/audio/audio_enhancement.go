@@ -4,6 +4,7 @@ import (
 	"log"
 	"math"
 	"runtime"
+	"slices"
 	"sync"
 
 	"github.com/CoyAce/apm"
@@ -124,6 +125,28 @@ type DeEsserConfig struct {
 }
 
 func DefaultAudioEnhancer() *Enhancer {
+	return NewEnhancer(VoicePreset())
+}
+
+// Presets are the names of the enhancement profiles offered to users.
+var Presets = []string{"Voice", "Music", "Raw"}
+
+// Preset returns the profile called name, nil if there is none.
+func Preset(name string) *EnhancementConfig {
+	switch name {
+	case "Voice":
+		return VoicePreset()
+	case "Music":
+		return MusicPreset()
+	case "Raw":
+		return RawPreset()
+	}
+	return nil
+}
+
+// VoicePreset cleans up speech: echo cancellation, noise suppression, gain
+// control, and an EQ and de-esser for clarity. It is the default.
+func VoicePreset() *EnhancementConfig {
 	config := DefaultEnhancementConfig()
 	mobile := runtime.GOOS == "android" || runtime.GOOS == "ios"
 	analogLevel := 180
@@ -168,7 +191,40 @@ func DefaultAudioEnhancer() *Enhancer {
 		config.ApmConfig.GainControl.GainDB = 15
 	}
 	config.StreamAnalogLevel = analogLevel
-	return NewEnhancer(config)
+	return config
+}
+
+// MusicPreset keeps echo cancellation for calls but leaves level, noise and
+// tone alone, which speech processing would pump and muffle.
+func MusicPreset() *EnhancementConfig {
+	config := VoicePreset()
+	config.ApmConfig.HighPassFilterEnabled = false
+	config.ApmConfig.CaptureLevelAdjustment.Enabled = false
+	config.ApmConfig.NoiseSuppression.Enabled = false
+	config.ApmConfig.GainControl.Enabled = false
+	config.Equalizer.Enabled = false
+	config.DeEsser.Enabled = false
+	return config
+}
+
+// RawPreset passes the microphone through untouched, echo included.
+func RawPreset() *EnhancementConfig {
+	config := MusicPreset()
+	config.ApmConfig.EchoCancellation.Enabled = false
+	config.Compression.Enabled = false
+	config.AGC.Enabled = false
+	return config
+}
+
+// Clone is a deep copy of c.
+func (c *EnhancementConfig) Clone() *EnhancementConfig {
+	clone := *c
+	if c.ApmConfig != nil {
+		apmConfig := *c.ApmConfig
+		clone.ApmConfig = &apmConfig
+	}
+	clone.Equalizer.Bands = slices.Clone(c.Equalizer.Bands)
+	return &clone
 }
 
 // DefaultEnhancementConfig returns default audio enhancement configuration
@@ -299,8 +355,98 @@ func (ae *Enhancer) Initialize() {
 	}
 }
 
+// Close releases the echo canceller, the enhancer must not be used after.
+func (ae *Enhancer) Close() {
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	if ae.processor != nil {
+		_ = ae.processor.Close()
+		ae.processor = nil
+	}
+}
+
+// Config returns a copy of the configuration in use.
+func (ae *Enhancer) Config() *EnhancementConfig {
+	ae.mu.RLock()
+	defer ae.mu.RUnlock()
+	return ae.config.Clone()
+}
+
+// UpdateConfig applies config to a running enhancer. Only stages whose
+// settings changed are rebuilt, the others keep their state; a new echo
+// canceller has to converge again.
+func (ae *Enhancer) UpdateConfig(config *EnhancementConfig) {
+	config = config.Clone()
+	ae.mu.Lock()
+	defer ae.mu.Unlock()
+	old := ae.config
+	ae.config = config
+	// the stages hold pointers into the config, so all of them move to the new one
+	if old.AGC != config.AGC {
+		ae.agc = NewAutomaticGainControl(&config.AGC)
+	} else {
+		ae.agc.config = &config.AGC
+	}
+	if old.Compression != config.Compression {
+		ae.compressor = NewDynamicRangeCompressor(&config.Compression)
+	} else {
+		ae.compressor.config = &config.Compression
+	}
+	if !slices.Equal(old.Equalizer.Bands, config.Equalizer.Bands) || old.Equalizer.SampleRate != config.Equalizer.SampleRate {
+		ae.equalizer = NewParametricEqualizer(&config.Equalizer)
+	} else {
+		ae.equalizer.config = &config.Equalizer
+	}
+	if old.DeEsser != config.DeEsser {
+		ae.deesser = NewDeEsser(&config.DeEsser)
+	} else {
+		ae.deesser.config = &config.DeEsser
+	}
+	if old.AGC.SampleRate != config.AGC.SampleRate {
+		ae.highPassFilter = NewHighPassFilter(80, config.AGC.SampleRate)
+	}
+	if apmChanged(old.ApmConfig, config.ApmConfig) {
+		ae.replaceProcessor()
+	} else if old.StreamAnalogLevel != config.StreamAnalogLevel && ae.processor != nil {
+		ae.processor.SetStreamAnalogLevel(config.StreamAnalogLevel)
+	}
+}
+
+// apmChanged reports whether the echo canceller has to be replaced, the
+// stream delay is passed with every frame and does not count.
+func apmChanged(old, config *apm.Config) bool {
+	if old == nil || config == nil {
+		return old != config
+	}
+	a := *old
+	a.EchoCancellation.StreamDelayMs = config.EchoCancellation.StreamDelayMs
+	return a != *config
+}
+
+// replaceProcessor closes the echo canceller and opens one for the current
+// config, it is called with the lock held.
+func (ae *Enhancer) replaceProcessor() {
+	if ae.processor != nil {
+		_ = ae.processor.Close()
+		ae.processor = nil
+	}
+	if ae.config.ApmConfig == nil {
+		return
+	}
+	processor, err := apm.New(*ae.config.ApmConfig)
+	if err != nil {
+		log.Printf("Can't create processor: %v", err)
+		return
+	}
+	processor.SetStreamAnalogLevel(ae.config.StreamAnalogLevel)
+	processor.Initialize()
+	ae.processor = processor
+}
+
 // AddFarEnd - 单独添加远端信号（用于异步处理）
 func (ae *Enhancer) AddFarEnd(farEnd []int16) {
+	ae.mu.RLock()
+	defer ae.mu.RUnlock()
 	if len(farEnd) != FrameSize || ae.processor == nil {
 		log.Printf("AddFarEnd failed")
 		return
@@ -348,7 +494,7 @@ func (ae *Enhancer) ProcessAudio(samples []float32) ([]float32, error) {
 	}
 
 	// Stage 3: Echo cancellation (should be first)
-	if ae.config.ApmConfig != nil {
+	if ae.config.ApmConfig != nil && ae.processor != nil {
 		ae.processor.SetStreamDelay(ae.config.ApmConfig.EchoCancellation.StreamDelayMs)
 		err := ae.processor.ProcessCapture(output)
 		if err != nil {
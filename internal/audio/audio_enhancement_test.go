@@ -149,3 +149,41 @@ func BenchmarkAudioEnhancer_ProcessAudio(b *testing.B) {
 		enhancer.ProcessAudio(samples)
 	}
 }
+
+func TestAudioEnhancer_UpdateConfig(t *testing.T) {
+	enhancer := NewEnhancer(DefaultEnhancementConfig())
+	agc, compressor := enhancer.agc, enhancer.compressor
+
+	config := enhancer.Config()
+	config.Compression.Enabled = true
+	config.Compression.Ratio = 8
+	config.Equalizer.Bands[0].Gain = 3
+	enhancer.UpdateConfig(config)
+	// changed after the update, must not reach the enhancer
+	config.Equalizer.Bands[0].Gain = 6
+
+	if enhancer.agc != agc || enhancer.agc.config != &enhancer.config.AGC {
+		t.Error("an unchanged stage should be kept and point at the new config")
+	}
+	if enhancer.compressor == compressor || !enhancer.compressor.config.Enabled {
+		t.Error("a changed stage should be rebuilt from the new config")
+	}
+	if got := enhancer.Config().Equalizer.Bands[0].Gain; got != 3 {
+		t.Errorf("the enhancer should keep its own copy of the config, but band gain %v", got)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	for _, name := range Presets {
+		if Preset(name) == nil {
+			t.Errorf("preset %s should exist", name)
+		}
+	}
+	raw := Preset("Raw")
+	if raw.ApmConfig.EchoCancellation.Enabled || raw.ApmConfig.NoiseSuppression.Enabled || raw.Equalizer.Enabled || raw.DeEsser.Enabled {
+		t.Errorf("raw should process nothing, but %+v", raw)
+	}
+	if music := Preset("Music"); !music.ApmConfig.EchoCancellation.Enabled || music.ApmConfig.GainControl.Enabled {
+		t.Errorf("music should only cancel echo")
+	}
+}
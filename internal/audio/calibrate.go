@@ -0,0 +1,157 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	maxStreamDelayMs   = 500 // the largest delay the echo canceller accepts
+	minCalibrationERLE = 6   // dB of echo removed before a delay is trusted
+	validationSignal   = 2 * time.Second
+	validationVolume   = 0.1
+)
+
+// calibrationConfig is short enough to run from the settings while the user waits.
+var calibrationConfig = Config{
+	MaxAttempts:        5,
+	MeasurementTimeout: 1 * time.Second,
+	SignalFrequency:    1000,
+	SignalDuration:     200 * time.Millisecond,
+	PlayVolume:         0.2,
+}
+
+// Calibration is the echo path measured between the selected speaker and
+// microphone.
+type Calibration struct {
+	StreamDelayMs int       `json:"streamDelayMs"`
+	Confidence    float64   `json:"confidence"`
+	ERLE          float64   `json:"erle"`
+	Measured      time.Time `json:"measured"`
+}
+
+// Valid reports whether the echo canceller removed enough echo at the
+// measured delay for it to replace the default.
+func (c Calibration) Valid() bool {
+	return c.ERLE >= minCalibrationERLE
+}
+
+// Calibrate measures the round trip from the selected speaker to the selected
+// microphone, then plays noise through an echo canceller set to that delay
+// and reports how much of it was cancelled. config is the enhancement in use,
+// it is not modified.
+func Calibrate(ctx context.Context, config *EnhancementConfig) (Calibration, error) {
+	if config.ApmConfig == nil || !config.ApmConfig.EchoCancellation.Enabled {
+		return Calibration{}, fmt.Errorf("echo cancellation is disabled")
+	}
+	w, err := NewMiniAudioWrapper(SampleRate, FrameSize)
+	if err != nil {
+		return Calibration{}, err
+	}
+	defer w.Close()
+
+	result, err := NewLatencyMeasurer(w, calibrationConfig).Measure(ctx)
+	_ = w.StopCapture()
+	if ctx.Err() != nil {
+		return Calibration{}, ctx.Err()
+	}
+	if err != nil {
+		return Calibration{}, err
+	}
+	c := Calibration{
+		StreamDelayMs: streamDelay(result.RoundTripLatency),
+		Confidence:    result.Confidence,
+		Measured:      result.Timestamp,
+	}
+	config = config.Clone()
+	config.ApmConfig.EchoCancellation.StreamDelayMs = c.StreamDelayMs
+	c.ERLE, err = measureERLE(ctx, w, config, result.RoundTripLatency)
+	return c, err
+}
+
+// streamDelay turns a round trip into the delay the echo canceller expects,
+// the far end is handed to it when queued for playback just like the test
+// signal was.
+func streamDelay(roundTrip time.Duration) int {
+	return int(min(max(roundTrip.Milliseconds(), 0), maxStreamDelayMs))
+}
+
+// measureERLE feeds noise to the speaker and to an enhancer built from config
+// as the far end, and cancels it from what the microphone picks up. It returns
+// the median ERLE over the second half of the signal, the first half is left
+// for the canceller to converge.
+func measureERLE(ctx context.Context, w *MiniAudioWrapper, config *EnhancementConfig, roundTrip time.Duration) (float64, error) {
+	ae := NewEnhancer(config)
+	ae.Initialize()
+	defer ae.Close()
+
+	var (
+		mu      sync.Mutex
+		pending []float32
+		erle    []float64
+	)
+	start := time.Now()
+	err := w.StartCapture(func(data []float32) {
+		mu.Lock()
+		defer mu.Unlock()
+		pending = append(pending, data...)
+		for len(pending) >= FrameSize {
+			if _, err := ae.ProcessAudio(pending[:FrameSize]); err == nil && time.Since(start) > roundTrip+validationSignal/2 {
+				erle = append(erle, ae.GetMetrics().Stats.EchoReturnLossEnhancement)
+			}
+			pending = pending[FrameSize:]
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer w.StopCapture()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for range validationSignal / (10 * time.Millisecond) {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		frame := noiseFrame()
+		ae.AddFarEnd(Float32ToInt16(frame))
+		if err = w.PlayBuffer(frame); err != nil {
+			return 0, err
+		}
+	}
+	// wait for the tail of the signal to come back through the microphone
+	select {
+	case <-time.After(roundTrip + 100*time.Millisecond):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(erle) == 0 {
+		return 0, fmt.Errorf("no echo picked up")
+	}
+	return median(erle), nil
+}
+
+// noiseFrame is one frame of white noise, unlike a tone it excites the whole
+// echo path the canceller has to model.
+func noiseFrame() []float32 {
+	frame := make([]float32, FrameSize)
+	for i := range frame {
+		frame[i] = (rand.Float32()*2 - 1) * validationVolume
+	}
+	return frame
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2]
+}
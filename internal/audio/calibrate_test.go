@@ -0,0 +1,39 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamDelay(t *testing.T) {
+	tests := []struct {
+		roundTrip time.Duration
+		want      int
+	}{
+		{-time.Millisecond, 0},
+		{54 * time.Millisecond, 54},
+		{154*time.Millisecond + 900*time.Microsecond, 154},
+		{2 * time.Second, maxStreamDelayMs},
+	}
+	for _, tt := range tests {
+		if got := streamDelay(tt.roundTrip); got != tt.want {
+			t.Errorf("streamDelay(%v) = %d, want %d", tt.roundTrip, got, tt.want)
+		}
+	}
+}
+
+func TestCalibration_Valid(t *testing.T) {
+	if (Calibration{StreamDelayMs: 80, ERLE: 2}).Valid() {
+		t.Error("calibration with little echo removed is valid")
+	}
+	if !(Calibration{StreamDelayMs: 80, ERLE: 18}).Valid() {
+		t.Error("calibration with echo removed is invalid")
+	}
+	values := []float64{12, 3, 20, 18, 15}
+	if got := median(values); got != 15 {
+		t.Errorf("median = %v, want 15", got)
+	}
+	if values[0] != 12 {
+		t.Error("median sorted its input")
+	}
+}
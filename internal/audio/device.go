@@ -0,0 +1,213 @@
+package audio
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gen2brain/malgo"
+)
+
+// deviceCheckInterval is how often the devices are listed to find the
+// device of an open stream unplugged or the default device changing.
+const deviceCheckInterval = 2 * time.Second
+
+// errDeviceChanged asks stream to open its device again.
+var errDeviceChanged = errors.New("audio device changed")
+
+// Device is a capture or playback device as listed by the backend.
+type Device struct {
+	ID      malgo.DeviceID
+	Name    string
+	Default bool
+}
+
+// Devices lists the devices of kind, malgo.Capture or malgo.Playback.
+func Devices(mctx malgo.Context, kind malgo.DeviceType) ([]Device, error) {
+	infos, err := mctx.Devices(kind)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]Device, 0, len(infos))
+	for _, d := range infos {
+		devices = append(devices, Device{ID: d.ID, Name: strings.TrimSpace(d.Name()), Default: d.IsDefault != 0})
+	}
+	return devices, nil
+}
+
+// Selection is a device picked by the user, matched by ID and by name when
+// the ID changed, e.g. after the device was plugged into another port.
+// The zero Selection is the system default.
+type Selection struct {
+	ID   string
+	Name string
+}
+
+// Match returns the device of devices picked by s.
+func (s Selection) Match(devices []Device) (Device, bool) {
+	if s.ID != "" {
+		for _, d := range devices {
+			if d.ID.String() == s.ID {
+				return d, true
+			}
+		}
+	}
+	if s.Name != "" {
+		for _, d := range devices {
+			if d.Name == s.Name {
+				return d, true
+			}
+		}
+	}
+	return Device{}, false
+}
+
+// selected are the devices picked by the user, nil for the system default.
+var selected = map[malgo.DeviceType]*atomic.Pointer[Selection]{
+	malgo.Capture:  new(atomic.Pointer[Selection]),
+	malgo.Playback: new(atomic.Pointer[Selection]),
+}
+
+// SelectDevice makes streams of kind use the device picked by s. Streams
+// already open move to it.
+func SelectDevice(kind malgo.DeviceType, s Selection) {
+	selected[kind].Store(&s)
+}
+
+// SelectedDevice is the selection given to SelectDevice for kind.
+func SelectedDevice(kind malgo.DeviceType) Selection {
+	if s := selected[kind].Load(); s != nil {
+		return *s
+	}
+	return Selection{}
+}
+
+// openedDevice is what a stream opened, to tell when it should be opened again.
+type openedDevice struct {
+	kind malgo.DeviceType
+	// explicit streams were given their device in the config and stay on it
+	explicit bool
+	// selection was current when the stream opened, found if that device was there
+	selection Selection
+	found     bool
+	// id is the device opened, zero when the backend names no default
+	id malgo.DeviceID
+}
+
+// resolveDevice picks the device a stream of kind opens: the one in config
+// when set, else the selected one if it is plugged in, else the default.
+func resolveDevice(config *StreamConfig, kind malgo.DeviceType) openedDevice {
+	opened := openedDevice{kind: kind, selection: SelectedDevice(kind)}
+	id := &config.CaptureDeviceID
+	if kind == malgo.Playback {
+		id = &config.PlaybackDeviceID
+	}
+	if *id != nil {
+		opened.explicit, opened.id = true, **id
+		return opened
+	}
+	devices, err := Devices(config.MalgoContext, kind)
+	if err != nil {
+		return opened
+	}
+	if d, ok := opened.selection.Match(devices); ok {
+		opened.found, opened.id = true, d.ID
+		*id = &d.ID
+		return opened
+	}
+	for _, d := range devices {
+		if d.Default {
+			opened.id = d.ID
+		}
+	}
+	return opened
+}
+
+// changed reports whether the stream should be opened again, because its
+// device is gone, another one was selected or plugged in, or the default moved.
+func (o openedDevice) changed(devices []Device) bool {
+	if SelectedDevice(o.kind) != o.selection {
+		return true
+	}
+	if o.found {
+		return !slices.ContainsFunc(devices, func(d Device) bool { return d.ID == o.id })
+	}
+	if _, ok := o.selection.Match(devices); ok {
+		return true
+	}
+	var zero malgo.DeviceID
+	for _, d := range devices {
+		if d.Default {
+			return o.id != zero && d.ID != o.id
+		}
+	}
+	return false
+}
+
+// deviceWatch lists the devices once per check for all open streams and
+// tells those whose device changed.
+type deviceWatch struct {
+	streams map[chan struct{}]openedDevice
+	running bool
+	lock    sync.Mutex
+}
+
+var devicesWatch = &deviceWatch{streams: make(map[chan struct{}]openedDevice)}
+
+// add watches the device opened by a stream, the returned channel receives
+// when it should be opened again. remove stops watching it.
+func (w *deviceWatch) add(mctx malgo.Context, opened openedDevice) (changed <-chan struct{}, remove func()) {
+	ch := make(chan struct{}, 1)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.streams[ch] = opened
+	if !w.running {
+		w.running = true
+		go w.run(mctx)
+	}
+	return ch, func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		delete(w.streams, ch)
+	}
+}
+
+// run checks the devices until no stream is left to watch.
+func (w *deviceWatch) run(mctx malgo.Context) {
+	ticker := time.NewTicker(deviceCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.lock.Lock()
+		if len(w.streams) == 0 {
+			w.running = false
+			w.lock.Unlock()
+			return
+		}
+		streams := maps.Clone(w.streams)
+		w.lock.Unlock()
+		lists := make(map[malgo.DeviceType][]Device)
+		failed := make(map[malgo.DeviceType]bool)
+		for ch, opened := range streams {
+			devices, ok := lists[opened.kind]
+			if !ok && !failed[opened.kind] {
+				var err error
+				if devices, err = Devices(mctx, opened.kind); err != nil {
+					failed[opened.kind] = true
+				} else {
+					lists[opened.kind] = devices
+				}
+			}
+			if failed[opened.kind] || !opened.changed(devices) {
+				continue
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
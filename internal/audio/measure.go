@@ -148,7 +148,7 @@ func (m *LatencyMeasurer) measureRoundTripLatency(ctx context.Context) (time.Dur
 	})
 
 	if err != nil {
-		fmt.Errorf("failed to start capture: %v", err)
+		return 0, 0, fmt.Errorf("failed to start capture: %v", err)
 	}
 
 	for i := 0; i < m.maxAttempts; i++ {
@@ -0,0 +1,95 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+const (
+	// levelRingSize holds a little over 5s of 10ms levels
+	levelRingSize = 512
+	// clipLevel is the peak from which a frame counts as clipped
+	clipLevel = 0.99
+)
+
+// Level is the loudness of one 10ms frame.
+type Level struct {
+	RMS  float32
+	Peak float32
+}
+
+// DB is the RMS in dBFS.
+func (l Level) DB() float32 {
+	return linearToDb(l.RMS)
+}
+
+// Clipped reports whether the frame reached full scale.
+func (l Level) Clipped() bool {
+	return l.Peak >= clipLevel
+}
+
+// LevelRing passes levels from the audio callback to the UI without locks.
+// There is one writer, readers see the most recent levels and may miss
+// some when they fall more than the ring behind.
+type LevelRing struct {
+	slots [levelRingSize]atomic.Uint64
+	next  atomic.Uint64
+}
+
+// Push publishes l, it is called by the single writer only.
+func (r *LevelRing) Push(l Level) {
+	n := r.next.Load()
+	r.slots[n%levelRingSize].Store(uint64(math.Float32bits(l.RMS))<<32 | uint64(math.Float32bits(l.Peak)))
+	r.next.Store(n + 1)
+}
+
+// Count is how many levels were pushed since the last reset.
+func (r *LevelRing) Count() int {
+	return int(r.next.Load())
+}
+
+// Recent appends up to n of the latest levels to dst, oldest first.
+func (r *LevelRing) Recent(dst []Level, n int) []Level {
+	next := r.next.Load()
+	n = min(n, int(min(next, levelRingSize)))
+	for i := next - uint64(n); i < next; i++ {
+		v := r.slots[i%levelRingSize].Load()
+		dst = append(dst, Level{RMS: math.Float32frombits(uint32(v >> 32)), Peak: math.Float32frombits(uint32(v))})
+	}
+	return dst
+}
+
+// Reset forgets all levels, it must not race with Push.
+func (r *LevelRing) Reset() {
+	r.next.Store(0)
+}
+
+// Meter passes float32 samples through to a writer and publishes the level
+// of every 10ms of them, analysed by a Preamp.
+type Meter struct {
+	w      io.Writer
+	levels *LevelRing
+	preamp *Preamp
+	frame  []float32
+	size   int
+}
+
+// NewMeter meters the interleaved float32 samples written to w into levels.
+func NewMeter(w io.Writer, levels *LevelRing, sampleRate, channels int) *Meter {
+	size := sampleRate / 100 * channels
+	return &Meter{w: w, levels: levels, preamp: NewPreamp(), frame: make([]float32, 0, size), size: size}
+}
+
+func (m *Meter) Write(p []byte) (int, error) {
+	for i := 0; i+4 <= len(p); i += 4 {
+		m.frame = append(m.frame, math.Float32frombits(binary.LittleEndian.Uint32(p[i:])))
+		if len(m.frame) == m.size {
+			info := m.preamp.AnalyzePCM(m.frame)
+			m.levels.Push(Level{RMS: info.RMS, Peak: info.Peak})
+			m.frame = m.frame[:0]
+		}
+	}
+	return m.w.Write(p)
+}
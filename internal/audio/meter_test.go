@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestMeter(t *testing.T) {
+	var levels LevelRing
+	var buf bytes.Buffer
+	m := NewMeter(&buf, &levels, testRate, 1)
+	// 30ms quiet, 10ms at full scale, 5ms left over
+	samples := make([]float32, testRate*45/1000)
+	for i := range samples[:testRate*30/1000] {
+		samples[i] = 0.1 * float32(math.Sin(float64(i)/10))
+	}
+	for i := testRate * 30 / 1000; i < len(samples); i++ {
+		samples[i] = 1
+	}
+	data := ToBytes(samples)
+	// written in odd sized pieces as the device does
+	for i := 0; i < len(data); i += 1000 {
+		if _, err := m.Write(data[i:min(i+1000, len(data))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != len(data) {
+		t.Fatalf("all samples should be passed on, but %d of %d bytes", buf.Len(), len(data))
+	}
+	got := levels.Recent(nil, 10)
+	if len(got) != 4 {
+		t.Fatalf("should publish one level per whole 10ms, but %d", len(got))
+	}
+	if got[0].Clipped() || got[0].RMS < 0.05 || got[0].RMS > 0.08 || !got[3].Clipped() {
+		t.Errorf("levels should be quiet then clipped, but %+v", got)
+	}
+}
+
+func TestLevelRing(t *testing.T) {
+	var r LevelRing
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 3 * levelRingSize {
+			r.Push(Level{RMS: float32(i), Peak: float32(i)})
+		}
+	}()
+	for r.Count() < 3*levelRingSize {
+		for _, l := range r.Recent(nil, 50) {
+			if l.RMS != l.Peak {
+				t.Fatalf("a level should never be torn, but %+v", l)
+			}
+		}
+	}
+	wg.Wait()
+	recent := r.Recent(nil, 2*levelRingSize)
+	if len(recent) != levelRingSize || recent[len(recent)-1].RMS != 3*levelRingSize-1 {
+		t.Errorf("the ring should keep the latest %d levels, but %d ending %v", levelRingSize, len(recent), recent[len(recent)-1])
+	}
+}
@@ -0,0 +1,456 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+
+	"github.com/CoyAce/opus"
+	"github.com/CoyAce/opus/ogg/oggwriter"
+)
+
+const (
+	oggHeaderSize = 27
+	// oggContinued is set on pages that start with the rest of a packet
+	oggContinued = 0x01
+	// opusRate is the rate opus always decodes at here
+	opusRate = 48000
+	// opusMaxFrames is the longest opus packet, 120ms
+	opusMaxFrames = opusRate * 120 / 1000
+	// opusPreRoll is how much audio the decoder needs to converge after a seek, 80ms
+	opusPreRoll = opusRate * 80 / 1000
+	// opusFrame is the 20ms frame recordings are encoded in
+	opusFrame = opusRate * 20 / 1000
+	// opusMaxPacket is the largest packet opus produces
+	opusMaxPacket = 1275
+	// opusPagePackets is how many packets go in one page, about a second
+	opusPagePackets = 50
+)
+
+var (
+	errNotOgg  = errors.New("not an ogg stream")
+	errOggCRC  = errors.New("ogg page checksum mismatch")
+	errNotOpus = errors.New("not an opus stream")
+)
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return
+}()
+
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, v := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// OggReader splits an Ogg stream into its packets one page at a time,
+// packets may span pages.
+type OggReader struct {
+	r       io.Reader
+	header  [oggHeaderSize]byte
+	lacing  []byte
+	payload []byte
+	packet  []byte
+}
+
+func NewOggReader(r io.Reader) *OggReader {
+	return &OggReader{r: r}
+}
+
+// reset drops the page and packet in progress, after r was moved to a page boundary.
+func (o *OggReader) reset() {
+	o.lacing, o.payload, o.packet = nil, nil, o.packet[:0]
+}
+
+func (o *OggReader) nextPage() error {
+	if _, err := io.ReadFull(o.r, o.header[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(o.header[:4], []byte("OggS")) {
+		return errNotOgg
+	}
+	lacing := make([]byte, o.header[26])
+	if _, err := io.ReadFull(o.r, lacing); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	size := 0
+	for _, v := range lacing {
+		size += int(v)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(o.r, payload); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	want := binary.LittleEndian.Uint32(o.header[22:])
+	clear(o.header[22:26])
+	crc := oggCRC(oggCRC(oggCRC(0, o.header[:]), lacing), payload)
+	if crc != want {
+		return errOggCRC
+	}
+	o.lacing, o.payload = lacing, payload
+	if o.header[5]&oggContinued == 0 {
+		// a packet left unfinished by the previous page is lost
+		o.packet = o.packet[:0]
+	} else if len(o.packet) == 0 {
+		// the start of the continued packet was not read, e.g. after a seek
+		o.skipPacket()
+	}
+	return nil
+}
+
+func (o *OggReader) skipPacket() {
+	for len(o.lacing) > 0 {
+		n := int(o.lacing[0])
+		o.lacing, o.payload = o.lacing[1:], o.payload[n:]
+		if n < 255 {
+			return
+		}
+	}
+}
+
+// Packet returns the next packet, it is only valid until the next call.
+// io.EOF is returned at the end of the stream.
+func (o *OggReader) Packet() ([]byte, error) {
+	o.packet = o.packet[:0]
+	for {
+		for len(o.lacing) > 0 {
+			n := int(o.lacing[0])
+			o.lacing = o.lacing[1:]
+			o.packet = append(o.packet, o.payload[:n]...)
+			o.payload = o.payload[n:]
+			if n < 255 {
+				return o.packet, nil
+			}
+		}
+		if err := o.nextPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// oggPage is where a page starts and the frame its first packet starts at,
+// which is only known for pages that do not continue a packet.
+type oggPage struct {
+	offset    int64
+	frame     int
+	continued bool
+}
+
+// opusSamples is the length of an opus packet in 48kHz frames, read from its TOC byte.
+func opusSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+	var size int
+	switch {
+	case config < 12:
+		size = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		size = []int{480, 960}[config%2]
+	default:
+		size = []int{120, 240, 480, 960}[config%4]
+	}
+	switch toc & 3 {
+	case 0:
+		return size
+	case 1, 2:
+		return 2 * size
+	}
+	if len(packet) < 2 {
+		return 0
+	}
+	return int(packet[1]&0x3f) * size
+}
+
+// scanOpusPages lists the pages of r without decoding them. first is the
+// page the audio starts on after the two header packets, frames the length
+// of the audio. Granule positions are not used, writers get them wrong.
+func scanOpusPages(r io.ReadSeeker) (pages []oggPage, first, frames int, err error) {
+	var header [oggHeaderSize]byte
+	var lacing [255]byte
+	var offset int64
+	if offset, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	packets, first := 0, -1
+	// start is true between packets, the next segment begins one
+	start := true
+	var payload []byte
+	for {
+		if _, err = io.ReadFull(r, header[:]); err != nil {
+			break
+		}
+		if !bytes.Equal(header[:4], []byte("OggS")) {
+			return nil, 0, 0, errNotOgg
+		}
+		n := int(header[26])
+		if _, err = io.ReadFull(r, lacing[:n]); err != nil {
+			break
+		}
+		size := 0
+		for _, v := range lacing[:n] {
+			size += int(v)
+		}
+		payload = slices.Grow(payload[:0], size)[:size]
+		if _, err = io.ReadFull(r, payload); err != nil {
+			break
+		}
+		continued := header[5]&oggContinued != 0
+		// like the reader, an unfinished packet is dropped and so is the
+		// rest of a packet whose start was not seen
+		orphan := continued && start
+		if !continued {
+			start = true
+		}
+		pages = append(pages, oggPage{offset: offset, frame: frames, continued: continued})
+		if first < 0 && packets >= 2 && !continued {
+			first = len(pages) - 1
+		}
+		for _, v := range lacing[:n] {
+			if orphan {
+				orphan = v == 255
+				payload = payload[v:]
+				continue
+			}
+			if start && packets >= 2 {
+				frames += opusSamples(payload)
+			}
+			if start {
+				packets++
+			}
+			payload = payload[v:]
+			start = v < 255
+		}
+		offset += int64(oggHeaderSize + n + size)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// a truncated last page is ignored
+		err = nil
+	}
+	if first < 0 {
+		first = len(pages)
+	}
+	return pages, first, frames, err
+}
+
+// OpusReader decodes an Ogg Opus stream packet by packet, so that long
+// recordings are never held in memory at once. It can seek to any frame.
+type OpusReader struct {
+	r        io.ReadSeeker
+	ogg      *OggReader
+	dec      *opus.Decoder
+	channels int
+	pages    []oggPage
+	// first is the first page with audio, frames the length of the stream
+	first  int
+	frames int
+	// pos is the frame of the next decoded packet, target where output starts after a seek
+	pos    int
+	target int
+	decode []int16
+	buf    []int16
+}
+
+// NewOpusReader reads the headers of the Ogg Opus stream in r.
+func NewOpusReader(r io.ReadSeeker) (*OpusReader, error) {
+	pages, first, frames, err := scanOpusPages(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	o := &OpusReader{r: r, ogg: NewOggReader(r), pages: pages, first: first, frames: frames}
+	head, err := o.ogg.Packet()
+	if err != nil {
+		return nil, err
+	}
+	if len(head) < 19 || !bytes.HasPrefix(head, []byte("OpusHead")) || head[9] == 0 || head[9] > 2 {
+		return nil, errNotOpus
+	}
+	// the pre-skip in the header is not honoured, our own writer sets one its encoder does not have
+	o.channels = int(head[9])
+	o.decode = make([]int16, opusMaxFrames*o.channels)
+	return o, o.SeekFrame(0)
+}
+
+func (o *OpusReader) Channels() int {
+	return o.channels
+}
+
+func (o *OpusReader) SampleRate() int {
+	return opusRate
+}
+
+// Frames is the length of the stream in frames.
+func (o *OpusReader) Frames() int {
+	return o.frames
+}
+
+// SeekFrame moves to frame, decoding from a page far enough before it for
+// the decoder to converge.
+func (o *OpusReader) SeekFrame(frame int) error {
+	frame = max(0, min(frame, o.frames))
+	start, pos := o.first, 0
+	for i := o.first + 1; i < len(o.pages); i++ {
+		page := o.pages[i]
+		if page.frame > frame-opusPreRoll {
+			break
+		}
+		if !page.continued {
+			start, pos = i, page.frame
+		}
+	}
+	if start >= len(o.pages) {
+		o.pos, o.target, o.buf = o.frames, o.frames, nil
+		return nil
+	}
+	if _, err := o.r.Seek(o.pages[start].offset, io.SeekStart); err != nil {
+		return err
+	}
+	o.ogg.reset()
+	dec, err := opus.NewDecoder(opusRate, o.channels)
+	if err != nil {
+		return err
+	}
+	o.dec, o.pos, o.target, o.buf = dec, pos, frame, nil
+	return nil
+}
+
+// ReadPCM fills pcm with interleaved samples and returns how many it read,
+// io.EOF is returned at the end of the stream.
+func (o *OpusReader) ReadPCM(pcm []int16) (int, error) {
+	for len(o.buf) == 0 {
+		if o.pos >= o.frames {
+			return 0, io.EOF
+		}
+		packet, err := o.ogg.Packet()
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		if len(packet) == 0 {
+			continue
+		}
+		n, err := o.dec.Decode(packet, o.decode)
+		if err != nil {
+			return 0, err
+		}
+		start := o.pos
+		o.pos += n
+		from, to := max(o.target-start, 0), min(n, o.frames-start)
+		if from < to {
+			o.buf = o.decode[from*o.channels : to*o.channels]
+		}
+	}
+	n := copy(pcm[:len(pcm)/o.channels*o.channels], o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+// OpusWriter encodes PCM into an Ogg Opus stream as it arrives. Each page is
+// written once it holds about a second of audio, so a stream cut short by a
+// crash is readable up to its last whole page.
+type OpusWriter struct {
+	enc      *opus.Encoder
+	ogg      *oggwriter.OggWriter
+	channels int
+	// pcm holds the samples of a frame not yet complete
+	pcm     []int16
+	packets [][]byte
+	// samples is the length of packets, frames of all the audio written
+	samples int
+	frames  int
+}
+
+// NewOpusWriter writes the headers of a 48kHz Ogg Opus stream to w.
+func NewOpusWriter(w io.Writer, channels int) (*OpusWriter, error) {
+	enc, err := opus.NewEncoder(opusRate, channels, opus.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := oggwriter.NewWith(w, opusRate, uint16(channels))
+	if err != nil {
+		return nil, err
+	}
+	return &OpusWriter{enc: enc, ogg: writer, channels: channels, pcm: make([]int16, 0, opusFrame*channels)}, nil
+}
+
+// Frames is how much audio was written, in frames.
+func (o *OpusWriter) Frames() int {
+	return o.frames
+}
+
+// WritePCM encodes the interleaved samples in pcm, a partial frame is kept
+// for the next call.
+func (o *OpusWriter) WritePCM(pcm []int16) error {
+	o.frames += len(pcm) / o.channels
+	for len(pcm) > 0 {
+		n := min(len(pcm), cap(o.pcm)-len(o.pcm))
+		o.pcm, pcm = append(o.pcm, pcm[:n]...), pcm[n:]
+		if len(o.pcm) < cap(o.pcm) {
+			break
+		}
+		if err := o.encode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OpusWriter) encode() error {
+	packet := make([]byte, opusMaxPacket)
+	n, err := o.enc.Encode(o.pcm, packet)
+	if err != nil {
+		return err
+	}
+	o.pcm = o.pcm[:0]
+	o.packets = append(o.packets, packet[:n])
+	o.samples += opusFrame
+	if len(o.packets) < opusPagePackets {
+		return nil
+	}
+	return o.Flush()
+}
+
+// Flush writes the packets encoded so far as a page.
+func (o *OpusWriter) Flush() error {
+	if len(o.packets) == 0 {
+		return nil
+	}
+	err := o.ogg.Write(o.packets, uint32(o.samples))
+	o.packets, o.samples = nil, 0
+	return err
+}
+
+// Close encodes what is left, padded with silence to a whole frame, and
+// writes the last page. The underlying writer is not closed.
+func (o *OpusWriter) Close() error {
+	if len(o.pcm) > 0 {
+		n := len(o.pcm)
+		o.pcm = o.pcm[:cap(o.pcm)]
+		clear(o.pcm[n:])
+		if err := o.encode(); err != nil {
+			return err
+		}
+	}
+	return o.Flush()
+}
@@ -0,0 +1,138 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CoyAce/opus"
+	"github.com/CoyAce/opus/ogg"
+)
+
+func encodeOpus(t *testing.T, pcm []int16) []byte {
+	var buf bytes.Buffer
+	if err := ogg.NewEncoder(ogg.FrameSize, 1, opus.AppVoIP).Encode(&buf, ogg.ToBytes(pcm)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, r *OpusReader) []int16 {
+	var out []int16
+	buf := make([]int16, 1000)
+	for {
+		n, err := r.ReadPCM(buf)
+		out = append(out, buf[:n]...)
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpusReader(t *testing.T) {
+	data := encodeOpus(t, sine(300, 3*time.Second, 1))
+	want, _, err := ogg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewOpusReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Channels() != 1 || r.Frames() != len(want)/2 {
+		t.Fatalf("should be mono with %d frames, but %d channels %d frames", len(want)/2, r.Channels(), r.Frames())
+	}
+	if got := readAll(t, r); !slices.Equal(got, ogg.ToInts(want)) {
+		t.Fatalf("streamed decode should match the whole file decode")
+	}
+
+	// after a seek the decoder converges within the pre-roll
+	frame := testRate*3/2 + 123
+	if err = r.SeekFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(t, r)
+	if len(got) != r.Frames()-frame {
+		t.Fatalf("should read %d frames after the seek, but %d", r.Frames()-frame, len(got))
+	}
+	var diff float64
+	for i, v := range got[:testRate/10] {
+		diff = max(diff, math.Abs(float64(v)-float64(ogg.ToInts(want)[frame+i])))
+	}
+	if diff > 800 {
+		t.Errorf("audio after a seek should match, but differs by %v", diff)
+	}
+}
+
+func TestOpusReaderCorrupt(t *testing.T) {
+	data := encodeOpus(t, sine(300, time.Second, 1))
+	if _, err := NewOpusReader(bytes.NewReader([]byte("not ogg at all, not at all"))); err == nil {
+		t.Errorf("garbage should not open")
+	}
+	data[len(data)-10] ^= 0xff
+	r, err := NewOpusReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]int16, 1000)
+	for err == nil {
+		_, err = r.ReadPCM(buf)
+	}
+	if !errors.Is(err, errOggCRC) {
+		t.Errorf("a damaged page should fail its checksum, but %v", err)
+	}
+}
+
+func TestRecordEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewOpusWriter(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processed := 0
+	e := NewRecordEncoder(w, func(frame []float32) ([]float32, error) {
+		processed += len(frame)
+		return frame, nil
+	})
+	pcm := Int16ToFloat32(sine(300, 2500*time.Millisecond+5*time.Millisecond, 1))
+	data := ToBytes(pcm)
+	// written in odd sized pieces as the device does
+	for i := 0; i < len(data); i += 1444 {
+		if _, err = e.Write(data[i:min(i+1444, len(data))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Frames() != len(pcm) || processed != len(pcm)/FrameSize*FrameSize {
+		t.Fatalf("all %d frames should be encoded, but %d, %d processed", len(pcm), w.Frames(), processed)
+	}
+	r, err := NewOpusReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last frame is padded with silence
+	if want := (len(pcm) + opusFrame - 1) / opusFrame * opusFrame; r.Frames() != want {
+		t.Fatalf("should read back %d frames, but %d", want, r.Frames())
+	}
+	if f := frequency(readAll(t, r), 1); math.Abs(f-300) > 5 {
+		t.Errorf("the tone should survive encoding, but %v Hz", f)
+	}
+
+	// a stream cut off mid page keeps its whole pages
+	r, err = NewOpusReader(bytes.NewReader(buf.Bytes()[:buf.Len()-100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readAll(t, r)); got != 2*testRate {
+		t.Errorf("the first two pages should be readable, but %d frames", got)
+	}
+}
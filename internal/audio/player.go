@@ -0,0 +1,240 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Source is interleaved 16-bit PCM of known length that can be read from any frame.
+type Source interface {
+	Channels() int
+	SampleRate() int
+	Frames() int
+	SeekFrame(frame int) error
+	// ReadPCM reads whole frames into pcm, io.EOF is returned at the end
+	ReadPCM(pcm []int16) (int, error)
+}
+
+// pcmSource is a Source in memory.
+type pcmSource struct {
+	pcm        []int16
+	channels   int
+	sampleRate int
+	cursor     int
+}
+
+// NewPCMSource reads pcm, e.g. a recording that was not encoded yet.
+func NewPCMSource(pcm []int16, channels, sampleRate int) Source {
+	return &pcmSource{pcm: pcm, channels: channels, sampleRate: sampleRate}
+}
+
+func (s *pcmSource) Channels() int {
+	return s.channels
+}
+
+func (s *pcmSource) SampleRate() int {
+	return s.sampleRate
+}
+
+func (s *pcmSource) Frames() int {
+	return len(s.pcm) / s.channels
+}
+
+func (s *pcmSource) SeekFrame(frame int) error {
+	s.cursor = max(0, min(frame, s.Frames())) * s.channels
+	return nil
+}
+
+func (s *pcmSource) ReadPCM(pcm []int16) (int, error) {
+	if s.cursor >= len(s.pcm) {
+		return 0, io.EOF
+	}
+	n := copy(pcm[:len(pcm)/s.channels*s.channels], s.pcm[s.cursor:])
+	s.cursor += n
+	return n, nil
+}
+
+// Levels reads src from the start and returns the RMS of each of bars equal parts.
+func Levels(src Source, bars int) ([]float32, error) {
+	frames, channels := src.Frames(), src.Channels()
+	sums := make([]float64, bars)
+	counts := make([]int, bars)
+	if err := src.SeekFrame(0); err != nil {
+		return nil, err
+	}
+	buf := make([]int16, src.SampleRate()/10*channels)
+	frame := 0
+	for {
+		n, err := src.ReadPCM(buf)
+		for i, v := range buf[:n] {
+			bar := min((frame+i/channels)*bars/max(frames, 1), bars-1)
+			x := float64(v) / 32768
+			sums[bar] += x * x
+			counts[bar]++
+		}
+		frame += n / channels
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	levels := make([]float32, bars)
+	for i := range levels {
+		if counts[i] > 0 {
+			levels[i] = float32(math.Sqrt(sums[i] / float64(counts[i])))
+		}
+	}
+	return levels, nil
+}
+
+// Player plays a Source from any position and at any speed, it is the
+// reader handed to Playback. The position advances with the samples the
+// device pulls rather than with the wall clock.
+type Player struct {
+	src        Source
+	channels   int
+	sampleRate int
+	speed      float64
+	stretcher  *Stretcher
+	// cursor is the next input frame to feed, played the input frames pulled by the device
+	cursor  int
+	played  float64
+	input   []int16
+	pending []int16
+	flushed bool
+	lock    sync.Mutex
+}
+
+// NewPlayer plays src from the start at speed 1.
+func NewPlayer(src Source) *Player {
+	return &Player{
+		src:        src,
+		channels:   src.Channels(),
+		sampleRate: src.SampleRate(),
+		speed:      1,
+		stretcher:  NewStretcher(src.SampleRate(), src.Channels()),
+		input:      make([]int16, src.SampleRate()/100*src.Channels()),
+	}
+}
+
+func (p *Player) frames() int {
+	return p.src.Frames()
+}
+
+func (p *Player) toDuration(frames float64) time.Duration {
+	return time.Duration(frames * float64(time.Second) / float64(p.sampleRate))
+}
+
+// Duration is the length of the audio at speed 1.
+func (p *Player) Duration() time.Duration {
+	return p.toDuration(float64(p.frames()))
+}
+
+// Position is how much of the audio was played, at speed 1.
+func (p *Player) Position() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.toDuration(p.played)
+}
+
+// Seek moves playback to d, clamped to the audio.
+func (p *Player) Seek(d time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	frame := int(d.Seconds() * float64(p.sampleRate))
+	p.restart(max(0, min(frame, p.frames())))
+}
+
+// SetSpeed changes the tempo, the pitch is kept.
+func (p *Player) SetSpeed(speed float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if speed == p.speed {
+		return
+	}
+	p.speed = speed
+	p.stretcher.SetSpeed(speed)
+	// what was stretched at the old speed is dropped and stretched again
+	p.restart(int(p.played))
+}
+
+func (p *Player) restart(frame int) {
+	if err := p.src.SeekFrame(frame); err != nil {
+		log.Printf("seek audio failed: %v", err)
+	}
+	p.cursor = frame
+	p.played = float64(frame)
+	p.pending = p.pending[:0]
+	p.flushed = false
+	p.stretcher.Reset()
+}
+
+// Read fills b with little endian samples, io.EOF is returned once everything was played.
+func (p *Player) Read(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	want := len(b) / 2 / p.channels * p.channels
+	if p.speed == 1 {
+		n, err := p.read(b, want)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		p.played = float64(p.cursor)
+		return p.done(n)
+	}
+	for len(p.pending) < want && !p.flushed {
+		n, err := p.src.ReadPCM(p.input)
+		if n > 0 {
+			p.pending = p.stretcher.Process(p.input[:n], p.pending)
+			p.cursor += n / p.channels
+		} else if errors.Is(err, io.EOF) {
+			p.pending = p.stretcher.Flush(p.pending)
+			p.flushed = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := min(want, len(p.pending))
+	p.put(b, p.pending[:n])
+	p.pending = p.pending[:copy(p.pending, p.pending[n:])]
+	p.played = min(p.played+float64(n/p.channels)*p.speed, float64(p.frames()))
+	if p.flushed && len(p.pending) == 0 {
+		p.played = float64(p.frames())
+	}
+	return p.done(n)
+}
+
+// read copies up to want samples from the source straight into b.
+func (p *Player) read(b []byte, want int) (int, error) {
+	n := 0
+	for n < want {
+		m, err := p.src.ReadPCM(p.input[:min(len(p.input), want-n)])
+		p.put(b[n*2:], p.input[:m])
+		n += m
+		p.cursor += m / p.channels
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (p *Player) put(b []byte, samples []int16) {
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(b[i*2:], uint16(v))
+	}
+}
+
+func (p *Player) done(samples int) (int, error) {
+	if samples == 0 {
+		return 0, io.EOF
+	}
+	return samples * 2, nil
+}
@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math"
+	"sync/atomic"
+)
+
+// recordBacklog is how many capture callbacks may wait for the encoder, more
+// are dropped rather than stalling the capture.
+const recordBacklog = 500
+
+// RecordEncoder takes the float32 samples of a recording as an io.Writer and
+// enhances and encodes them on its own goroutine, the capture callback only
+// copies them.
+type RecordEncoder struct {
+	w       *OpusWriter
+	process func([]float32) ([]float32, error)
+	chunks  chan []byte
+	done    chan error
+	dropped atomic.Int64
+}
+
+// NewRecordEncoder encodes what is written to it into w, passing every 10ms
+// through process first when it is not nil.
+func NewRecordEncoder(w *OpusWriter, process func([]float32) ([]float32, error)) *RecordEncoder {
+	e := &RecordEncoder{w: w, process: process, chunks: make(chan []byte, recordBacklog), done: make(chan error, 1)}
+	go e.run()
+	return e
+}
+
+// Write never blocks, p is dropped when the encoder is too far behind.
+// It must not be called after Close.
+func (e *RecordEncoder) Write(p []byte) (int, error) {
+	select {
+	case e.chunks <- bytes.Clone(p):
+	default:
+		e.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Dropped is how many writes were dropped so far.
+func (e *RecordEncoder) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+// Close waits until everything written is encoded and closes the OpusWriter.
+func (e *RecordEncoder) Close() error {
+	close(e.chunks)
+	return <-e.done
+}
+
+func (e *RecordEncoder) run() {
+	frame := make([]float32, 0, FrameSize*e.w.channels)
+	var err error
+	for chunk := range e.chunks {
+		// after a failure the rest is drained
+		for i := 0; err == nil && i+4 <= len(chunk); i += 4 {
+			frame = append(frame, math.Float32frombits(binary.LittleEndian.Uint32(chunk[i:])))
+			if len(frame) == cap(frame) {
+				err = e.encode(frame)
+				frame = frame[:0]
+			}
+		}
+	}
+	if err == nil && len(frame) > 0 {
+		// too short for the enhancer, it is encoded as it is
+		err = e.w.WritePCM(Float32ToInt16(frame))
+	}
+	if err == nil {
+		err = e.w.Close()
+	}
+	if n := e.dropped.Load(); n > 0 {
+		log.Printf("encoder fell behind, %d chunks dropped", n)
+	}
+	e.done <- err
+}
+
+func (e *RecordEncoder) encode(frame []float32) error {
+	if e.process != nil {
+		processed, err := e.process(frame)
+		if err != nil {
+			log.Printf("process audio failed, %s", err)
+		} else {
+			frame = processed
+		}
+	}
+	return e.w.WritePCM(Float32ToInt16(frame))
+}
@@ -0,0 +1,200 @@
+package audio
+
+import "math"
+
+const (
+	// stretchSegment is the length of the windowed segments, long enough to
+	// hold a couple of pitch periods of a low voice.
+	stretchSegment = 30
+	// stretchTolerance is how far a segment may move to line up with the last one.
+	stretchTolerance = 10
+)
+
+// Stretcher changes the tempo of interleaved PCM without changing its pitch.
+// It implements WSOLA: segments are taken from the input every hop*speed
+// samples, each moved within a tolerance to where it best continues the
+// previous one, and overlap-added every hop samples.
+type Stretcher struct {
+	channels  int
+	hop       int
+	tolerance int
+	speed     float64
+	window    []float32
+	// buf holds interleaved input frames starting at frame offset
+	buf    []float32
+	offset int
+	// pos is the nominal start of the next segment, last the start of the previous one
+	pos  float64
+	last int
+	// tail is the second half of the previous segment, weighted by the window
+	tail []float32
+}
+
+// NewStretcher returns a stretcher for sampleRate and channels at speed 1.
+func NewStretcher(sampleRate, channels int) *Stretcher {
+	hop := sampleRate * stretchSegment / 1000 / 2
+	s := &Stretcher{
+		channels:  channels,
+		hop:       hop,
+		tolerance: sampleRate * stretchTolerance / 1000,
+		speed:     1,
+		window:    make([]float32, 2*hop),
+	}
+	// periodic Hann windows overlapping by half add up to one
+	for i := range s.window {
+		s.window[i] = float32(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(2*hop)))
+	}
+	s.Reset()
+	return s
+}
+
+// Speed returns the tempo factor, 2 plays twice as fast.
+func (s *Stretcher) Speed() float64 {
+	return s.speed
+}
+
+// SetSpeed sets the tempo factor, buffered input is kept.
+func (s *Stretcher) SetSpeed(speed float64) {
+	s.speed = speed
+}
+
+// Reset drops buffered input, for seeking.
+func (s *Stretcher) Reset() {
+	s.buf = s.buf[:0]
+	s.offset, s.pos, s.last = 0, 0, 0
+	s.tail = s.tail[:0]
+}
+
+// Buffered is how many input frames were written but not played yet.
+func (s *Stretcher) Buffered() int {
+	return s.offset + len(s.buf)/s.channels - int(s.pos)
+}
+
+// Process takes interleaved input frames and appends the output frames that are ready to out.
+func (s *Stretcher) Process(in []int16, out []int16) []int16 {
+	for _, v := range in {
+		s.buf = append(s.buf, float32(v)/32768)
+	}
+	segment := 2 * s.hop
+	for {
+		nominal := int(s.pos)
+		// the segment may start tolerance later and must be whole, as must the continuation of the last one
+		need := max(nominal+s.tolerance+segment, s.last+s.hop+segment)
+		if need > s.offset+len(s.buf)/s.channels {
+			break
+		}
+		start := s.best(nominal)
+		out = s.overlap(start, out)
+		s.last = start
+		s.pos += float64(s.hop) * s.speed
+		s.trim()
+	}
+	return out
+}
+
+// best finds the start near nominal whose first half is most like the
+// natural continuation of the previous segment.
+func (s *Stretcher) best(nominal int) int {
+	if len(s.tail) == 0 {
+		return nominal
+	}
+	target := s.last + s.hop
+	lo, hi := max(nominal-s.tolerance, s.offset), nominal+s.tolerance
+	// coarse search on every other sample and offset, then refine around the peak
+	best, score := nominal, math.Inf(-1)
+	for c := lo; c <= hi; c += 2 {
+		if v := s.correlate(c, target, 2); v > score {
+			best, score = c, v
+		}
+	}
+	coarse := best
+	score = s.correlate(coarse, target, 1)
+	for _, c := range []int{coarse - 1, coarse + 1} {
+		if c < lo || c > hi {
+			continue
+		}
+		if v := s.correlate(c, target, 1); v > score {
+			best, score = c, v
+		}
+	}
+	return best
+}
+
+// correlate is the normalised cross-correlation of the channel sums over hop frames.
+func (s *Stretcher) correlate(a, b, stride int) float64 {
+	var dot, ea, eb float64
+	ia, ib := (a-s.offset)*s.channels, (b-s.offset)*s.channels
+	for i := 0; i < s.hop; i += stride {
+		var x, y float32
+		for ch := 0; ch < s.channels; ch++ {
+			x += s.buf[ia+i*s.channels+ch]
+			y += s.buf[ib+i*s.channels+ch]
+		}
+		dot += float64(x * y)
+		ea += float64(x * x)
+		eb += float64(y * y)
+	}
+	if ea == 0 || eb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(ea*eb)
+}
+
+// overlap adds the first half of the segment at start to the tail of the
+// previous one, outputs hop frames and keeps the second half as the new tail.
+func (s *Stretcher) overlap(start int, out []int16) []int16 {
+	n := s.hop * s.channels
+	i := (start - s.offset) * s.channels
+	if len(s.tail) == 0 {
+		// the first segment fades in from itself, so that output starts unchanged
+		s.tail = make([]float32, n)
+		for k := range s.tail {
+			s.tail[k] = s.buf[i+k] * (1 - s.window[k/s.channels])
+		}
+	}
+	for f := 0; f < s.hop; f++ {
+		w := s.window[f]
+		for ch := 0; ch < s.channels; ch++ {
+			k := f*s.channels + ch
+			out = append(out, toInt16(s.tail[k]+s.buf[i+k]*w))
+		}
+	}
+	for f := 0; f < s.hop; f++ {
+		w := s.window[s.hop+f]
+		for ch := 0; ch < s.channels; ch++ {
+			k := f*s.channels + ch
+			s.tail[k] = s.buf[i+n+k] * w
+		}
+	}
+	return out
+}
+
+// Flush returns the fading tail of the last segment at the end of the input
+// and resets the stretcher.
+func (s *Stretcher) Flush(out []int16) []int16 {
+	for _, v := range s.tail {
+		out = append(out, toInt16(v))
+	}
+	s.Reset()
+	return out
+}
+
+// trim drops input no later segment can start in.
+func (s *Stretcher) trim() {
+	keep := min(int(s.pos)-s.tolerance, s.last+s.hop)
+	if drop := keep - s.offset; drop > 0 {
+		n := copy(s.buf, s.buf[drop*s.channels:])
+		s.buf = s.buf[:n]
+		s.offset = keep
+	}
+}
+
+func toInt16(v float32) int16 {
+	switch {
+	case v >= 1:
+		return math.MaxInt16
+	case v <= -1:
+		return math.MinInt16
+	}
+	return int16(v * 32767)
+}
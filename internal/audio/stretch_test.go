@@ -0,0 +1,110 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+const testRate = 48000
+
+func sine(freq float64, d time.Duration, channels int) []int16 {
+	n := int(d.Seconds() * testRate)
+	pcm := make([]int16, n*channels)
+	for i := 0; i < n; i++ {
+		v := int16(8000 * math.Sin(2*math.Pi*freq*float64(i)/testRate))
+		for ch := 0; ch < channels; ch++ {
+			pcm[i*channels+ch] = v
+		}
+	}
+	return pcm
+}
+
+// frequency estimates the pitch of the first channel from its zero crossings.
+func frequency(pcm []int16, channels int) float64 {
+	crossings := 0
+	for i := channels; i < len(pcm); i += channels {
+		if (pcm[i-channels] < 0) != (pcm[i] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / (float64(len(pcm)/channels) / testRate)
+}
+
+func TestStretcherUnchangedAtSpeedOne(t *testing.T) {
+	in := sine(220, time.Second, 1)
+	s := NewStretcher(testRate, 1)
+	var out []int16
+	for i := 0; i < len(in); i += 480 {
+		out = s.Process(in[i:min(i+480, len(in))], out)
+	}
+	if len(out) < len(in)*9/10 {
+		t.Fatalf("want most of %d samples, but %d", len(in), len(out))
+	}
+	for i, v := range out {
+		if d := int(v) - int(in[i]); d < -2 || d > 2 {
+			t.Fatalf("sample %d should be %d, but %d", i, in[i], v)
+		}
+	}
+}
+
+func TestStretcherKeepsPitch(t *testing.T) {
+	for _, speed := range []float64{1.5, 2} {
+		in := sine(300, 2*time.Second, 2)
+		s := NewStretcher(testRate, 2)
+		s.SetSpeed(speed)
+		out := s.Flush(s.Process(in, nil))
+		ratio := float64(len(in)) / float64(len(out))
+		if math.Abs(ratio-speed) > 0.05*speed {
+			t.Errorf("speed %v should shorten by %v, but %.2f", speed, speed, ratio)
+		}
+		if f := frequency(out, 2); math.Abs(f-300) > 10 {
+			t.Errorf("speed %v should keep 300Hz, but %.1fHz", speed, f)
+		}
+	}
+}
+
+func TestPlayerPosition(t *testing.T) {
+	p := NewPlayer(NewPCMSource(sine(200, 4*time.Second, 1), 1, testRate))
+	if p.Duration() != 4*time.Second {
+		t.Fatalf("duration should be 4s, but %v", p.Duration())
+	}
+	buf := make([]byte, testRate/10*2)
+	p.Seek(time.Second)
+	p.SetSpeed(2)
+	for range 5 {
+		if _, err := p.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 500ms of output at twice the speed
+	if pos := p.Position(); pos < 1900*time.Millisecond || pos > 2100*time.Millisecond {
+		t.Errorf("position should be about 2s, but %v", pos)
+	}
+	if v := int16(binary.LittleEndian.Uint16(buf[2:])); v == 0 {
+		t.Errorf("player should output audio")
+	}
+	var err error
+	for err == nil {
+		_, err = p.Read(buf)
+	}
+	if !errors.Is(err, io.EOF) || p.Position() != p.Duration() {
+		t.Errorf("player should end at %v with EOF, but %v %v", p.Duration(), p.Position(), err)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	pcm := append(sine(200, time.Second, 2), make([]int16, testRate*2)...)
+	levels, err := Levels(NewPCMSource(pcm, 2, testRate), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sine of amplitude 8000 has an rms of 8000/sqrt(2)
+	want := float32(8000 / math.Sqrt2 / 32768)
+	if math.Abs(float64(levels[0]-want)) > 0.01 || math.Abs(float64(levels[1]-want)) > 0.01 || levels[2] != 0 || levels[3] != 0 {
+		t.Errorf("levels should be loud then silent, but %v", levels)
+	}
+}
@@ -269,11 +269,13 @@ func formatResult(result LatencyResult) string {
 	return fmt.Sprintf(`=== 音频延迟测量结果 ===
 测量时间: %s
   往返延迟: %.2fms
+  回声延迟: %dms
 置信度: %.2f
 测量样本数: %d
 =========================`,
 		result.Timestamp.Format("15:04:05"),
 		result.RoundTripLatency.Seconds()*1000,
+		streamDelay(result.RoundTripLatency),
 		result.Confidence,
 		result.SampleCount)
 }
@@ -0,0 +1,316 @@
+// Package cache keeps decoded media in memory under a byte budget.
+package cache
+
+import "sync"
+
+// maxFreq caps the access counter, entries are reinserted at most this often.
+const maxFreq = 3
+
+// Stats is a snapshot of the counters of a Cache.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Cost      int64
+	Capacity  int64
+}
+
+// HitRatio is the share of lookups that found their entry.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	cost       int64
+	freq       uint8
+	main       bool
+	prev, next *entry[K, V]
+}
+
+// queue is a FIFO of entries, new entries are pushed at the head.
+type queue[K comparable, V any] struct {
+	root entry[K, V]
+	cost int64
+	len  int
+}
+
+func (q *queue[K, V]) init() {
+	q.root.next, q.root.prev = &q.root, &q.root
+}
+
+func (q *queue[K, V]) push(e *entry[K, V]) {
+	e.prev, e.next = &q.root, q.root.next
+	q.root.next.prev = e
+	q.root.next = e
+	q.cost += e.cost
+	q.len++
+}
+
+func (q *queue[K, V]) remove(e *entry[K, V]) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = nil, nil
+	q.cost -= e.cost
+	q.len--
+}
+
+func (q *queue[K, V]) tail() *entry[K, V] {
+	if q.len == 0 {
+		return nil
+	}
+	return q.root.prev
+}
+
+// Cache is a concurrency-safe cache bounded by the total cost of its entries.
+// It evicts with S3-FIFO: new entries wait in a small queue, those hit again
+// before leaving it move to the main queue, and the keys of the others are
+// remembered in a ghost queue so that they go straight to main on return.
+// One-off scans therefore never push out the entries in regular use.
+type Cache[K comparable, V any] struct {
+	capacity int64
+	items    map[K]*entry[K, V]
+	small    queue[K, V]
+	main     queue[K, V]
+	ghost    map[K]uint64
+	ghosts   []ghostKey[K]
+	ghostSeq uint64
+	stats    Stats
+	lock     sync.Mutex
+}
+
+type ghostKey[K comparable] struct {
+	key K
+	seq uint64
+}
+
+// New creates a cache holding entries of up to capacity total cost.
+func New[K comparable, V any](capacity int64) *Cache[K, V] {
+	c := &Cache[K, V]{
+		capacity: capacity,
+		items:    make(map[K]*entry[K, V]),
+		ghost:    make(map[K]uint64),
+	}
+	c.small.init()
+	c.main.init()
+	return c
+}
+
+// Get returns the value stored for key and marks it as used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var zero V
+		return zero, false
+	}
+	c.stats.Hits++
+	if e.freq < maxFreq {
+		e.freq++
+	}
+	return e.value, true
+}
+
+// Peek returns the value stored for key without counting it as a use.
+func (c *Cache[K, V]) Peek(key K) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.items[key]; ok {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores value under key, replacing the previous value and cost if any.
+// An entry costing more than the capacity is kept until anything else is added.
+func (c *Cache[K, V]) Set(key K, value V, cost int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.value = value
+		c.resize(e, cost)
+		c.evict(e)
+		return
+	}
+	e := &entry[K, V]{key: key, value: value, cost: cost}
+	if _, ok := c.ghost[key]; ok {
+		delete(c.ghost, key)
+		e.main = true
+		c.main.push(e)
+	} else {
+		c.small.push(e)
+	}
+	c.items[key] = e
+	c.evict(e)
+}
+
+// SetCost updates the cost of an entry whose value was filled in after Set,
+// it returns false when the entry is gone.
+func (c *Cache[K, V]) SetCost(key K, cost int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.resize(e, cost)
+	c.evict(e)
+	return true
+}
+
+// Delete removes key from the cache.
+func (c *Cache[K, V]) Delete(key K) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.queueOf(e).remove(e)
+		delete(c.items, key)
+	}
+}
+
+// DeleteIf removes key only while match accepts its value, so a late
+// cleanup does not drop an entry that was stored again meanwhile.
+func (c *Cache[K, V]) DeleteIf(key K, match func(V) bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.items[key]
+	if !ok || !match(e.value) {
+		return false
+	}
+	c.queueOf(e).remove(e)
+	delete(c.items, key)
+	return true
+}
+
+// Reset drops every entry, the counters are kept.
+func (c *Cache[K, V]) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = make(map[K]*entry[K, V])
+	c.ghost = make(map[K]uint64)
+	c.ghosts = nil
+	c.small = queue[K, V]{}
+	c.main = queue[K, V]{}
+	c.small.init()
+	c.main.init()
+}
+
+// Stats returns a snapshot of the counters.
+func (c *Cache[K, V]) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := c.stats
+	s.Entries = len(c.items)
+	s.Cost = c.small.cost + c.main.cost
+	s.Capacity = c.capacity
+	return s
+}
+
+func (c *Cache[K, V]) queueOf(e *entry[K, V]) *queue[K, V] {
+	if e.main {
+		return &c.main
+	}
+	return &c.small
+}
+
+func (c *Cache[K, V]) resize(e *entry[K, V], cost int64) {
+	c.queueOf(e).cost += cost - e.cost
+	e.cost = cost
+}
+
+// evict makes room until the cost fits the capacity, keep is never evicted.
+func (c *Cache[K, V]) evict(keep *entry[K, V]) {
+	for c.small.cost+c.main.cost > c.capacity {
+		var ok bool
+		// the small queue gets a tenth of the capacity
+		if c.small.cost > c.capacity/10 || c.main.len == 0 {
+			ok = c.evictSmall(keep)
+		} else {
+			ok = c.evictMain(keep)
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// evictSmall moves the oldest small entry to main if it was hit, otherwise
+// it is dropped and its key remembered.
+func (c *Cache[K, V]) evictSmall(keep *entry[K, V]) bool {
+	e := c.small.tail()
+	if e == nil {
+		return c.evictMain(keep)
+	}
+	c.small.remove(e)
+	if e.freq > 0 || e == keep {
+		e.freq = 0
+		e.main = true
+		c.main.push(e)
+		return c.small.len > 0 || c.main.len > 1
+	}
+	c.drop(e)
+	c.remember(e.key)
+	return true
+}
+
+// evictMain gives the oldest main entry another round per hit, then drops it.
+func (c *Cache[K, V]) evictMain(keep *entry[K, V]) bool {
+	// every entry is reinserted at most maxFreq times, so this terminates
+	for c.main.len > 0 {
+		e := c.main.tail()
+		if e == keep {
+			if c.main.len == 1 {
+				break
+			}
+			c.main.remove(e)
+			c.main.push(e)
+			continue
+		}
+		c.main.remove(e)
+		if e.freq > 0 {
+			e.freq--
+			c.main.push(e)
+			continue
+		}
+		c.drop(e)
+		return true
+	}
+	return c.small.len > 0 && c.evictSmall(keep)
+}
+
+func (c *Cache[K, V]) drop(e *entry[K, V]) {
+	delete(c.items, e.key)
+	c.stats.Evictions++
+}
+
+// remember adds key to the ghost queue, which holds as many keys as there are entries.
+func (c *Cache[K, V]) remember(key K) {
+	c.ghostSeq++
+	c.ghost[key] = c.ghostSeq
+	c.ghosts = append(c.ghosts, ghostKey[K]{key: key, seq: c.ghostSeq})
+	limit := max(len(c.items), 16)
+	for len(c.ghost) > limit && len(c.ghosts) > 0 {
+		g := c.ghosts[0]
+		c.ghosts = c.ghosts[1:]
+		if c.ghost[g.key] == g.seq {
+			delete(c.ghost, g.key)
+		}
+	}
+	// drop keys that were readmitted so the slice does not outgrow the map
+	if len(c.ghosts) > 2*limit {
+		live := c.ghosts[:0]
+		for _, g := range c.ghosts {
+			if c.ghost[g.key] == g.seq {
+				live = append(live, g)
+			}
+		}
+		c.ghosts = append([]ghostKey[K](nil), live...)
+	}
+}
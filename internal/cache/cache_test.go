@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCacheCost(t *testing.T) {
+	c := New[string, int](100)
+	for i := 0; i < 50; i++ {
+		c.Set(fmt.Sprint(i), i, 10)
+		if s := c.Stats(); s.Cost > 100 {
+			t.Fatalf("cost should stay within 100, but %d", s.Cost)
+		}
+	}
+	if v, ok := c.Get("49"); !ok || v != 49 {
+		t.Errorf("the newest entry should be cached, but %v %v", v, ok)
+	}
+	if _, ok := c.Get("0"); ok {
+		t.Errorf("the oldest entry should be evicted")
+	}
+	s := c.Stats()
+	if s.Entries != 10 || s.Evictions != 40 || s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestCacheSetCost(t *testing.T) {
+	c := New[string, int](100)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	if !c.SetCost("a", 60) || !c.SetCost("b", 60) {
+		t.Fatalf("entries should still be cached")
+	}
+	if s := c.Stats(); s.Cost != 60 || s.Entries != 1 {
+		t.Errorf("one entry of cost 60 should be left, but %+v", s)
+	}
+	if _, ok := c.Peek("b"); !ok {
+		t.Errorf("the resized entry should be kept")
+	}
+	c.Set("huge", 3, 500)
+	if _, ok := c.Peek("huge"); !ok {
+		t.Errorf("an entry larger than the capacity should be kept alone")
+	}
+	if s := c.Stats(); s.Entries != 1 {
+		t.Errorf("only the large entry should be left, but %d", s.Entries)
+	}
+}
+
+func TestCacheScanResistance(t *testing.T) {
+	c := New[string, int](100)
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprint("hot", i), i, 10)
+	}
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 5; i++ {
+			c.Get(fmt.Sprint("hot", i))
+		}
+		for i := 0; i < 10; i++ {
+			c.Set(fmt.Sprint("scan", round, i), i, 10)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := c.Peek(fmt.Sprint("hot", i)); !ok {
+			t.Errorf("hot%d should survive the scan", i)
+		}
+	}
+}
+
+func TestCacheGhost(t *testing.T) {
+	c := New[string, int](100)
+	c.Set("a", 1, 10)
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint(i), i, 10)
+	}
+	if _, ok := c.Peek("a"); ok {
+		t.Fatalf("a should be evicted from the small queue")
+	}
+	c.Set("a", 1, 10)
+	if e := c.items["a"]; e == nil || !e.main {
+		t.Errorf("a returning from the ghost queue should go to main")
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	c := New[string, int](100)
+	c.Set("a", 1, 30)
+	c.Set("b", 2, 30)
+	c.Delete("a")
+	c.Delete("missing")
+	if s := c.Stats(); s.Cost != 30 || s.Entries != 1 {
+		t.Errorf("one entry of cost 30 should be left, but %+v", s)
+	}
+	c.Reset()
+	if s := c.Stats(); s.Cost != 0 || s.Entries != 0 {
+		t.Errorf("reset should drop every entry, but %+v", s)
+	}
+}
+
+func TestCacheDeleteIf(t *testing.T) {
+	c := New[string, int](100)
+	c.Set("a", 1, 30)
+	c.Set("a", 2, 30)
+	if c.DeleteIf("a", func(v int) bool { return v == 1 }) {
+		t.Errorf("a replaced value should be kept")
+	}
+	if !c.DeleteIf("a", func(v int) bool { return v == 2 }) {
+		t.Errorf("the matching value should be deleted")
+	}
+	if s := c.Stats(); s.Cost != 0 || s.Entries != 0 {
+		t.Errorf("no entry should be left, but %+v", s)
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := New[int, int](1000)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				k := (i * (g + 1)) % 300
+				if _, ok := c.Get(k); !ok {
+					c.Set(k, i, int64(k%20))
+				}
+				if i%7 == 0 {
+					c.SetCost(k, int64(i%30))
+				}
+				if i%97 == 0 {
+					c.Delete(k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	s := c.Stats()
+	if s.Cost > s.Capacity {
+		t.Errorf("cost %d should stay within %d", s.Cost, s.Capacity)
+	}
+	var cost int64
+	for _, e := range c.items {
+		cost += e.cost
+	}
+	if cost != s.Cost || c.small.len+c.main.len != len(c.items) {
+		t.Errorf("queues out of sync with the items, %d != %d", cost, s.Cost)
+	}
+}
@@ -0,0 +1,244 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// Disposal says what happens to the area of a frame once it has been shown.
+type Disposal uint8
+
+const (
+	// DisposeNone leaves the frame on the canvas.
+	DisposeNone Disposal = iota
+	// DisposeBackground clears the area of the frame to transparent.
+	DisposeBackground
+	// DisposePrevious restores the canvas as it was before the frame.
+	DisposePrevious
+)
+
+// minDelay is the shortest delay honoured, browsers slow down shorter ones to defaultDelay.
+const (
+	minDelay     = 20 * time.Millisecond
+	defaultDelay = 100 * time.Millisecond
+)
+
+// Frame is one frame of an animation, drawn at Offset on the canvas.
+type Frame struct {
+	Image    image.Image
+	Offset   image.Point
+	Delay    time.Duration
+	Disposal Disposal
+	// Replace copies the frame onto the canvas instead of blending it over.
+	Replace bool
+}
+
+// Animation is a decoded GIF, APNG or animated WebP.
+type Animation struct {
+	Width, Height int
+	// Plays is how many times the animation runs, 0 for forever.
+	Plays  int
+	Frames []Frame
+}
+
+// Animations come from peers, so their size is checked against these
+// limits before any pixels are allocated.
+const (
+	// MaxDimension bounds the width and the height of the canvas and of each frame.
+	MaxDimension = 4096
+	// maxPixels bounds the canvas, 32MB once composed.
+	maxPixels = 4096 * 2048
+	maxFrames = 1000
+	// maxAnimationPixels bounds the decoded frames together.
+	maxAnimationPixels = 1 << 26
+)
+
+var (
+	errNoFrames = errors.New("imaging: no frames")
+	// ErrTooLarge is returned for images beyond the limits above.
+	ErrTooLarge = errors.New("imaging: image too large")
+)
+
+// checkSize rejects a canvas or frame too large to decode.
+func checkSize(width, height int) error {
+	if width < 0 || height < 0 || width > MaxDimension || height > MaxDimension || width*height > maxPixels {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// frameBudget adds up the frames of an animation as their headers are read.
+type frameBudget struct {
+	frames, pixels int
+}
+
+func (b *frameBudget) add(width, height int) error {
+	if err := checkSize(width, height); err != nil {
+		return err
+	}
+	b.frames++
+	b.pixels += width * height
+	if b.frames > maxFrames || b.pixels > maxAnimationPixels {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// AnimationFormat returns "gif", "apng" or "webp" when the header starts an
+// animated image, and "" for still images. Any GIF counts as animated.
+func AnimationFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		return "gif"
+	case bytes.HasPrefix(header, pngSignature) && apngHeader(header):
+		return "apng"
+	case isWebP(header) && webpAnimated(header):
+		return "webp"
+	}
+	return ""
+}
+
+// DecodeAnimation decodes a GIF, PNG or WebP file, still images
+// come back as an animation of a single frame. Files beyond the size limits
+// fail with ErrTooLarge before anything is decoded.
+func DecodeAnimation(data []byte) (*Animation, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return decodeGIF(data)
+	case bytes.HasPrefix(data, pngSignature):
+		if apngHeader(data) {
+			return decodeAPNG(data)
+		}
+		return still(data, png.DecodeConfig, png.Decode)
+	case isWebP(data):
+		if webpAnimated(data) {
+			return decodeWebP(data)
+		}
+		return still(data, webp.DecodeConfig, webp.Decode)
+	}
+	return nil, ErrUnsupported
+}
+
+func still(data []byte, config func(io.Reader) (image.Config, error), decode func(io.Reader) (image.Image, error)) (*Animation, error) {
+	c, err := config(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSize(c.Width, c.Height); err != nil {
+		return nil, err
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	return &Animation{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		Frames: []Frame{{Image: img, Offset: b.Min.Mul(-1), Delay: defaultDelay}},
+	}, nil
+}
+
+func clampDelay(d time.Duration) time.Duration {
+	if d < minDelay {
+		return defaultDelay
+	}
+	return d
+}
+
+// Compose renders every frame of a as it is displayed, applying the disposal
+// of the frames before it, and scales the frames down to fit in width x height.
+// Each returned frame has its own pixels. An animation beyond the size
+// limits composes to no frames.
+func Compose(a *Animation, width, height int, f Filter) []*image.RGBA {
+	if len(a.Frames) == 0 || len(a.Frames) > maxFrames {
+		return nil
+	}
+	screen := image.Rect(0, 0, a.Width, a.Height)
+	if screen.Empty() {
+		for _, frame := range a.Frames {
+			screen = screen.Union(frame.Image.Bounds().Add(frame.Offset))
+		}
+	}
+	if checkSize(screen.Dx(), screen.Dy()) != nil {
+		return nil
+	}
+	canvas := image.NewRGBA(screen)
+	var saved []uint8
+	frames := make([]*image.RGBA, 0, len(a.Frames))
+	for _, frame := range a.Frames {
+		if frame.Disposal == DisposePrevious {
+			saved = append(saved[:0], canvas.Pix...)
+		}
+		drawFrame(canvas, frame)
+		out := Fit(canvas, width, height, f)
+		if out == canvas {
+			out = &image.RGBA{Pix: bytes.Clone(canvas.Pix), Stride: canvas.Stride, Rect: canvas.Rect}
+		}
+		frames = append(frames, out)
+		switch frame.Disposal {
+		case DisposeBackground:
+			// browsers clear to transparent rather than to the background colour
+			clearRect(canvas, frame.Image.Bounds().Add(frame.Offset))
+		case DisposePrevious:
+			copy(canvas.Pix, saved)
+		}
+	}
+	return frames
+}
+
+// drawFrame draws frame onto canvas, blending unless it replaces the area.
+func drawFrame(canvas *image.RGBA, frame Frame) {
+	if p, ok := frame.Image.(*image.Paletted); ok && frame.Offset == (image.Point{}) {
+		drawPaletted(canvas, p, frame.Replace)
+		return
+	}
+	b := frame.Image.Bounds()
+	r := b.Add(frame.Offset).Intersect(canvas.Bounds())
+	if r.Empty() {
+		return
+	}
+	buf := make([]uint8, b.Dx()*4)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := readRow(frame.Image, y-frame.Offset.Y, buf)
+		src := row[(r.Min.X-frame.Offset.X-b.Min.X)*4:]
+		dst := canvas.Pix[canvas.PixOffset(r.Min.X, y):]
+		if frame.Replace {
+			copy(dst[:r.Dx()*4], src)
+			continue
+		}
+		for x := 0; x < r.Dx()*4; x += 4 {
+			var c [4]uint8
+			copy(c[:], src[x:x+4])
+			switch c[3] {
+			case 0:
+			case 0xff:
+				copy(dst[x:x+4], c[:])
+			default:
+				blendOver(dst[x:x+4], c)
+			}
+		}
+	}
+}
+
+// blendOver composites the premultiplied colour c over the pixel p.
+func blendOver(p []uint8, c [4]uint8) {
+	k := 255 - uint32(c[3])
+	for i := range 4 {
+		p[i] = uint8(uint32(c[i]) + (uint32(p[i])*k+127)/255)
+	}
+}
+
+func clearRect(canvas *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(canvas.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := canvas.PixOffset(r.Min.X, y)
+		clear(canvas.Pix[i : i+r.Dx()*4])
+	}
+}
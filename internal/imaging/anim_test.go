@@ -0,0 +1,245 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+)
+
+type testFrame struct {
+	rect    image.Rectangle
+	c       color.RGBA
+	dispose byte
+	noBlend bool
+	delayMs int
+}
+
+// the last frame blends green over the top left corner after the
+// second one, drawn without blending, is cleared to transparent
+var testFrames = []testFrame{
+	{rect: image.Rect(0, 0, 4, 4), c: red, delayMs: 40},
+	{rect: image.Rect(2, 2, 4, 4), c: blue, dispose: 1, noBlend: true, delayMs: 0},
+	{rect: image.Rect(0, 0, 2, 2), c: green, delayMs: 300},
+}
+
+func checkAnimation(t *testing.T, a *Animation, plays int) {
+	t.Helper()
+	if a.Width != 4 || a.Height != 4 || a.Plays != plays || len(a.Frames) != 3 {
+		t.Fatalf("want a 4x4 animation of 3 frames played %d times, but %dx%d, %d frames, %d plays",
+			plays, a.Width, a.Height, len(a.Frames), a.Plays)
+	}
+	if a.Frames[0].Delay != 40*time.Millisecond || a.Frames[1].Delay != defaultDelay {
+		t.Errorf("delays should be 40ms and 100ms, but %v %v", a.Frames[0].Delay, a.Frames[1].Delay)
+	}
+	if a.Frames[1].Disposal != DisposeBackground || !a.Frames[1].Replace || a.Frames[2].Replace {
+		t.Errorf("frame 1 should replace and dispose, frame 2 should blend")
+	}
+	frames := Compose(a, 4, 4, Area)
+	if got := frames[1].RGBAAt(3, 3); got != blue {
+		t.Errorf("frame 1 should draw blue, but %v", got)
+	}
+	if got := frames[2].RGBAAt(3, 3); got != (color.RGBA{}) {
+		t.Errorf("frame 1 should be cleared, but %v", got)
+	}
+	if got := frames[2].RGBAAt(0, 0); got != green {
+		t.Errorf("frame 2 should draw green, but %v", got)
+	}
+	if got := frames[2].RGBAAt(3, 0); got != red {
+		t.Errorf("frame 2 should keep red, but %v", got)
+	}
+}
+
+func encodeAPNG(t *testing.T, frames []testFrame, plays int) []byte {
+	out := bytes.Clone(pngSignature)
+	for i, f := range frames {
+		img := image.NewRGBA(image.Rect(0, 0, f.rect.Dx(), f.rect.Dy()))
+		for p := 0; p < len(img.Pix); p += 4 {
+			img.Pix[p], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = f.c.R, f.c.G, f.c.B, f.c.A
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		chunks := pngChunks(buf.Bytes())
+		if i == 0 {
+			out = appendChunk(out, "IHDR", chunks[0].data)
+			actl := binary.BigEndian.AppendUint32(nil, uint32(len(frames)))
+			out = appendChunk(out, "acTL", binary.BigEndian.AppendUint32(actl, uint32(plays)))
+		}
+		fctl := binary.BigEndian.AppendUint32(nil, uint32(2*i))
+		for _, v := range []int{f.rect.Dx(), f.rect.Dy(), f.rect.Min.X, f.rect.Min.Y} {
+			fctl = binary.BigEndian.AppendUint32(fctl, uint32(v))
+		}
+		fctl = binary.BigEndian.AppendUint16(fctl, uint16(f.delayMs))
+		fctl = binary.BigEndian.AppendUint16(fctl, 1000)
+		blend := byte(1)
+		if f.noBlend {
+			blend = 0
+		}
+		out = appendChunk(out, "fcTL", append(fctl, f.dispose, blend))
+		for _, c := range chunks {
+			if c.kind != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				out = appendChunk(out, "IDAT", c.data)
+			} else {
+				out = appendChunk(out, "fdAT", append(binary.BigEndian.AppendUint32(nil, uint32(2*i+1)), c.data...))
+			}
+		}
+	}
+	return appendChunk(out, "IEND", nil)
+}
+
+// bitWriter writes the least significant bits first, as VP8L reads them.
+type bitWriter struct {
+	out  []byte
+	bits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.bits%8 == 0 {
+			w.out = append(w.out, 0)
+		}
+		w.out[len(w.out)-1] |= byte(v>>i&1) << (w.bits % 8)
+		w.bits++
+	}
+}
+
+// solidVP8L encodes a lossless image of one colour, every prefix code
+// holds a single symbol so that the pixels themselves take no bits.
+func solidVP8L(w, h int, c color.RGBA) []byte {
+	b := &bitWriter{out: []byte{0x2f}, bits: 8}
+	b.write(uint32(w-1), 14)
+	b.write(uint32(h-1), 14)
+	b.write(1, 1) // alpha is used
+	b.write(0, 3) // version
+	b.write(0, 1) // no transform
+	b.write(0, 1) // no colour cache
+	b.write(0, 1) // no meta prefix codes
+	for _, v := range []uint8{c.G, c.R, c.B, c.A, 0} {
+		b.write(1, 1) // simple code
+		b.write(0, 1) // one symbol
+		b.write(1, 1) // of 8 bits
+		b.write(uint32(v), 8)
+	}
+	return b.out
+}
+
+func encodeWebP(frames []testFrame, plays int) []byte {
+	header := []byte{webpAnimFlag | webpAlphaFlag, 0, 0, 0, 3, 0, 0, 3, 0, 0}
+	body := appendRIFFChunk([]byte("WEBP"), "VP8X", header)
+	body = appendRIFFChunk(body, "ANIM", []byte{0, 0, 0, 0, byte(plays), byte(plays >> 8)})
+	for _, f := range frames {
+		put := func(b []byte, v int) []byte { return append(b, byte(v), byte(v>>8), byte(v>>16)) }
+		anmf := put(nil, f.rect.Min.X/2)
+		anmf = put(anmf, f.rect.Min.Y/2)
+		anmf = put(anmf, f.rect.Dx()-1)
+		anmf = put(anmf, f.rect.Dy()-1)
+		anmf = put(anmf, f.delayMs)
+		var flags byte
+		if f.noBlend {
+			flags |= 0x02
+		}
+		anmf = append(anmf, flags|f.dispose)
+		anmf = appendRIFFChunk(anmf, "VP8L", solidVP8L(f.rect.Dx(), f.rect.Dy(), f.c))
+		body = appendRIFFChunk(body, "ANMF", anmf)
+	}
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func TestDecodeAPNG(t *testing.T) {
+	data := encodeAPNG(t, testFrames, 2)
+	if f := AnimationFormat(data[:64]); f != "apng" {
+		t.Fatalf("format should be apng, but %q", f)
+	}
+	a, err := DecodeAnimation(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnimation(t, a, 2)
+}
+
+func TestDecodeWebP(t *testing.T) {
+	data := encodeWebP(testFrames, 0)
+	if f := AnimationFormat(data[:32]); f != "webp" {
+		t.Fatalf("format should be webp, but %q", f)
+	}
+	a, err := DecodeAnimation(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnimation(t, a, 0)
+}
+
+func TestDecodeStill(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, uniform(red, 5, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if f := AnimationFormat(buf.Bytes()); f != "" {
+		t.Errorf("a still png should not be animated, but %q", f)
+	}
+	a, err := DecodeAnimation(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Frames) != 1 || a.Width != 5 || a.Height != 3 {
+		t.Errorf("want one 5x3 frame, but %d frames of %dx%d", len(a.Frames), a.Width, a.Height)
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	huge := encodeAPNG(t, testFrames, 0)
+	// the IHDR follows the signature, its length and its type
+	binary.BigEndian.PutUint32(huge[16:], 1<<24)
+	binary.BigEndian.PutUint32(huge[20:], 1<<24)
+	if _, err := DecodeAnimation(huge); err != ErrTooLarge {
+		t.Errorf("apng of 1<<24 pixels square should be too large, but %v", err)
+	}
+
+	huge = encodeWebP(testFrames, 0)
+	// the VP8X payload starts after RIFF, WEBP and the chunk header
+	copy(huge[24:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if _, err := DecodeAnimation(huge); err != ErrTooLarge {
+		t.Errorf("webp of 1<<24 pixels square should be too large, but %v", err)
+	}
+
+	g := &gif.GIF{Config: image.Config{Width: 1, Height: 1}}
+	for range maxFrames + 1 {
+		g.Image = append(g.Image, paletted(image.Rect(0, 0, 1, 1), red))
+		g.Delay = append(g.Delay, 0)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeAnimation(buf.Bytes()); err != ErrTooLarge {
+		t.Errorf("gif of %d frames should be too large, but %v", maxFrames+1, err)
+	}
+
+	a := &Animation{Width: 1 << 24, Height: 1 << 24, Frames: []Frame{{Image: paletted(image.Rect(0, 0, 1, 1), red)}}}
+	if frames := Compose(a, 64, 64, Area); frames != nil {
+		t.Errorf("a 1<<24 pixels square animation should compose to nothing, but %d frames", len(frames))
+	}
+}
+
+func TestDecodeGIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation(gif.DisposalNone)); err != nil {
+		t.Fatal(err)
+	}
+	a, err := DecodeAnimation(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Frames) != 3 || a.Width != 4 || a.Height != 4 {
+		t.Errorf("want three frames of 4x4, but %d frames of %dx%d", len(a.Frames), a.Width, a.Height)
+	}
+}
@@ -0,0 +1,171 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"time"
+)
+
+// pngShared are the chunks every frame of an APNG needs to decode.
+var pngShared = map[string]bool{"PLTE": true, "tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true, "sBIT": true}
+
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// pngChunks splits the chunks after the signature, a truncated tail is dropped.
+func pngChunks(data []byte) []pngChunk {
+	var chunks []pngChunk
+	for i := len(pngSignature); i+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			break
+		}
+		chunks = append(chunks, pngChunk{kind: string(data[i+4 : i+8]), data: data[i+8 : i+8+n]})
+		i = end
+	}
+	return chunks
+}
+
+// apngHeader reports whether an animation control chunk comes before the image data.
+func apngHeader(header []byte) bool {
+	for i := len(pngSignature); i+8 <= len(header); {
+		switch string(header[i+4 : i+8]) {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+		n := int(binary.BigEndian.Uint32(header[i:]))
+		if n < 0 {
+			return false
+		}
+		i += 12 + n
+	}
+	return false
+}
+
+func offset(x, y uint32) image.Point {
+	return image.Pt(int(x), int(y))
+}
+
+func appendChunk(out []byte, kind string, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	start := len(out)
+	out = append(out, kind...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+// apngFrame is a frame control chunk and the image data that follows it.
+type apngFrame struct {
+	control []byte
+	data    []byte
+}
+
+// decodeAPNG decodes every frame by rewriting it as a PNG of its own.
+func decodeAPNG(data []byte) (*Animation, error) {
+	var (
+		ihdr, actl []byte
+		shared     []pngChunk
+		frames     []*apngFrame
+		current    *apngFrame
+	)
+	for _, c := range pngChunks(data) {
+		switch c.kind {
+		case "IHDR":
+			ihdr = c.data
+		case "acTL":
+			actl = c.data
+		case "fcTL":
+			if len(c.data) < 26 {
+				return nil, errors.New("imaging: invalid apng frame control")
+			}
+			current = &apngFrame{control: c.data}
+			frames = append(frames, current)
+		case "IDAT":
+			// the default image is only a frame when a frame control precedes it
+			if current != nil {
+				current.data = append(current.data, c.data...)
+			}
+		case "fdAT":
+			if current != nil && len(c.data) > 4 {
+				current.data = append(current.data, c.data[4:]...)
+			}
+		default:
+			if pngShared[c.kind] {
+				shared = append(shared, c)
+			}
+		}
+	}
+	if len(ihdr) != 13 || len(actl) < 8 {
+		return nil, errors.New("imaging: invalid apng")
+	}
+	a := &Animation{
+		Width:  int(binary.BigEndian.Uint32(ihdr)),
+		Height: int(binary.BigEndian.Uint32(ihdr[4:])),
+		Plays:  int(binary.BigEndian.Uint32(actl[4:])),
+	}
+	if err := checkSize(a.Width, a.Height); err != nil {
+		return nil, err
+	}
+	var budget frameBudget
+	for _, f := range frames {
+		err := budget.add(int(binary.BigEndian.Uint32(f.control[4:])), int(binary.BigEndian.Uint32(f.control[8:])))
+		if err != nil {
+			return nil, err
+		}
+	}
+	header := bytes.Clone(ihdr)
+	for i, f := range frames {
+		c := f.control
+		copy(header, c[4:12])
+		var out []byte
+		out = append(out, pngSignature...)
+		out = appendChunk(out, "IHDR", header)
+		for _, s := range shared {
+			out = appendChunk(out, s.kind, s.data)
+		}
+		out = appendChunk(out, "IDAT", f.data)
+		out = appendChunk(out, "IEND", nil)
+		img, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			if len(a.Frames) > 0 {
+				// play what decoded of a damaged file
+				break
+			}
+			return nil, err
+		}
+		num, den := binary.BigEndian.Uint16(c[20:]), binary.BigEndian.Uint16(c[22:])
+		if den == 0 {
+			den = 100
+		}
+		frame := Frame{
+			Image:   img,
+			Offset:  offset(binary.BigEndian.Uint32(c[12:]), binary.BigEndian.Uint32(c[16:])),
+			Delay:   clampDelay(time.Duration(num) * time.Second / time.Duration(den)),
+			Replace: c[25] == 0,
+		}
+		switch c[24] {
+		case 1:
+			frame.Disposal = DisposeBackground
+		case 2:
+			frame.Disposal = DisposePrevious
+			if i == 0 {
+				// nothing to restore before the first frame
+				frame.Disposal = DisposeBackground
+			}
+		}
+		a.Frames = append(a.Frames, frame)
+	}
+	if len(a.Frames) == 0 {
+		return nil, errNoFrames
+	}
+	return a, nil
+}
@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// ToRGBA returns src as premultiplied RGBA with its origin at (0, 0),
+// src itself is returned when it already is.
+func ToRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	if rgba, ok := src.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if b.Empty() {
+		return dst
+	}
+	parallel(b.Dy(), func(lo, hi int) {
+		for y := lo; y < hi; y++ {
+			out := dst.Pix[y*dst.Stride : y*dst.Stride+b.Dx()*4]
+			if row := readRow(src, b.Min.Y+y, out); &row[0] != &out[0] {
+				copy(out, row)
+			}
+		}
+	})
+	return dst
+}
+
+// readRow returns row y of src as premultiplied RGBA. Rows of *image.RGBA are
+// returned in place, others are converted into buf which holds Dx()*4 bytes.
+func readRow(src image.Image, y int, buf []uint8) []uint8 {
+	b := src.Bounds()
+	switch s := src.(type) {
+	case *image.RGBA:
+		i := s.PixOffset(b.Min.X, y)
+		return s.Pix[i : i+b.Dx()*4]
+	case *image.NRGBA:
+		i := s.PixOffset(b.Min.X, y)
+		row := s.Pix[i : i+b.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			a := uint32(row[x+3])
+			buf[x] = uint8(uint32(row[x]) * a / 255)
+			buf[x+1] = uint8(uint32(row[x+1]) * a / 255)
+			buf[x+2] = uint8(uint32(row[x+2]) * a / 255)
+			buf[x+3] = row[x+3]
+		}
+	case *image.YCbCr:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			yi, ci := s.YOffset(x, y), s.COffset(x, y)
+			r, g, bl := color.YCbCrToRGB(s.Y[yi], s.Cb[ci], s.Cr[ci])
+			p := buf[(x-b.Min.X)*4:]
+			p[0], p[1], p[2], p[3] = r, g, bl, 0xff
+		}
+	case *image.NYCbCrA:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			yi, ci := s.YOffset(x, y), s.COffset(x, y)
+			r, g, bl := color.YCbCrToRGB(s.Y[yi], s.Cb[ci], s.Cr[ci])
+			a := uint32(s.A[s.AOffset(x, y)])
+			p := buf[(x-b.Min.X)*4:]
+			p[0], p[1], p[2], p[3] = uint8(uint32(r)*a/255), uint8(uint32(g)*a/255), uint8(uint32(bl)*a/255), uint8(a)
+		}
+	case *image.Gray:
+		i := s.PixOffset(b.Min.X, y)
+		for x, v := range s.Pix[i : i+b.Dx()] {
+			buf[x*4], buf[x*4+1], buf[x*4+2], buf[x*4+3] = v, v, v, 0xff
+		}
+	default:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(src.At(x, y)).(color.RGBA)
+			p := buf[(x-b.Min.X)*4:]
+			p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+		}
+	}
+	return buf[:b.Dx()*4]
+}
@@ -0,0 +1,169 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// EXIF orientations, see the TIFF tag 0x0112.
+const (
+	OrientNormal = iota + 1
+	OrientFlipH
+	OrientRotate180
+	OrientFlipV
+	OrientTranspose
+	OrientRotate90
+	OrientTransverse
+	OrientRotate270
+)
+
+const (
+	markerSOI  = 0xd8
+	markerSOS  = 0xda
+	markerAPP0 = 0xe0
+	markerAPP1 = 0xe1
+	tagOrient  = 0x0112
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegSegment is a marker segment before the scan, data excludes the marker and length.
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegSegments splits the header of a JPEG, rest starts at the SOS marker.
+// ok is false when data is not a JPEG.
+func jpegSegments(data []byte) (segments []jpegSegment, rest []byte, ok bool) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, nil, false
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return segments, nil, false
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, data[i:], true
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return segments, nil, false
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[i+4 : i+2+n]})
+		i += 2 + n
+	}
+	// header cut short, enough to look for the orientation
+	return segments, nil, true
+}
+
+// Orientation reads the EXIF orientation from the start of a JPEG file,
+// OrientNormal is returned when there is none.
+func Orientation(header []byte) int {
+	segments, _, ok := jpegSegments(header)
+	if !ok && len(segments) == 0 {
+		return OrientNormal
+	}
+	for _, s := range segments {
+		if s.marker == markerAPP1 && bytes.HasPrefix(s.data, exifHeader) {
+			if o := tiffOrientation(s.data[len(exifHeader):]); o >= OrientNormal && o <= OrientRotate270 {
+				return o
+			}
+		}
+	}
+	return OrientNormal
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	// compare before converting, int is 32 bits on some targets
+	offset := order.Uint32(tiff[4:])
+	if int64(offset)+2 > int64(len(tiff)) {
+		return 0
+	}
+	ifd := int(offset)
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:]) == tagOrient {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
+
+// orientationSegment builds an APP1 payload holding nothing but the orientation.
+func orientationSegment(o int) []byte {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	// tag, type SHORT, count 1, value padded to 4 bytes
+	tiff = binary.LittleEndian.AppendUint16(tiff, tagOrient)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(o))
+	// no next IFD
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	return append(bytes.Clone(exifHeader), tiff...)
+}
+
+// Orient transforms img as its EXIF orientation asks so that it displays upright.
+func Orient(img image.Image, o int) image.Image {
+	if o <= OrientNormal || o > OrientRotate270 {
+		return img
+	}
+	src := ToRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if o >= OrientTranspose {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	parallel(h, func(lo, hi int) {
+		for y := lo; y < hi; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+w*4]
+			for x := 0; x < w; x++ {
+				var dx, dy int
+				switch o {
+				case OrientFlipH:
+					dx, dy = w-1-x, y
+				case OrientRotate180:
+					dx, dy = w-1-x, h-1-y
+				case OrientFlipV:
+					dx, dy = x, h-1-y
+				case OrientTranspose:
+					dx, dy = y, x
+				case OrientRotate90:
+					dx, dy = h-1-y, x
+				case OrientTransverse:
+					dx, dy = h-1-y, w-1-x
+				case OrientRotate270:
+					dx, dy = y, w-1-x
+				}
+				copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], row[x*4:x*4+4])
+			}
+		}
+	})
+	return dst
+}
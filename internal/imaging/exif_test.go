@@ -0,0 +1,169 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withSegments inserts application segments right after the SOI marker.
+func withSegments(t *testing.T, data []byte, segments ...jpegSegment) []byte {
+	t.Helper()
+	out := []byte{0xff, markerSOI}
+	for _, s := range segments {
+		out = appendSegment(out, s.marker, s.data)
+	}
+	return append(out, data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, uniform(color.RGBA{R: 90, G: 160, B: 30, A: 255}, w, h), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStripJPEG(t *testing.T) {
+	exif := orientationSegment(OrientRotate90)
+	// pretend there is a GPS sub IFD after the orientation
+	exif = append(exif, []byte("GPS 52.37N 4.89E")...)
+	data := withSegments(t, encodeJPEG(t, 40, 30),
+		jpegSegment{marker: markerAPP1, data: exif},
+		jpegSegment{marker: markerAPP1, data: []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")},
+		jpegSegment{marker: 0xe2, data: []byte("ICC_PROFILE\x00\x01\x01")},
+		jpegSegment{marker: 0xfe, data: []byte("shot on a phone")},
+	)
+	if o := Orientation(data); o != OrientRotate90 {
+		t.Fatalf("orientation should be %d, but %d", OrientRotate90, o)
+	}
+	stripped, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"GPS", "xmpmeta", "phone"} {
+		if bytes.Contains(stripped, []byte(leak)) {
+			t.Errorf("%q should be stripped", leak)
+		}
+	}
+	if !bytes.Contains(stripped, []byte("ICC_PROFILE")) {
+		t.Errorf("colour profile should be kept")
+	}
+	if o := Orientation(stripped); o != OrientRotate90 {
+		t.Errorf("orientation should survive stripping, but %d", o)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Size() != image.Pt(40, 30) {
+		t.Errorf("stripped jpeg should decode to 40x30, but %v", img.Bounds().Size())
+	}
+	if _, err = StripMetadata([]byte("GIF89a")); err != ErrUnsupported {
+		t.Errorf("gif should be unsupported, but %v", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, uniform(color.RGBA{B: 255, A: 255}, 8, 8)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	text := []byte("Comment\x00secret location")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(append(append(chunk, "tEXt"...), text...), 0, 0, 0, 0)
+	// the IHDR chunk is 25 bytes after the signature
+	at := len(pngSignature) + 25
+	data = append(append(bytes.Clone(data[:at]), chunk...), data[at:]...)
+	stripped, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte("secret")) {
+		t.Errorf("text chunk should be stripped")
+	}
+	if _, err = png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped png should decode, %v", err)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	data := encodeWebP(testFrames, 0)
+	data = appendRIFFChunk(data, "EXIF", []byte("secret location"))
+	data = appendRIFFChunk(data, "XMP ", []byte("<x:xmpmeta>secret</x:xmpmeta>"))
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	// the VP8X flags follow RIFF, WEBP and the chunk header
+	data[20] |= webpExifFlag | webpXMPFlag
+	stripped, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte("secret")) {
+		t.Errorf("exif and xmp chunks should be stripped")
+	}
+	if stripped[20]&(webpExifFlag|webpXMPFlag) != 0 {
+		t.Errorf("exif and xmp flags should be cleared, but %#x", stripped[20])
+	}
+	a, err := DecodeAnimation(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAnimation(t, a, 0)
+}
+
+func TestOrientationOffset(t *testing.T) {
+	for _, offset := range []uint32{0x7fffffff, 0x80000000, 0xffffffff} {
+		tiff := binary.LittleEndian.AppendUint32([]byte{'I', 'I', 42, 0}, offset)
+		tiff = append(tiff, make([]byte, 16)...)
+		if o := tiffOrientation(tiff); o != 0 {
+			t.Errorf("offset %#x: orientation should be 0, but %d", offset, o)
+		}
+	}
+	data := withSegments(t, encodeJPEG(t, 4, 4), jpegSegment{
+		marker: markerAPP1,
+		data:   append(bytes.Clone(exifHeader), 'M', 'M', 0, 42, 0x80, 0, 0, 0),
+	})
+	if o := Orientation(data); o != OrientNormal {
+		t.Errorf("orientation should be normal, but %d", o)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 2x3, pixel value encodes its position
+	src := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 2; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+	tests := []struct {
+		o      int
+		size   image.Point
+		origin image.Point // where the top left source pixel ends up
+	}{
+		{OrientNormal, image.Pt(2, 3), image.Pt(0, 0)},
+		{OrientFlipH, image.Pt(2, 3), image.Pt(1, 0)},
+		{OrientRotate180, image.Pt(2, 3), image.Pt(1, 2)},
+		{OrientFlipV, image.Pt(2, 3), image.Pt(0, 2)},
+		{OrientTranspose, image.Pt(3, 2), image.Pt(0, 0)},
+		{OrientRotate90, image.Pt(3, 2), image.Pt(2, 0)},
+		{OrientTransverse, image.Pt(3, 2), image.Pt(2, 1)},
+		{OrientRotate270, image.Pt(3, 2), image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		dst := ToRGBA(Orient(src, tt.o))
+		if dst.Bounds().Size() != tt.size {
+			t.Errorf("orientation %d: size should be %v, but %v", tt.o, tt.size, dst.Bounds().Size())
+			continue
+		}
+		if c := dst.RGBAAt(tt.origin.X, tt.origin.Y); c.R != 0 || c.G != 0 {
+			t.Errorf("orientation %d: top left pixel should move to %v", tt.o, tt.origin)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"time"
+)
+
+func decodeGIF(data []byte) (*Animation, error) {
+	if err := checkGIF(data); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return FromGIF(g), nil
+}
+
+// checkGIF walks the blocks of a GIF to size the screen and every frame
+// before any is decoded. Damaged files are left for the decoder to report.
+func checkGIF(data []byte) error {
+	if len(data) < 13 {
+		return nil
+	}
+	if err := checkSize(int(binary.LittleEndian.Uint16(data[6:])), int(binary.LittleEndian.Uint16(data[8:]))); err != nil {
+		return err
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&7 + 1)
+	}
+	var budget frameBudget
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension, a label then sub-blocks
+			i = skipSubBlocks(data, i+2)
+		case 0x2c: // image descriptor
+			if i+10 > len(data) {
+				return nil
+			}
+			err := budget.add(int(binary.LittleEndian.Uint16(data[i+5:])), int(binary.LittleEndian.Uint16(data[i+7:])))
+			if err != nil {
+				return err
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&7 + 1)
+			}
+			// the LZW code size comes before the sub-blocks
+			i = skipSubBlocks(data, i+1)
+		default:
+			// the trailer, or garbage the decoder stops at as well
+			return nil
+		}
+	}
+	return nil
+}
+
+func skipSubBlocks(data []byte, i int) int {
+	for i < len(data) {
+		n := int(data[i])
+		i += 1 + n
+		if n == 0 {
+			break
+		}
+	}
+	return i
+}
+
+// FromGIF wraps the frames of g, which are shared rather than copied.
+func FromGIF(g *gif.GIF) *Animation {
+	a := &Animation{Width: g.Config.Width, Height: g.Config.Height}
+	switch {
+	case g.LoopCount < 0:
+		a.Plays = 1
+	case g.LoopCount > 0:
+		a.Plays = g.LoopCount + 1
+	}
+	for i, img := range g.Image {
+		frame := Frame{Image: img, Delay: defaultDelay}
+		if i < len(g.Delay) {
+			frame.Delay = clampDelay(time.Duration(g.Delay[i]) * 10 * time.Millisecond)
+		}
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				frame.Disposal = DisposeBackground
+			case gif.DisposalPrevious:
+				frame.Disposal = DisposePrevious
+			}
+		}
+		a.Frames = append(a.Frames, frame)
+	}
+	return a
+}
+
+// drawPaletted draws frame onto canvas, GIF colours are either opaque or transparent.
+func drawPaletted(canvas *image.RGBA, frame *image.Paletted, replace bool) {
+	var palette [256][4]uint8
+	for i, c := range frame.Palette {
+		r, g, b, a := c.RGBA()
+		palette[i] = [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	}
+	r := frame.Bounds().Intersect(canvas.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		src := frame.Pix[frame.PixOffset(r.Min.X, y):]
+		dst := canvas.Pix[canvas.PixOffset(r.Min.X, y):]
+		for x := 0; x < r.Dx(); x++ {
+			c := palette[src[x]]
+			switch {
+			case replace, c[3] == 0xff:
+				copy(dst[x*4:x*4+4], c[:])
+			case c[3] == 0:
+			default:
+				blendOver(dst[x*4:x*4+4], c)
+			}
+		}
+	}
+}
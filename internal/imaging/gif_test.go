@@ -0,0 +1,116 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+)
+
+var (
+	red   = color.RGBA{R: 255, A: 255}
+	blue  = color.RGBA{B: 255, A: 255}
+	green = color.RGBA{G: 255, A: 255}
+)
+
+func paletted(r image.Rectangle, c color.Color) *image.Paletted {
+	img := image.NewPaletted(r, color.Palette{color.Transparent, c})
+	for i := range img.Pix {
+		img.Pix[i] = 1
+	}
+	return img
+}
+
+func animation(disposal byte) *gif.GIF {
+	return &gif.GIF{
+		Image: []*image.Paletted{
+			paletted(image.Rect(0, 0, 4, 4), red),
+			paletted(image.Rect(0, 0, 2, 2), blue),
+			paletted(image.Rect(3, 3, 4, 4), green),
+		},
+		Delay:    []int{0, 5, 20},
+		Disposal: []byte{gif.DisposalNone, disposal, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 4},
+	}
+}
+
+func TestComposeGIF(t *testing.T) {
+	cases := []struct {
+		disposal byte
+		topLeft  color.RGBA
+	}{
+		{gif.DisposalNone, blue},
+		{gif.DisposalBackground, color.RGBA{}},
+		{gif.DisposalPrevious, red},
+	}
+	for _, c := range cases {
+		frames := Compose(FromGIF(animation(c.disposal)), 4, 4, Area)
+		if len(frames) != 3 {
+			t.Fatalf("3 frames should be composed, but %d", len(frames))
+		}
+		if got := frames[1].RGBAAt(0, 0); got != blue {
+			t.Errorf("disposal %d: frame 1 should draw blue, but %v", c.disposal, got)
+		}
+		if got := frames[2].RGBAAt(0, 0); got != c.topLeft {
+			t.Errorf("disposal %d: frame 2 should show %v, but %v", c.disposal, c.topLeft, got)
+		}
+		if got := frames[2].RGBAAt(3, 3); got != green {
+			t.Errorf("disposal %d: frame 2 should draw green, but %v", c.disposal, got)
+		}
+		if got := frames[2].RGBAAt(3, 0); got != red {
+			t.Errorf("disposal %d: frame 2 should keep red, but %v", c.disposal, got)
+		}
+	}
+}
+
+func TestComposeGIFScale(t *testing.T) {
+	frames := Compose(FromGIF(animation(gif.DisposalNone)), 2, 2, Area)
+	for _, f := range frames {
+		if f.Bounds().Size() != image.Pt(2, 2) {
+			t.Fatalf("frames should be scaled to 2x2, but %v", f.Bounds().Size())
+		}
+	}
+	if &frames[0].Pix[0] == &frames[1].Pix[0] {
+		t.Errorf("frames should not share pixels")
+	}
+	a := FromGIF(animation(gif.DisposalNone))
+	if a.Frames[0].Delay != defaultDelay || a.Frames[1].Delay != 50*time.Millisecond || a.Frames[2].Delay != 200*time.Millisecond {
+		t.Errorf("delays should be 100ms, 50ms and 200ms, but %v %v %v", a.Frames[0].Delay, a.Frames[1].Delay, a.Frames[2].Delay)
+	}
+}
+
+func benchAnimation(n, size int) *gif.GIF {
+	g := &gif.GIF{Config: image.Config{Width: size, Height: size}}
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{R: uint8(i), G: uint8(255 - i), B: uint8(i * 3), A: 255}
+	}
+	palette[0] = color.Transparent
+	for i := 0; i < n; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+		for p := range frame.Pix {
+			frame.Pix[p] = uint8(p + i)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 4)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	return g
+}
+
+func BenchmarkComposeGIF(b *testing.B) {
+	a := FromGIF(benchAnimation(60, 480))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Compose(a, 240, 240, Area)
+	}
+}
+
+func BenchmarkComposeGIFNative(b *testing.B) {
+	a := FromGIF(benchAnimation(60, 480))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Compose(a, 480, 480, Area)
+	}
+}
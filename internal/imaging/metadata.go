@@ -0,0 +1,112 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUnsupported is returned by StripMetadata for formats it does not rewrite.
+var ErrUnsupported = errors.New("imaging: unsupported format")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadata are the ancillary PNG chunks carrying text, time and EXIF.
+var pngMetadata = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// StripMetadata removes EXIF, XMP, IPTC and comments from a JPEG, PNG or WebP.
+// The orientation of a JPEG is kept in a minimal EXIF block, colour profiles are kept.
+func StripMetadata(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		return stripPNG(data)
+	}
+	if isWebP(data) {
+		return stripWebP(data)
+	}
+	segments, rest, ok := jpegSegments(data)
+	if !ok || rest == nil {
+		if len(data) >= 2 && data[0] == 0xff && data[1] == markerSOI {
+			return nil, errors.New("imaging: truncated jpeg")
+		}
+		return nil, ErrUnsupported
+	}
+	o := Orientation(data)
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, markerSOI)
+	pending := o != OrientNormal
+	for _, s := range segments {
+		if pending && s.marker != markerAPP0 {
+			out = appendSegment(out, markerAPP1, orientationSegment(o))
+			pending = false
+		}
+		if !keepSegment(s.marker) {
+			continue
+		}
+		out = appendSegment(out, s.marker, s.data)
+	}
+	if pending {
+		out = appendSegment(out, markerAPP1, orientationSegment(o))
+	}
+	return append(out, rest...), nil
+}
+
+// keepSegment keeps tables, frame headers, JFIF, ICC profiles and the Adobe
+// colour transform, other application segments and comments are dropped.
+func keepSegment(marker byte) bool {
+	switch {
+	case marker == markerAPP0, marker == 0xe2, marker == 0xee:
+		return true
+	case marker >= markerAPP1 && marker <= 0xef, marker == 0xfe:
+		return false
+	}
+	return true
+}
+
+func appendSegment(out []byte, marker byte, data []byte) []byte {
+	out = append(out, 0xff, marker)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)+2))
+	return append(out, data...)
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := bytes.Clone(pngSignature)
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("imaging: truncated png")
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, errors.New("imaging: truncated png")
+		}
+		if !pngMetadata[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the
+// extended header, frames and the ICC profile are kept.
+func stripWebP(data []byte) ([]byte, error) {
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || 8+size > len(data) {
+		return nil, errors.New("imaging: truncated webp")
+	}
+	out := append([]byte(nil), "RIFF\x00\x00\x00\x00WEBP"...)
+	for _, c := range riffChunks(data[12 : 8+size]) {
+		switch c.kind {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if len(c.data) > 0 {
+				c.data = bytes.Clone(c.data)
+				c.data[0] &^= webpExifFlag | webpXMPFlag
+			}
+		}
+		out = appendRIFFChunk(out, c.kind, c.data)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,216 @@
+// Package imaging resamples images directly on their pixel buffers.
+package imaging
+
+import (
+	"image"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Filter is a resampling kernel. A nil Kernel averages the covered source
+// area, which is exact for downscaling and nearest neighbour for upscaling.
+type Filter struct {
+	// Support is the kernel radius in source pixels when not downscaling.
+	Support float64
+	Kernel  func(x float64) float64
+}
+
+var (
+	// Area averages every source pixel covered by a destination pixel,
+	// the fastest choice for large downscales such as thumbnails.
+	Area = Filter{Support: 0.5}
+	// CatmullRom is a sharp cubic, a good default for previews.
+	CatmullRom = Filter{Support: 2, Kernel: catmullRom}
+	// Lanczos is a three lobed windowed sinc, the sharpest and slowest.
+	Lanczos = Filter{Support: 3, Kernel: lanczos}
+)
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+func lanczos(x float64) float64 {
+	if x <= -3 || x >= 3 {
+		return 0
+	}
+	return sinc(x) * sinc(x/3)
+}
+
+// contrib lists the source samples weighted into one destination sample.
+type contrib struct {
+	left    int
+	weights []float32
+}
+
+func computeWeights(dst, src int, f Filter) []contrib {
+	scale := float64(src) / float64(dst)
+	out := make([]contrib, dst)
+	for i := range out {
+		var left, right int
+		var weights []float64
+		if f.Kernel == nil {
+			lo, hi := float64(i)*scale, float64(i+1)*scale
+			left, right = int(lo), min(src, int(math.Ceil(hi)))
+			for j := left; j < right; j++ {
+				weights = append(weights, math.Min(hi, float64(j+1))-math.Max(lo, float64(j)))
+			}
+		} else {
+			// widen the kernel when downscaling so that it averages instead of aliasing
+			fs := math.Max(scale, 1)
+			center := (float64(i) + 0.5) * scale
+			left = max(0, int(math.Floor(center-f.Support*fs)))
+			right = min(src, int(math.Ceil(center+f.Support*fs)))
+			for j := left; j < right; j++ {
+				weights = append(weights, f.Kernel((float64(j)+0.5-center)/fs))
+			}
+		}
+		sum := 0.0
+		for _, w := range weights {
+			sum += w
+		}
+		c := contrib{left: left, weights: make([]float32, len(weights))}
+		if sum == 0 {
+			c.left, c.weights = min(src-1, int(float64(i)*scale)), []float32{1}
+		}
+		for j, w := range weights {
+			if sum != 0 {
+				c.weights[j] = float32(w / sum)
+			}
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// Resize scales src to width x height.
+func Resize(src image.Image, width, height int, f Filter) *image.RGBA {
+	b := src.Bounds()
+	if width <= 0 || height <= 0 || b.Empty() {
+		return image.NewRGBA(image.Rect(0, 0, max(width, 0), max(height, 0)))
+	}
+	var tmp *image.RGBA
+	if width == b.Dx() {
+		tmp = ToRGBA(src)
+	} else {
+		tmp = resizeRows(src, width, f)
+	}
+	if height == b.Dy() {
+		return tmp
+	}
+	return resizeColumns(tmp, height, f)
+}
+
+// Fit scales src down, keeping its aspect ratio, so that it fits in width x height.
+// Images that already fit are only converted.
+func Fit(src image.Image, width, height int, f Filter) *image.RGBA {
+	dx, dy := src.Bounds().Dx(), src.Bounds().Dy()
+	if dx <= width && dy <= height {
+		return ToRGBA(src)
+	}
+	if dx*height > dy*width {
+		return Resize(src, width, max(1, dy*width/dx), f)
+	}
+	return Resize(src, max(1, dx*height/dy), height, f)
+}
+
+func resizeRows(src image.Image, width int, f Filter) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, b.Dy()))
+	weights := computeWeights(width, b.Dx(), f)
+	parallel(b.Dy(), func(lo, hi int) {
+		buf := make([]uint8, b.Dx()*4)
+		for y := lo; y < hi; y++ {
+			row := readRow(src, b.Min.Y+y, buf)
+			out := dst.Pix[y*dst.Stride : y*dst.Stride+width*4]
+			for x, c := range weights {
+				var r, g, bl, a float32
+				for k, w := range c.weights {
+					s := row[(c.left+k)*4 : (c.left+k)*4+4 : (c.left+k)*4+4]
+					r += w * float32(s[0])
+					g += w * float32(s[1])
+					bl += w * float32(s[2])
+					a += w * float32(s[3])
+				}
+				store(out[x*4:x*4+4:x*4+4], r, g, bl, a)
+			}
+		}
+	})
+	return dst
+}
+
+func resizeColumns(src *image.RGBA, height int, f Filter) *image.RGBA {
+	width := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	weights := computeWeights(height, src.Bounds().Dy(), f)
+	parallel(height, func(lo, hi int) {
+		acc := make([]float32, width*4)
+		for y := lo; y < hi; y++ {
+			clear(acc)
+			c := weights[y]
+			for k, w := range c.weights {
+				row := src.Pix[(c.left+k)*src.Stride : (c.left+k)*src.Stride+width*4]
+				for i, v := range row {
+					acc[i] += w * float32(v)
+				}
+			}
+			out := dst.Pix[y*dst.Stride : y*dst.Stride+width*4]
+			for i := 0; i < len(acc); i += 4 {
+				store(out[i:i+4:i+4], acc[i], acc[i+1], acc[i+2], acc[i+3])
+			}
+		}
+	})
+	return dst
+}
+
+// store rounds and clamps a premultiplied sample, negative lobes may overshoot.
+func store(p []uint8, r, g, b, a float32) {
+	p[3] = clamp(a)
+	p[0] = min(clamp(r), p[3])
+	p[1] = min(clamp(g), p[3])
+	p[2] = min(clamp(b), p[3])
+}
+
+func clamp(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// parallel splits [0, n) into ranges processed concurrently.
+func parallel(n int, f func(lo, hi int)) {
+	workers := min(runtime.GOMAXPROCS(0), max(1, n/32))
+	if workers == 1 {
+		f(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	step := (n + workers - 1) / workers
+	for lo := 0; lo < n; lo += step {
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			f(lo, hi)
+		}(lo, min(n, lo+step))
+	}
+	wg.Wait()
+}
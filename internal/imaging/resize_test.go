@@ -0,0 +1,141 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func uniform(c color.RGBA, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func photo(w, h int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Y[img.YOffset(x, y)] = uint8((x*7 + y*3) % 256)
+			img.Cb[img.COffset(x, y)] = uint8(x % 256)
+			img.Cr[img.COffset(x, y)] = uint8(y % 256)
+		}
+	}
+	return img
+}
+
+func near(a, b uint8) bool {
+	d := int(a) - int(b)
+	return d >= -1 && d <= 1
+}
+
+func TestResizeUniform(t *testing.T) {
+	c := color.RGBA{R: 200, G: 100, B: 50, A: 255}
+	src := uniform(c, 97, 61)
+	for name, f := range map[string]Filter{"area": Area, "catmullrom": CatmullRom, "lanczos": Lanczos} {
+		for _, size := range []image.Point{{31, 17}, {200, 150}, {97, 20}} {
+			dst := Resize(src, size.X, size.Y, f)
+			if dst.Bounds().Size() != size {
+				t.Fatalf("%s: size should be %v, but %v", name, size, dst.Bounds().Size())
+			}
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					got := dst.RGBAAt(x, y)
+					if !near(got.R, c.R) || !near(got.G, c.G) || !near(got.B, c.B) || got.A != 255 {
+						t.Fatalf("%s %v: pixel (%d,%d) should be %v, but %v", name, size, x, y, c, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestAreaAverage(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 6, 1))
+	copy(src.Pix, []uint8{0, 90, 30, 30, 255, 255})
+	dst := Resize(src, 3, 1, Area)
+	for x, want := range []uint8{45, 30, 255} {
+		if got := dst.RGBAAt(x, 0).R; !near(got, want) {
+			t.Errorf("pixel %d should average to %d, but %d", x, want, got)
+		}
+	}
+	// a third of the way: 0*1 + 90*0.5 over 1.5 pixels
+	dst = Resize(src, 4, 1, Area)
+	if got := dst.RGBAAt(0, 0).R; !near(got, 30) {
+		t.Errorf("partial coverage should weight 30, but %d", got)
+	}
+}
+
+func TestYCbCrMatchesGeneric(t *testing.T) {
+	src := photo(64, 48)
+	rgba := image.NewRGBA(src.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), src, image.Point{}, draw.Src)
+	converted := ToRGBA(src)
+	for i := range rgba.Pix {
+		if !near(rgba.Pix[i], converted.Pix[i]) {
+			t.Fatalf("converted byte %d should be %d, but %d", i, rgba.Pix[i], converted.Pix[i])
+		}
+	}
+	a, b := Resize(src, 20, 15, CatmullRom), Resize(rgba, 20, 15, CatmullRom)
+	for i := range a.Pix {
+		if !near(a.Pix[i], b.Pix[i]) {
+			t.Fatalf("resized byte %d should be %d, but %d", i, b.Pix[i], a.Pix[i])
+		}
+	}
+}
+
+func TestPremultipliedAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	src.SetNRGBA(1, 0, color.NRGBA{G: 255, A: 0})
+	got := Resize(src, 1, 1, Lanczos).RGBAAt(0, 0)
+	// the transparent pixel must not bleed its colour
+	if got.G != 0 || !near(got.R, 128) || !near(got.A, 128) {
+		t.Errorf("unexpected blend %v", got)
+	}
+}
+
+func TestFit(t *testing.T) {
+	src := photo(4032, 3024)
+	if got := Fit(src, 512, 512, Area).Bounds().Size(); got != image.Pt(512, 384) {
+		t.Errorf("landscape should fit in 512x384, but %v", got)
+	}
+	if got := Fit(photo(300, 1200), 512, 512, Area).Bounds().Size(); got != image.Pt(128, 512) {
+		t.Errorf("portrait should fit in 128x512, but %v", got)
+	}
+	small := uniform(color.RGBA{A: 255}, 10, 10)
+	if Fit(small, 512, 512, Area) != small {
+		t.Errorf("images that fit should not be copied")
+	}
+}
+
+func benchmarkResize(b *testing.B, f Filter) {
+	src := photo(4032, 3024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		Resize(src, 1280, 960, f)
+	}
+}
+
+func BenchmarkResizeArea(b *testing.B) {
+	benchmarkResize(b, Area)
+}
+
+func BenchmarkResizeCatmullRom(b *testing.B) {
+	benchmarkResize(b, CatmullRom)
+}
+
+func BenchmarkResizeLanczos(b *testing.B) {
+	benchmarkResize(b, Lanczos)
+}
+
+func BenchmarkToRGBA(b *testing.B) {
+	src := photo(4032, 3024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		ToRGBA(src)
+	}
+}
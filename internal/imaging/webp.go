@@ -0,0 +1,141 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+const (
+	webpAlphaFlag = 0x10
+	webpExifFlag  = 0x08
+	webpXMPFlag   = 0x04
+	webpAnimFlag  = 0x02
+)
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// webpAnimated checks the animation flag of the extended header.
+func webpAnimated(header []byte) bool {
+	return len(header) >= 21 && string(header[12:16]) == "VP8X" && header[20]&webpAnimFlag != 0
+}
+
+type riffChunk struct {
+	kind string
+	data []byte
+}
+
+// riffChunks splits data into chunks, a truncated tail is dropped.
+func riffChunks(data []byte) []riffChunk {
+	var chunks []riffChunk
+	for i := 0; i+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if n < 0 || i+8+n > len(data) {
+			break
+		}
+		chunks = append(chunks, riffChunk{kind: string(data[i : i+4]), data: data[i+8 : i+8+n]})
+		// chunks are padded to an even size
+		i += 8 + n + n&1
+	}
+	return chunks
+}
+
+func appendRIFFChunk(out []byte, kind string, data []byte) []byte {
+	out = append(out, kind...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if len(data)&1 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// decodeWebP decodes every frame by rewriting it as a still WebP of its own.
+func decodeWebP(data []byte) (*Animation, error) {
+	a := &Animation{}
+	chunks := riffChunks(data[12:])
+	// size everything up before the first frame is decoded
+	var budget frameBudget
+	for _, c := range chunks {
+		switch c.kind {
+		case "VP8X":
+			if len(c.data) < 10 {
+				return nil, errors.New("imaging: invalid webp header")
+			}
+			a.Width, a.Height = uint24(c.data[4:])+1, uint24(c.data[7:])+1
+			if err := checkSize(a.Width, a.Height); err != nil {
+				return nil, err
+			}
+		case "ANMF":
+			if len(c.data) < 16 {
+				return nil, errors.New("imaging: invalid webp frame")
+			}
+			if err := budget.add(uint24(c.data[6:])+1, uint24(c.data[9:])+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, c := range chunks {
+		switch c.kind {
+		case "ANIM":
+			if len(c.data) >= 6 {
+				a.Plays = int(binary.LittleEndian.Uint16(c.data[4:]))
+			}
+		case "ANMF":
+			img, err := decodeWebPFrame(c.data[16:], uint24(c.data[6:])+1, uint24(c.data[9:])+1)
+			if err != nil {
+				if len(a.Frames) > 0 {
+					// play what decoded of a damaged file
+					return a, nil
+				}
+				return nil, err
+			}
+			frame := Frame{
+				Image:   img,
+				Offset:  image.Pt(uint24(c.data)*2, uint24(c.data[3:])*2),
+				Delay:   clampDelay(time.Duration(uint24(c.data[12:])) * time.Millisecond),
+				Replace: c.data[15]&0x02 != 0,
+			}
+			if c.data[15]&0x01 != 0 {
+				frame.Disposal = DisposeBackground
+			}
+			a.Frames = append(a.Frames, frame)
+		}
+	}
+	if len(a.Frames) == 0 {
+		return nil, errNoFrames
+	}
+	return a, nil
+}
+
+// decodeWebPFrame wraps the alpha and bitstream chunks of a frame in a file of its own.
+func decodeWebPFrame(data []byte, width, height int) (image.Image, error) {
+	var flags byte
+	var body []byte
+	for _, c := range riffChunks(data) {
+		switch c.kind {
+		case "ALPH":
+			flags |= webpAlphaFlag
+			fallthrough
+		case "VP8 ", "VP8L":
+			body = appendRIFFChunk(body, c.kind, c.data)
+		}
+	}
+	header := []byte{flags, 0, 0, 0}
+	header = append(header, byte(width-1), byte((width-1)>>8), byte((width-1)>>16))
+	header = append(header, byte(height-1), byte((height-1)>>8), byte((height-1)>>16))
+	file := appendRIFFChunk([]byte("WEBP"), "VP8X", header)
+	file = append(file, body...)
+	out := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(file)))
+	return webp.Decode(bytes.NewReader(append(out, file...)))
+}
@@ -7,6 +7,7 @@ import (
 	"mushin/ui/native"
 	"mushin/ui/view"
 	"runtime"
+	"time"
 
 	"gioui.org/app"
 	"gioui.org/io/event"
@@ -39,6 +40,10 @@ func Draw(window *app.Window, c *wi.Client) error {
 		switch e := evt.(type) {
 		// this is sent when the application is closed
 		case app.DestroyEvent:
+			view.DefaultPresence.Leave(time.Second)
+			m.MessageEditor.Drafts.Flush()
+			view.Playbacks.Flush()
+			view.Enhancement.Flush()
 			wi.DefaultClient.Store()
 			return e.Err
 		case app.ConfigEvent:
@@ -46,15 +51,25 @@ func Draw(window *app.Window, c *wi.Client) error {
 				log.Printf("focus lost")
 				wi.DefaultClient.Store()
 				m.MessageKeeper.Flush()
+				m.MessageEditor.Drafts.Flush()
+				view.Playbacks.Flush()
+				view.Enhancement.Flush()
 				if runtime.GOOS == "android" || runtime.GOOS == "ios" {
-					wi.DefaultClient.SignOut()
+					view.DefaultPresence.SetLocal(view.Offline)
+					view.Downloads.Suspend()
+					// signed out once offline is announced
+					view.DefaultPresence.Do(func() { wi.DefaultClient.SignOut() })
+				} else {
+					view.DefaultPresence.SetLocal(view.Away)
 				}
 			} else {
 				log.Printf("focused")
-				go func() {
+				view.DefaultPresence.Do(func() {
 					wi.DefaultClient.SignIn()
 					wi.DefaultClient.Pull()
-				}()
+				})
+				view.DefaultPresence.SetLocal(view.Online)
+				view.DefaultPresence.Do(view.Downloads.Resume)
 			}
 
 		// this is sent when the application should re-render.
@@ -23,6 +23,7 @@ type ModalContent struct {
 
 type Header struct {
 	*material.Theme
+	Title       string
 	closeButton widget.Clickable
 	closeIcon   *widget.Icon
 }
@@ -35,13 +36,24 @@ func NewModalContent(theme *material.Theme, onClose func()) *ModalContent {
 	iconClear, _ := widget.NewIcon(icons.ContentClear)
 	return &ModalContent{
 		Theme:   theme,
-		header:  Header{Theme: theme, closeIcon: iconClear},
+		header:  Header{Theme: theme, Title: "Settings", closeIcon: iconClear},
 		OnClose: onClose,
 		List:    layout.List{Axis: layout.Vertical},
 	}
 }
 
+func (m *ModalContent) SetTitle(title string) {
+	m.header.Title = title
+}
+
 func (m *ModalContent) DrawContent(gtx layout.Context, contentWidget layout.Widget) layout.Dimensions {
+	return m.DrawList(gtx, 1, func(gtx layout.Context, index int) layout.Dimensions {
+		return contentWidget(gtx)
+	})
+}
+
+// DrawList draws the content as n list elements, only the visible ones are laid out.
+func (m *ModalContent) DrawList(gtx layout.Context, n int, element layout.ListElement) layout.Dimensions {
 	if m.header.CloseButtonClicked(gtx) {
 		if m.OnClose != nil {
 			m.OnClose()
@@ -57,9 +69,7 @@ func (m *ModalContent) DrawContent(gtx layout.Context, contentWidget layout.Widg
 		}),
 		layout.Rigid(layout.Hr{Height: unit.Dp(1), Color: color.NRGBA(colornames.Grey300)}.Layout),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-			return m.List.Layout(gtx, 1, func(gtx layout.Context, index int) layout.Dimensions {
-				return contentWidget(gtx)
-			})
+			return m.List.Layout(gtx, n, element)
 		}),
 	)
 	call := mac.Stop()
@@ -72,7 +82,7 @@ func (h *Header) Layout(gtx layout.Context) layout.Dimensions {
 	return layout.Flex{Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
 		layout.Rigid(layout.Spacer{Width: unit.Dp(24)}.Layout),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-			bd := material.Body1(h.Theme, "Settings")
+			bd := material.Body1(h.Theme, h.Title)
 			bd.TextSize = unit.Sp(18)
 			bd.Font.Weight = font.ExtraBold
 			bd.Color = h.Theme.ContrastBg
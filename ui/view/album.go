@@ -0,0 +1,246 @@
+package view
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"mushin/assets/fonts"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/CoyAce/wi"
+)
+
+// AlbumItem is one image of an album. Preview names the smaller copy sent
+// ahead of a large photo, it is shown until the photo arrives.
+type AlbumItem struct {
+	Filename string
+	FileId   uint32 `json:",omitempty"`
+	Path     string `json:",omitempty"`
+	Preview  string `json:",omitempty"`
+}
+
+// AlbumControl groups several images sent together, the caption lives in TextControl.
+type AlbumControl struct {
+	Album []AlbumItem `json:",omitempty"`
+}
+
+// AlbumManifest announces an album before its images are sent, FileIds
+// are the ids the images are sent with and Previews the previews sent ahead
+// of them, empty for small photos, in the order of Files.
+type AlbumManifest struct {
+	Files    []string `json:"files"`
+	FileIds  []uint32 `json:"fileIds"`
+	Previews []string `json:"previews,omitempty"`
+	Caption  string   `json:"caption,omitempty"`
+}
+
+func NewAlbumMessage(contacts Contacts, items []AlbumItem, caption string) *Message {
+	return &Message{
+		State: Stateless,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		TextControl:  NewTextControl(caption),
+		AlbumControl: AlbumControl{Album: items},
+		Contacts:     contacts,
+		MessageType:  Album,
+		CreatedAt:    time.Now(),
+	}
+}
+
+// SendAlbum sends photos as one album message with an optional caption.
+// The previews of large photos are sent first, then the photos.
+func SendAlbum(photos []FileDescription, caption string) {
+	items := make([]AlbumItem, 0, len(photos))
+	previews := make([]string, len(photos))
+	manifest := &AlbumManifest{Caption: caption}
+	for i, fd := range photos {
+		id := wi.Hash(unsafe.Pointer(&photos[i]))
+		item := AlbumItem{Filename: fd.Name, FileId: id, Path: fd.Path}
+		if !isAnimated(fd.Path) {
+			if m, preview, ok := makeDerivatives(fd, id); ok {
+				item.Preview, previews[i] = m.Preview, preview
+			}
+		}
+		items = append(items, item)
+		manifest.Files = append(manifest.Files, fd.Name)
+		manifest.FileIds = append(manifest.FileIds, id)
+		manifest.Previews = append(manifest.Previews, item.Preview)
+	}
+	message := NewAlbumMessage(FromMyself(), items, caption)
+	MessageBox <- message
+	err := SendControl(ControlMessage{Kind: ControlAlbum, Album: manifest})
+	if err != nil {
+		log.Printf("send album failed, %v", err)
+		message.State = Failed
+		return
+	}
+	message.State = Sent
+	for i, preview := range previews {
+		if preview == "" {
+			continue
+		}
+		if err = sendPreview(preview, items[i].Preview); err != nil {
+			log.Printf("send album preview failed, %v", err)
+		}
+	}
+	for i, fd := range photos {
+		if err = sendPhotoContent(fd, items[i].FileId); err != nil {
+			log.Printf("send album image failed, %v", err)
+			message.State = Failed
+		}
+	}
+}
+
+// sendPhotoContent sends fd with the file id id.
+func sendPhotoContent(fd FileDescription, id uint32) error {
+	fd = outgoingPhoto(fd)
+	opCode := wi.OpSendImage
+	if isAnimated(fd.Path) {
+		opCode = wi.OpSendGif
+	}
+	return wi.DefaultClient.SendFile(fd.Content(), opCode, id, fd.Name, uint64(fd.Size), 0)
+}
+
+// albumRegistry remembers which received images belong to an album,
+// so that they are rendered inside the album instead of on their own.
+// Images are matched by the file ids the album lists. Claims are kept as
+// long as their album, so an image replayed later is still hidden.
+type albumRegistry struct {
+	claimed map[string]bool
+	lock    sync.Mutex
+}
+
+func albumKey(sender string, fileId uint32) string {
+	return fmt.Sprintf("%s/%d", sender, fileId)
+}
+
+func (r *albumRegistry) Claim(m *Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, item := range m.Album {
+		if item.FileId != 0 {
+			r.claimed[albumKey(m.Sender, item.FileId)] = true
+		}
+	}
+}
+
+func (r *albumRegistry) Claimed(m *Message) bool {
+	if m.MessageType != Image && m.MessageType != GIF || m.FileId == 0 {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.claimed[albumKey(m.Sender, m.FileId)]
+}
+
+var Albums = &albumRegistry{claimed: make(map[string]bool)}
+
+// expectAlbumPreviews waits for the previews of a received album that
+// haven't arrived yet.
+func (m *Message) expectAlbumPreviews() {
+	if m.isMe() {
+		return
+	}
+	for _, item := range m.Album {
+		if item.Preview == "" {
+			continue
+		}
+		if _, err := os.Stat(GetPath(m.Sender, item.Preview)); err == nil {
+			continue
+		}
+		Previews.Expect(m.Sender, item.Preview, nil)
+	}
+}
+
+func (m *Message) albumItemPath(item AlbumItem) string {
+	if !m.isMe() {
+		return GetPath(m.Sender, item.Filename)
+	}
+	if runtime.GOOS == "ios" {
+		return GetExternalDir() + filepath.Base(item.Path)
+	}
+	return item.Path
+}
+
+func albumColumns(n int) int {
+	switch n {
+	case 1:
+		return 1
+	case 2, 4:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func (m *Message) drawAlbum(gtx layout.Context) layout.Dimensions {
+	macro := op.Record(gtx.Ops)
+	d := layout.UniformInset(unit.Dp(4)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(m.drawAlbumGrid),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if m.Text == "" {
+					return layout.Dimensions{}
+				}
+				return layout.Inset{Top: unit.Dp(8), Left: unit.Dp(8), Right: unit.Dp(8), Bottom: unit.Dp(4)}.Layout(gtx,
+					material.Body1(m.Theme, m.Text).Layout)
+			}),
+		)
+	})
+	call := macro.Stop()
+	m.drawBorder(gtx, d, call)
+	return d
+}
+
+func (m *Message) drawAlbumGrid(gtx layout.Context) layout.Dimensions {
+	n := len(m.Album)
+	cols := albumColumns(n)
+	gap := gtx.Dp(4)
+	cell := (gtx.Constraints.Max.X - gap*(cols-1)) / cols
+	rows := (n + cols - 1) / cols
+	for i, item := range m.Album {
+		x, y := i%cols*(cell+gap), i/cols*(cell+gap)
+		stack := op.Offset(image.Pt(x, y)).Push(gtx.Ops)
+		m.drawAlbumCell(gtx, item, cell)
+		stack.Pop()
+	}
+	return layout.Dimensions{Size: image.Pt(cols*cell+(cols-1)*gap, rows*cell+(rows-1)*gap)}
+}
+
+// drawAlbumCell draws the image of item, or its preview until it arrived.
+func (m *Message) drawAlbumCell(gtx layout.Context, item AlbumItem, size int) {
+	path := m.albumItemPath(item)
+	rect := image.Rectangle{Max: image.Pt(size, size)}
+	defer clip.UniformRRect(rect, gtx.Dp(6)).Push(gtx.Ops).Pop()
+	paint.Fill(gtx.Ops, fonts.DefaultTheme.Bg)
+	gtx.Constraints = layout.Exact(rect.Max)
+	// until the kind is known the cell is drawn as a still, it may show the preview
+	if animated, known := animatedState(path); known && animated {
+		gifImg := m.loadGif(path)
+		if gifImg.Animation != nil {
+			gifImg.Layout(gtx, ShortEdgeFixed)
+		}
+		return
+	}
+	img := m.loadImage(path)
+	if (img == nil || *img == nil) && item.Preview != "" && !m.isMe() {
+		img = m.loadImage(GetPath(m.Sender, item.Preview))
+	}
+	if img == nil || *img == nil {
+		return
+	}
+	widget.Image{Src: paint.NewImageOp(*img), Fit: widget.Cover, Position: layout.Center}.Layout(gtx)
+}
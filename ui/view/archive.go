@@ -0,0 +1,335 @@
+package view
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFolderListing caps the entries announced with a folder, the archive holds all of them.
+const maxFolderListing = 100
+
+// FolderSummary describes a folder sent as a tar archive.
+type FolderSummary struct {
+	Files   int      `json:"files"`
+	Size    int64    `json:"size"`
+	Entries []string `json:"entries,omitempty"`
+}
+
+// FolderManifest announces a folder before it is published.
+type FolderManifest struct {
+	FileId uint32 `json:"fileId"`
+	FolderSummary
+}
+
+// archiveSegment is a run of bytes of a tar stream, either in memory
+// (headers, padding) or read from a file.
+type archiveSegment struct {
+	offset int64
+	size   int64
+	data   []byte
+	path   string
+}
+
+// FolderArchive is a tar stream of a directory computed from its listing,
+// content is read from the files on demand so no temporary copy is made.
+type FolderArchive struct {
+	Summary  FolderSummary
+	segments []archiveSegment
+	size     int64
+	file     *os.File
+	filePath string
+	lock     sync.Mutex
+}
+
+// NewFolderArchive walks root and lays out its tar stream, root itself is the top entry.
+func NewFolderArchive(root string) (*FolderArchive, error) {
+	a := &FolderArchive{}
+	base := filepath.Base(root)
+	var buf bytes.Buffer
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			// links and devices are not sent
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(filepath.Join(base, rel))
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime().Truncate(time.Second),
+		}
+		if info.IsDir() {
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+		} else {
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+		}
+		buf.Reset()
+		if err = tar.NewWriter(&buf).WriteHeader(hdr); err != nil {
+			return err
+		}
+		a.add(archiveSegment{data: bytes.Clone(buf.Bytes())})
+		if info.IsDir() {
+			return nil
+		}
+		a.add(archiveSegment{size: hdr.Size, path: path})
+		if pad := (512 - hdr.Size%512) % 512; pad > 0 {
+			a.add(archiveSegment{data: make([]byte, pad)})
+		}
+		a.Summary.Files++
+		a.Summary.Size += hdr.Size
+		if len(a.Summary.Entries) < maxFolderListing {
+			a.Summary.Entries = append(a.Summary.Entries, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// end of archive marker
+	a.add(archiveSegment{data: make([]byte, 1024)})
+	return a, nil
+}
+
+func (a *FolderArchive) add(s archiveSegment) {
+	if s.data != nil {
+		s.size = int64(len(s.data))
+	}
+	s.offset = a.size
+	a.segments = append(a.segments, s)
+	a.size += s.size
+}
+
+// Size is the length of the tar stream.
+func (a *FolderArchive) Size() int64 {
+	return a.size
+}
+
+func (a *FolderArchive) ReadAt(p []byte, off int64) (int, error) {
+	if off >= a.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(a.segments), func(i int) bool {
+		return a.segments[i].offset+a.segments[i].size > off
+	})
+	n := 0
+	for ; i < len(a.segments) && n < len(p); i++ {
+		s := a.segments[i]
+		start := off + int64(n) - s.offset
+		want := min(int64(len(p)-n), s.size-start)
+		if s.data != nil {
+			n += copy(p[n:], s.data[start:start+want])
+			continue
+		}
+		read, err := a.readFile(s.path, p[n:n+int(want)], start)
+		n += read
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// the file shrank since the listing
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (a *FolderArchive) readFile(path string, p []byte, off int64) (int, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.filePath != path {
+		if a.file != nil {
+			_ = a.file.Close()
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			a.file, a.filePath = nil, ""
+			return 0, err
+		}
+		a.file, a.filePath = f, path
+	}
+	return a.file.ReadAt(p, off)
+}
+
+func (a *FolderArchive) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file, a.filePath = nil, ""
+	return err
+}
+
+type archiveReader struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// Open returns a reader over the stream laid out by NewFolderArchive, each
+// with a file handle of its own.
+func (a *FolderArchive) Open() io.ReadSeekCloser {
+	r := &FolderArchive{Summary: a.Summary, segments: a.segments, size: a.size}
+	return archiveReader{SectionReader: io.NewSectionReader(r, 0, r.Size()), Closer: r}
+}
+
+// folderArchives keeps the layout of each folder sent, by path, so that the
+// stream peers download matches the size that was announced.
+var folderArchives sync.Map
+
+// OpenFolderArchive streams root as a tar archive, the directory is only
+// walked when it was not sent in this session.
+func OpenFolderArchive(root string) (io.ReadSeekCloser, error) {
+	if a, ok := folderArchives.Load(root); ok {
+		return a.(*FolderArchive).Open(), nil
+	}
+	a, err := NewFolderArchive(root)
+	if err != nil {
+		return nil, err
+	}
+	folderArchives.Store(root, a)
+	return a.Open(), nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// SendFolder announces the listing of a folder, then publishes it as a tar archive.
+func SendFolder(fd FileDescription, appendFile func(*FileDescription)) {
+	a, err := NewFolderArchive(fd.Path)
+	if err != nil {
+		log.Printf("List folder %s failed: %v", fd.Path, err)
+		HintRequest <- "❌文件夹读取失败"
+		return
+	}
+	folderArchives.Store(fd.Path, a)
+	fd.Name = filepath.Base(fd.Path) + ".tar"
+	fd.Size = a.Size()
+	publishFile(fd, appendFile, &a.Summary, func(id uint32) {
+		err := SendControl(ControlMessage{Kind: ControlFolder, Folder: &FolderManifest{FileId: id, FolderSummary: a.Summary}})
+		if err != nil && !errors.Is(err, errOldPeers) {
+			log.Printf("send folder manifest failed, %v", err)
+		}
+	})
+}
+
+// folderRegistry pairs folder manifests with the file messages they describe,
+// the control message and the publish may arrive in any order.
+type folderRegistry struct {
+	summaries map[string]*FolderSummary
+	waiting   map[string]*FileControl
+	lock      sync.Mutex
+}
+
+func folderKey(sender string, fileId uint32) string {
+	return fmt.Sprintf("%s/%d", sender, fileId)
+}
+
+// Remember stores a received manifest, or attaches it to a file already shown.
+func (r *folderRegistry) Remember(sender string, m *FolderManifest) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := folderKey(sender, m.FileId)
+	summary := m.FolderSummary
+	if f, ok := r.waiting[key]; ok {
+		f.Folder = &summary
+		delete(r.waiting, key)
+		invalidate()
+		return
+	}
+	r.summaries[key] = &summary
+}
+
+// Attach sets the summary of a received archive if it is known, or waits for it.
+func (r *folderRegistry) Attach(sender string, f *FileControl) {
+	if !strings.HasSuffix(f.Filename, ".tar") {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := folderKey(sender, f.FileId)
+	if s, ok := r.summaries[key]; ok {
+		f.Folder = s
+		delete(r.summaries, key)
+		return
+	}
+	r.waiting[key] = f
+}
+
+var Folders = &folderRegistry{summaries: make(map[string]*FolderSummary), waiting: make(map[string]*FileControl)}
+
+// ExtractArchive unpacks the tar archive src into dir, entries escaping dir are skipped.
+func ExtractArchive(src string, dir string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			log.Printf("skip archive entry %s", hdr.Name)
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = extractFile(tr, target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm|0600)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,114 @@
+package view
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFolderArchive(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "album")
+	files := map[string]string{
+		"a.txt":       "hello",
+		"sub/b.txt":   "world!",
+		"sub/c/d.bin": string(make([]byte, 1500)),
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := NewFolderArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Summary.Files != len(files) {
+		t.Errorf("files should be %d, but %d", len(files), a.Summary.Files)
+	}
+	r, err := OpenFolderArchive(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != a.Size() {
+		t.Errorf("archive size should be %d, but %d", a.Size(), len(data))
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(r)
+	read := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel("album", filepath.FromSlash(hdr.Name))
+		if want := files[filepath.ToSlash(rel)]; string(content) != want {
+			t.Errorf("%s content mismatch", hdr.Name)
+		}
+		read++
+	}
+	if read != len(files) {
+		t.Errorf("archive should hold %d files, but %d", len(files), read)
+	}
+
+	src := filepath.Join(t.TempDir(), "album.tar")
+	if err = os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = ExtractArchive(src, dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dir, "album", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("extracted %s content mismatch", name)
+		}
+	}
+}
+
+func TestExtractArchiveTraversal(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "evil.tar")
+	file, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(file)
+	_ = tw.WriteHeader(&tar.Header{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3})
+	_, _ = tw.Write([]byte("bad"))
+	_ = tw.Close()
+	_ = file.Close()
+	dir := filepath.Join(t.TempDir(), "out")
+	if err = ExtractArchive(src, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "..", "escape.txt")); err == nil {
+		t.Errorf("entries escaping the target dir should be skipped")
+	}
+}
@@ -0,0 +1,298 @@
+package view
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mushin/assets/fonts"
+	"mushin/internal/imaging"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+
+	"gioui.org/io/transfer"
+	"github.com/CoyAce/wi"
+)
+
+// Clipboard and drop MIME types accepted as attachments.
+const (
+	MimePNG     = "image/png"
+	MimeJPEG    = "image/jpeg"
+	MimeURIList = "text/uri-list"
+)
+
+var imageMimeExt = map[string]string{
+	MimePNG:  ".png",
+	MimeJPEG: ".jpg",
+}
+
+func isPhoto(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".apng", ".webp", ".gif":
+		return true
+	}
+	return false
+}
+
+// SendPhoto sends a single image or gif inline. Large photos are sent as a
+// placeholder and a preview, the original is downloaded on demand.
+func SendPhoto(fd FileDescription, appendFile func(*FileDescription)) {
+	mType := Image
+	if isAnimated(fd.Path) {
+		mType = GIF
+	} else if sendProgressivePhoto(fd, appendFile) {
+		return
+	}
+	message := &Message{
+		State: Stateless,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		Contacts:    FromMyself(),
+		MessageType: mType,
+		FileControl: FileControl{Path: fd.Path, Filename: fd.Name},
+		CreatedAt:   time.Now(),
+	}
+	MessageBox <- message
+	err := sendPhotoContent(fd, wi.Hash(unsafe.Pointer(&fd)))
+	if err != nil {
+		log.Printf("send image failed, %v", err)
+		message.State = Failed
+	} else {
+		message.State = Sent
+	}
+}
+
+// outgoingPhoto returns fd marked to be sent without location and device
+// metadata, fd is returned as is when stripping is off or not needed.
+func outgoingPhoto(fd FileDescription) FileDescription {
+	if !Prefs.Get().StripMetadata {
+		return fd
+	}
+	r, err := Open(fd.Path)
+	if err != nil {
+		log.Printf("open %s failed: %v", fd.Path, err)
+		return fd
+	}
+	data, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		log.Printf("read %s failed: %v", fd.Path, err)
+		return fd
+	}
+	stripped, err := imaging.StripMetadata(data)
+	if err != nil {
+		if !errors.Is(err, imaging.ErrUnsupported) {
+			log.Printf("strip metadata of %s failed: %v", fd.Path, err)
+		}
+		return fd
+	}
+	if len(stripped) == len(data) {
+		return fd
+	}
+	fd.Strip, fd.Size = true, int64(len(stripped))
+	return fd
+}
+
+// warnUnstripped tells the user that a HEIC photo is sent with its metadata,
+// it is published as a file and not rewritten.
+func warnUnstripped(fd FileDescription) {
+	switch strings.ToLower(filepath.Ext(fd.Name)) {
+	case ".heic", ".heif":
+		if Prefs.Get().StripMetadata {
+			HintRequest <- "⚠️HEIC照片的位置信息未移除"
+		}
+	}
+}
+
+// PublishFile announces a file that peers may download on demand.
+func PublishFile(fd FileDescription, appendFile func(*FileDescription)) {
+	warnUnstripped(fd)
+	publishFile(fd, appendFile, nil, nil)
+}
+
+// publishFile publishes fd, announce is called with the file id right before
+// the publish so that peers can prepare for it.
+func publishFile(fd FileDescription, appendFile func(*FileDescription), folder *FolderSummary, announce func(id uint32)) {
+	id := wi.Hash(unsafe.Pointer(&fd))
+	fc := FileControl{
+		Filename: fd.Name,
+		FileId:   id,
+		Path:     fd.Path,
+		Size:     uint64(fd.Size),
+		Mime:     NewMine(fd.Name),
+		Folder:   folder,
+	}
+	message := &Message{
+		State: Stateless,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		FileControl: fc,
+		Contacts:    FromMyself(),
+		MessageType: File,
+		CreatedAt:   time.Now(),
+	}
+	MessageBox <- message
+	fd.ID = id
+	appendFile(&fd)
+	if announce != nil {
+		announce(id)
+	}
+	err := wi.DefaultClient.PublishFile(fd.Name, uint64(fd.Size), id)
+	if err != nil {
+		log.Printf("Publish file failed, %v", err)
+		message.State = Failed
+	} else {
+		message.State = Sent
+	}
+}
+
+// SendAttachment sends photos inline, folders as archives and publishes everything else.
+func SendAttachment(fd FileDescription, appendFile func(*FileDescription)) {
+	switch {
+	case isDir(fd.Path):
+		SendFolder(fd, appendFile)
+	case isPhoto(fd.Name):
+		SendPhoto(fd, appendFile)
+	default:
+		PublishFile(fd, appendFile)
+	}
+}
+
+// stagingDir holds clipboard images until they are sent or discarded.
+func stagingDir() string {
+	return GetConfig("paste")
+}
+
+// StageImage writes clipboard image data into the staging dir so that it can
+// be sent like a picked file.
+func StageImage(mime string, r io.Reader) (FileDescription, error) {
+	ext, ok := imageMimeExt[mime]
+	if !ok {
+		return FileDescription{}, fmt.Errorf("unsupported mime type: %s", mime)
+	}
+	name := fmt.Sprintf("paste_%d%s", time.Now().UnixMilli(), ext)
+	path := filepath.Join(stagingDir(), name)
+	wi.Mkdir(stagingDir())
+	file, err := os.Create(path)
+	if err != nil {
+		return FileDescription{}, err
+	}
+	defer file.Close()
+	size, err := io.Copy(file, r)
+	if err != nil {
+		return FileDescription{}, err
+	}
+	if size == 0 {
+		_ = os.Remove(path)
+		return FileDescription{}, errors.New("clipboard is empty")
+	}
+	return FileDescription{Name: name, Path: path, Size: size}, nil
+}
+
+func isStaged(path string) bool {
+	return filepath.Dir(path) == filepath.Clean(stagingDir())
+}
+
+// discardStaged removes the staged copy of an attachment that won't be sent.
+func discardStaged(fd FileDescription) {
+	if !isStaged(fd.Path) {
+		return
+	}
+	if err := os.Remove(fd.Path); err != nil {
+		log.Printf("remove %s failed: %v", fd.Path, err)
+	}
+}
+
+// keepStaged moves a staged attachment to the data dir before it is sent,
+// where the sent message keeps showing it from.
+func keepStaged(fd FileDescription) FileDescription {
+	if !isStaged(fd.Path) {
+		return fd
+	}
+	path := GetDataPath(fd.Name)
+	wi.Mkdir(filepath.Dir(path))
+	if err := os.Rename(fd.Path, path); err != nil {
+		// the data dir may be on another volume
+		if err = copyFile(fd.Path, path); err != nil {
+			log.Printf("keep %s failed: %v", fd.Path, err)
+			return fd
+		}
+		_ = os.Remove(fd.Path)
+	}
+	fd.Path = path
+	return fd
+}
+
+// ParseURIList resolves dropped file:// uris (RFC 2483) to local files.
+func ParseURIList(r io.Reader) []FileDescription {
+	var ret []FileDescription
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || u.Scheme != "file" {
+			log.Printf("skip dropped uri %s", line)
+			continue
+		}
+		path := uriPath(u)
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("skip dropped path %s", path)
+			continue
+		}
+		if info.IsDir() {
+			// sent as an archive, its size is known once listed
+			ret = append(ret, FileDescription{Name: info.Name(), Path: path})
+			continue
+		}
+		ret = append(ret, FileDescription{Name: info.Name(), Path: path, Size: info.Size()})
+	}
+	return ret
+}
+
+// uriPath converts a file uri to a native path, file:///C:/dir becomes C:\dir
+// and file://server/share a UNC path on windows.
+func uriPath(u *url.URL) string {
+	path := u.Path
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		path = "//" + u.Host + path
+	} else if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// ReceiveTransfer handles clipboard and drop data delivered to an attachment target.
+func ReceiveTransfer(e transfer.DataEvent, attach func(FileDescription)) {
+	go func() {
+		r := e.Open()
+		defer r.Close()
+		switch e.Type {
+		case MimeURIList:
+			for _, fd := range ParseURIList(r) {
+				attach(fd)
+			}
+		default:
+			fd, err := StageImage(e.Type, r)
+			if err != nil {
+				log.Printf("stage %s failed: %v", e.Type, err)
+				return
+			}
+			attach(fd)
+		}
+	}()
+}
@@ -140,6 +140,11 @@ func ShowIncomingCall(wrq wi.WriteReq) {
 	audioStackAnimation.Appear(time.Now())
 }
 
+// callActive reports whether a call is streaming from the microphone.
+func callActive() bool {
+	return audioMode == Accept && captureCtx.Err() == nil
+}
+
 func EndIncomingCall() {
 	if audioMode != Accept {
 		return
@@ -0,0 +1,443 @@
+package view
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mushin/assets/fonts"
+	"mushin/assets/icons"
+	"mushin/internal/audio"
+	"sync"
+	"time"
+
+	modal "mushin/ui/layout"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	"github.com/gen2brain/malgo"
+)
+
+// AudioPanel picks the microphone and speaker, tests the microphone,
+// calibrates the echo delay between the two and tunes the enhancement of
+// what the microphone picks up.
+type AudioPanel struct {
+	*material.Theme
+	modalContent    *modal.ModalContent
+	config          audio.StreamConfig
+	captures        deviceList
+	playbacks       deviceList
+	testButton      widget.Clickable
+	testCancel      context.CancelFunc
+	levels          audio.LevelRing
+	recent          []audio.Level
+	calibrateButton widget.Clickable
+	resetButton     widget.Clickable
+	calibration     *calibrationRun
+	enhancement     *EnhancerSettings
+}
+
+// calibrationRun is a calibration started from the panel, its goroutine
+// fills in the outcome.
+type calibrationRun struct {
+	cancel context.CancelFunc
+	lock   sync.Mutex
+	done   bool
+	// discarded by a reset, the result must not be kept
+	discarded bool
+	result    audio.Calibration
+	err       error
+}
+
+// discard cancels the run and keeps its result from being stored.
+func (r *calibrationRun) discard() {
+	r.cancel()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.discarded = true
+}
+
+func (r *calibrationRun) isDiscarded() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.discarded
+}
+
+func (r *calibrationRun) finish(result audio.Calibration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.done, r.result, r.err = true, result, err
+}
+
+func (r *calibrationRun) outcome() (done bool, result audio.Calibration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.done, r.result, r.err
+}
+
+// deviceList is the devices of one kind, the first row is the system default.
+type deviceList struct {
+	kind    malgo.DeviceType
+	devices []audio.Device
+	buttons []widget.Clickable
+}
+
+func NewAudioPanel(config audio.StreamConfig) *AudioPanel {
+	p := &AudioPanel{
+		Theme:     fonts.DefaultTheme,
+		config:    config,
+		captures:  deviceList{kind: malgo.Capture},
+		playbacks: deviceList{kind: malgo.Playback},
+	}
+	p.enhancement = NewEnhancerSettings(p.Theme)
+	p.modalContent = modal.NewModalContent(fonts.DefaultTheme, p.dismiss)
+	p.modalContent.SetTitle("Audio")
+	return p
+}
+
+// applyAudioDevices hands the saved device choices to the audio streams.
+func applyAudioDevices(p Preferences) {
+	audio.SelectDevice(malgo.Capture, audio.Selection{ID: p.CaptureDeviceID, Name: p.CaptureDevice})
+	audio.SelectDevice(malgo.Playback, audio.Selection{ID: p.PlaybackDeviceID, Name: p.PlaybackDevice})
+}
+
+func (l *deviceList) refresh(mctx malgo.Context) {
+	devices, err := audio.Devices(mctx, l.kind)
+	if err != nil {
+		log.Printf("list audio devices failed, %s", err)
+	}
+	l.devices = devices
+	l.buttons = make([]widget.Clickable, len(devices)+1)
+}
+
+// update saves a clicked device, the zero selection is the system default.
+func (l *deviceList) update(gtx layout.Context) {
+	for i := range l.buttons {
+		if !l.buttons[i].Clicked(gtx) {
+			continue
+		}
+		var s audio.Selection
+		if i > 0 {
+			s = audio.Selection{ID: l.devices[i-1].ID.String(), Name: l.devices[i-1].Name}
+		}
+		Prefs.Update(func(p *Preferences) {
+			if l.kind == malgo.Capture {
+				p.CaptureDeviceID, p.CaptureDevice = s.ID, s.Name
+			} else {
+				p.PlaybackDeviceID, p.PlaybackDevice = s.ID, s.Name
+			}
+		})
+		applyAudioDevices(Prefs.Get())
+		// the new pair has its own echo delay
+		Enhancement.Apply()
+	}
+}
+
+func (p *AudioPanel) update(gtx layout.Context) {
+	p.captures.update(gtx)
+	p.playbacks.update(gtx)
+	if p.testButton.Clicked(gtx) {
+		if p.testCancel == nil {
+			p.startTest()
+		} else {
+			p.stopTest()
+		}
+	}
+	if callActive() {
+		// the call owns the devices and the echo canceller
+		p.stopCalibration()
+	}
+	if p.calibrateButton.Clicked(gtx) {
+		if p.calibrating() {
+			p.stopCalibration()
+		} else {
+			p.startCalibration()
+		}
+	}
+	if p.resetButton.Clicked(gtx) {
+		if p.calibration != nil {
+			p.calibration.discard()
+		}
+		p.calibration = nil
+		key := calibrationKey(Prefs.Get())
+		Enhancement.Update(func(p *EnhancerProfile) {
+			delete(p.Calibrations, key)
+		})
+	}
+}
+
+// startTest captures from the selected microphone into the meter only.
+func (p *AudioPanel) startTest() {
+	p.stopCalibration()
+	var ctx context.Context
+	ctx, p.testCancel = context.WithCancel(context.Background())
+	p.levels.Reset()
+	config := p.config
+	config.Format = malgo.FormatF32
+	go func() {
+		meter := audio.NewMeter(io.Discard, &p.levels, config.SampleRate, cmp.Or(config.Channels, 1))
+		err := audio.Capture(ctx, meter, config)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("microphone test failed, %s", err)
+		}
+	}()
+}
+
+func (p *AudioPanel) stopTest() {
+	if p.testCancel != nil {
+		p.testCancel()
+		p.testCancel = nil
+	}
+}
+
+func (p *AudioPanel) calibrating() bool {
+	if p.calibration == nil {
+		return false
+	}
+	done, _, _ := p.calibration.outcome()
+	return !done
+}
+
+// startCalibration measures the echo delay of the selected devices and keeps
+// it for them when the echo canceller validates it.
+func (p *AudioPanel) startCalibration() {
+	p.stopTest()
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &calibrationRun{cancel: cancel}
+	p.calibration = run
+	key := calibrationKey(Prefs.Get())
+	config := enhancer.Config()
+	go func() {
+		c, err := audio.Calibrate(ctx, config)
+		switch {
+		case err != nil && !errors.Is(err, context.Canceled):
+			log.Printf("calibrate echo delay failed, %s", err)
+		case err == nil && c.Valid():
+			Enhancement.Update(func(p *EnhancerProfile) {
+				// a reset while the result was on its way wins, the
+				// profile lock orders this against its delete
+				if run.isDiscarded() {
+					return
+				}
+				if p.Calibrations == nil {
+					p.Calibrations = make(map[string]audio.Calibration)
+				}
+				p.Calibrations[key] = c
+			})
+		}
+		run.finish(c, err)
+	}()
+}
+
+func (p *AudioPanel) stopCalibration() {
+	if p.calibration != nil {
+		p.calibration.cancel()
+	}
+}
+
+func (p *AudioPanel) dismiss() {
+	p.stopTest()
+	p.stopCalibration()
+	modal.DefaultModal.Dismiss(nil)
+}
+
+func (p *AudioPanel) Layout(gtx layout.Context) layout.Dimensions {
+	p.update(gtx)
+	prefs := Prefs.Get()
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	margins := layout.Inset{Top: unit.Dp(12), Bottom: unit.Dp(24), Left: unit.Dp(16), Right: unit.Dp(16)}
+	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(p.drawTitle("Microphone")),
+			layout.Rigid(p.drawDevices(&p.captures, audio.Selection{ID: prefs.CaptureDeviceID, Name: prefs.CaptureDevice})),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+			layout.Rigid(p.drawTest),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(p.drawTitle("Speaker")),
+			layout.Rigid(p.drawDevices(&p.playbacks, audio.Selection{ID: prefs.PlaybackDeviceID, Name: prefs.PlaybackDevice})),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(p.drawTitle("Echo delay")),
+			layout.Rigid(p.drawCalibration),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+			layout.Rigid(p.drawTitle("Enhancement")),
+			layout.Rigid(p.enhancement.Layout),
+		)
+	})
+}
+
+func (p *AudioPanel) drawTitle(title string) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		label := material.Label(p.Theme, p.TextSize, title)
+		label.Font.Weight = font.Bold
+		return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, label.Layout)
+	}
+}
+
+func (p *AudioPanel) drawDevices(l *deviceList, selected audio.Selection) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		device, found := selected.Match(l.devices)
+		children := make([]layout.FlexChild, 0, len(l.buttons))
+		for i := range l.buttons {
+			name, checked := "System default", selected == (audio.Selection{})
+			if i > 0 {
+				name, checked = l.devices[i-1].Name, found && l.devices[i-1].ID == device.ID
+			}
+			children = append(children, layout.Rigid(p.drawDevice(&l.buttons[i], name, checked)))
+		}
+		if selected != (audio.Selection{}) && !found {
+			// the saved device is unplugged, streams use the default meanwhile
+			children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(p.Theme, p.TextSize*0.7, selected.Name+" is unplugged, using the system default")
+				label.Font.Style = font.Italic
+				label.Color = warningColor
+				return label.Layout(gtx)
+			}))
+		}
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	}
+}
+
+func (p *AudioPanel) drawDevice(button *widget.Clickable, name string, checked bool) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Top: unit.Dp(6), Bottom: unit.Dp(6)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						label := material.Label(p.Theme, p.TextSize*0.85, name)
+						label.MaxLines = 1
+						return label.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						gtx.Constraints.Min.X = gtx.Dp(20)
+						if !checked {
+							return layout.Dimensions{Size: image.Pt(gtx.Dp(20), 0)}
+						}
+						return icons.CheckCircleIcon.Layout(gtx, p.ContrastBg)
+					}),
+				)
+			})
+		})
+	}
+}
+
+// drawTest is the microphone test button and the level it picks up.
+func (p *AudioPanel) drawTest(gtx layout.Context) layout.Dimensions {
+	text := "Test microphone"
+	if p.testCancel != nil {
+		text = "Stop test"
+		gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(50 * time.Millisecond)})
+	}
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Rigid(material.Button(p.Theme, &p.testButton, text).Layout),
+		layout.Rigid(layout.Spacer{Width: unit.Dp(12)}.Layout),
+		layout.Flexed(1, p.drawMeter),
+	)
+}
+
+// drawCalibration is the calibrate button and the delay the selected devices use.
+func (p *AudioPanel) drawCalibration(gtx layout.Context) layout.Dimensions {
+	text := "Calibrate"
+	status, color := "", p.Fg
+	calibrated, ok := Enhancement.Calibration()
+	if ok {
+		status = fmt.Sprintf("Calibrated to %d ms, %.1f dB of echo removed", calibrated.StreamDelayMs, calibrated.ERLE)
+	} else if config := Enhancement.Get().Config; config.ApmConfig != nil {
+		status = fmt.Sprintf("Not calibrated, using the default %d ms", config.ApmConfig.EchoCancellation.StreamDelayMs)
+	}
+	if p.calibration != nil {
+		done, result, err := p.calibration.outcome()
+		switch {
+		case !done:
+			text = "Stop"
+			status = "Calibrating, keep quiet and turn the speaker up"
+			gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(100 * time.Millisecond)})
+		case errors.Is(err, context.Canceled):
+		case err != nil:
+			status, color = "Calibration failed: "+err.Error(), warningColor
+		case !result.Valid():
+			status, color = fmt.Sprintf("Calibration rejected, only %.1f dB of echo removed at %d ms", result.ERLE, result.StreamDelayMs), warningColor
+		}
+	}
+	inCall := callActive()
+	if inCall {
+		status, color = "Not available during a call", p.Fg
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if inCall {
+						gtx = gtx.Disabled()
+					}
+					return material.Button(p.Theme, &p.calibrateButton, text).Layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(12)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if !ok {
+						return layout.Dimensions{}
+					}
+					return material.Button(p.Theme, &p.resetButton, "Reset").Layout(gtx)
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Label(p.Theme, p.TextSize*0.7, status)
+			label.Font.Style = font.Italic
+			label.Color = color
+			return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, label.Layout)
+		}),
+	)
+}
+
+// drawMeter shows the loudest of the last 100ms, red when it clipped.
+func (p *AudioPanel) drawMeter(gtx layout.Context) layout.Dimensions {
+	size := image.Pt(gtx.Constraints.Max.X, gtx.Dp(8))
+	rect := clip.UniformRRect(image.Rectangle{Max: size}, gtx.Dp(4))
+	track := p.Fg
+	track.A = 40
+	paint.FillShape(gtx.Ops, track, rect.Op(gtx.Ops))
+	if p.testCancel == nil {
+		return layout.Dimensions{Size: size}
+	}
+	p.recent = p.levels.Recent(p.recent[:0], 10)
+	var level audio.Level
+	for _, l := range p.recent {
+		level.RMS = max(level.RMS, l.RMS)
+		level.Peak = max(level.Peak, l.Peak)
+	}
+	norm := max(0, min(1, (float64(level.DB())-meterFloor)/-meterFloor))
+	c := fonts.BrightCyan
+	if level.Clipped() {
+		c = warningColor
+	}
+	bar := clip.UniformRRect(image.Rectangle{Max: image.Pt(int(norm*float64(size.X)), size.Y)}, gtx.Dp(4))
+	paint.FillShape(gtx.Ops, c, bar.Op(gtx.Ops))
+	return layout.Dimensions{Size: size}
+}
+
+// ShowWithModal lists the devices plugged in now and opens the panel.
+func (p *AudioPanel) ShowWithModal() {
+	p.captures.refresh(p.config.MalgoContext)
+	p.playbacks.refresh(p.config.MalgoContext)
+	modal.DefaultModal.Show(p.ZoomInWithModalContent, p.dismiss, component.VisibilityAnimation{
+		Duration: time.Millisecond * 250,
+		State:    component.Invisible,
+		Started:  time.Time{},
+	})
+}
+
+func (p *AudioPanel) ZoomInWithModalContent(gtx layout.Context) layout.Dimensions {
+	gtx.Constraints.Max.X = int(float32(gtx.Constraints.Max.X) * 0.85)
+	gtx.Constraints.Max.Y = int(float32(gtx.Constraints.Max.Y) * 0.85)
+	return p.modalContent.DrawContent(gtx, p.Layout)
+}
@@ -1,9 +1,13 @@
 package view
 
 import (
+	"bytes"
 	"image"
+	"io"
 	"log"
 	"mushin/assets"
+	"mushin/internal/imaging"
+	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
@@ -73,37 +77,47 @@ func (v *Avatar) Layout(gtx layout.Context) layout.Dimensions {
 				return
 			}
 			defer fd.File.Close()
-			if filepath.Ext(fd.Name) == ".gif" {
-				gifImg, err := decodeGif(fd.File)
+			data, err := io.ReadAll(fd.File)
+			if err != nil {
+				log.Printf("Read image failed: %v", err)
+				return
+			}
+			if format := imaging.AnimationFormat(data); format != "" {
+				// the file goes to every peer as is, so it must pass the limits they decode it with
+				if len(data) > maxIconSize {
+					log.Printf("Animated avatar larger than %d bytes", maxIconSize)
+					return
+				}
+				anim, err := imaging.DecodeAnimation(data)
 				if err != nil {
-					log.Printf("Decode gif failed: %v", err)
+					log.Printf("Decode animation failed: %v", err)
 					return
 				}
-				gifPath := GetPath(v.UUID, "icon.gif")
-				v.Gif = LoadGif(gifPath, false)
-				v.GIF = gifImg
+				name := "icon." + format
+				if err = os.WriteFile(GetDataPath(name), data, 0644); err != nil {
+					log.Printf("Save %s failed: %v", name, err)
+					return
+				}
+				v.Gif = StoreGif(GetPath(v.UUID, name), anim)
 				v.AvatarType = GIF_IMG
 				avatar := AvatarCache.LoadOrElseNew(wi.DefaultClient.ID())
 				avatar.Gif = v.Gif
 				avatar.AvatarType = GIF_IMG
-				SaveGif(gifImg, "icon.gif", true)
-				wi.RemoveFile(GetPath(v.UUID, "icon.png"))
+				removeIcons(v.UUID, name)
 			} else {
-				img, err := decodeImage(fd.File)
+				img, err := decodeImage(io.NopCloser(bytes.NewReader(data)))
 				if err != nil {
 					log.Printf("Decode image failed: %v", err)
 					return
 				}
-				if img.Bounds().Dx() > 512 || img.Bounds().Dy() > 512 {
-					img = resizeImage(img, 512, 512)
-				}
+				img = imaging.Fit(img, 512, 512, imaging.Lanczos)
 				v.Image = &img
 				v.AvatarType = IMG
 				avatar := AvatarCache.LoadOrElseNew(wi.DefaultClient.ID())
 				avatar.Image = &img
 				avatar.AvatarType = IMG
 				SaveImg(img, "icon.png", true)
-				wi.RemoveFile(GetPath(v.UUID, "icon.gif"))
+				removeIcons(v.UUID, "icon.png")
 			}
 			// sync to server
 			if v.OnChange != nil {
@@ -167,13 +181,18 @@ func (v *Avatar) Reload(avatarType AvatarType) {
 	}
 	defer atomic.StoreInt32(&v.loadState, 0)
 	if avatarType == GIF_IMG || avatarType == Default {
-		gifPath := GetPath(v.UUID, "icon.gif")
-		gifImg := LoadGif(gifPath, true)
-		if gifImg.GIF != nil {
-			v.Gif = gifImg
-			v.AvatarType = GIF_IMG
-			wi.RemoveFile(GetPath(v.UUID, "icon.png"))
-			return
+		for _, name := range iconNames[1:] {
+			gifPath := GetPath(v.UUID, name)
+			if info, err := os.Stat(gifPath); err != nil || info.Size() > maxIconSize {
+				continue
+			}
+			gifImg := LoadGif(gifPath, true)
+			if gifImg.Animation != nil {
+				v.Gif = gifImg
+				v.AvatarType = GIF_IMG
+				removeIcons(v.UUID, name)
+				return
+			}
 		}
 	}
 
@@ -185,26 +204,36 @@ func (v *Avatar) Reload(avatarType AvatarType) {
 		*img = assets.AppIconImage
 	}
 	v.Image = img
-	wi.RemoveFile(GetPath(v.UUID, "icon.gif"))
+	removeIcons(v.UUID, "icon.png")
 }
 
-func resizeImage(src image.Image, newWidth, newHeight int) image.Image {
-	// 创建一个新的RGBA图像，用于存放调整大小后的图像数据
-	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+// maxIconSize bounds an animated avatar, still ones are scaled down and re-encoded.
+const maxIconSize = 4 << 20
+
+// iconNames are the files an avatar is kept in, the animated ones after
+// icon.png. Only one of them exists at a time.
+var iconNames = []string{"icon.png", "icon.gif", "icon.webp", "icon.apng"}
 
-	// 计算缩放比例因子
-	scaleX := float64(newWidth) / float64(src.Bounds().Dx())
-	scaleY := float64(newHeight) / float64(src.Bounds().Dy())
+func iconPaths(uuid string) []string {
+	paths := make([]string, 0, len(iconNames))
+	for _, name := range iconNames {
+		paths = append(paths, GetPath(uuid, name))
+	}
+	return paths
+}
+
+// animatedIcon reports whether an avatar file plays as an animation.
+func animatedIcon(name string) bool {
+	return filepath.Base(name) != iconNames[0]
+}
 
-	for x := 0; x < newWidth; x++ {
-		for y := 0; y < newHeight; y++ {
-			// 计算源图像中对应的像素位置（插值）
-			srcX := int(float64(x) / scaleX)
-			srcY := int(float64(y) / scaleY)
-			dst.Set(x, y, src.At(srcX, srcY)) // 直接赋值，不考虑插值，结果可能不够平滑
+// removeIcons deletes the avatar files of uuid other than keep.
+func removeIcons(uuid string, keep string) {
+	for _, name := range iconNames {
+		if name != keep {
+			wi.RemoveFile(GetPath(uuid, name))
 		}
 	}
-	return dst
 }
 
 type avatarCache struct {
@@ -6,6 +6,8 @@ import (
 	"math"
 	"mushin/assets/fonts"
 	"mushin/assets/icons"
+	"mushin/internal/audio"
+	"runtime"
 	"time"
 
 	"gioui.org/io/event"
@@ -516,8 +518,12 @@ var iconStackAnimation = component.VisibilityAnimation{
 	Started:  time.Time{},
 }
 
-func NewIconStack(modeSwitch func(*IconButton) func(), appendFile func(mapping *FileDescription)) *IconStack {
+func NewIconStack(modeSwitch func(*IconButton) func(), composer *Composer, streamConfig audio.StreamConfig) *IconStack {
 	settings := NewSettingsForm(OnSettingsSubmit)
+	audioSettings := NewAudioPanel(streamConfig)
+	members := NewMembersPanel()
+	transfers := NewTransferPanel()
+	stickers := NewStickerPanel()
 	audioMakeButton.OnClick = MakeAudioCall(audioMakeButton)
 	voiceMessageSwitch := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.AVMic, Enabled: true}
 	voiceMessageSwitch.OnClick = modeSwitch(voiceMessageSwitch)
@@ -529,11 +535,19 @@ func NewIconStack(modeSwitch func(*IconButton) func(), appendFile func(mapping *
 	filesColor := color.NRGBA{R: 165, G: 214, B: 167, A: 255}    // Sage Green - organization & growth (Files)
 	photoColor := color.NRGBA{R: 255, G: 183, B: 77, A: 255}     // Amber Yellow - creativity & memories (Photos)
 	videoColor := color.NRGBA{R: 171, G: 183, B: 183, A: 255}    // Cool Gray - connection & professionalism (Video Call)
+	membersColor := color.NRGBA{R: 128, G: 222, B: 234, A: 255}  // Mint Cyan - presence & togetherness (Members)
+	transfersColor := color.NRGBA{R: 255, G: 213, B: 79, A: 255} // Sunflower - movement & flow (Transfers)
+	stickersColor := color.NRGBA{R: 244, G: 143, B: 177, A: 255} // Blossom Pink - play & emotion (Stickers)
 
 	// Create buttons with custom colors
 	settingsButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.ActionSettings, Enabled: true, OnClick: settings.ShowWithModal, Color: settingsColor}
-	filesButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.FileFolder, Enabled: true, OnClick: ChooseAndSendFile(appendFile), Color: filesColor}
-	photoButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.ImagePhotoLibrary, Enabled: true, OnClick: ChooseAndSendPhoto, Color: photoColor}
+	audioSettingsButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.HardwareHeadset, Enabled: true, OnClick: audioSettings.ShowWithModal, Color: settingsColor}
+	membersButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.SocialGroup, Enabled: true, OnClick: members.ShowWithModal, Color: membersColor}
+	transfersButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.NotificationSync, Enabled: true, OnClick: transfers.ShowWithModal, Color: transfersColor}
+	filesButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.FileFolder, Enabled: true, OnClick: composer.ChooseFiles, Color: filesColor}
+	folderButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.FileCreateNewFolder, Enabled: true, Hidden: runtime.GOOS == "android" || runtime.GOOS == "ios", OnClick: composer.ChooseFolder, Color: filesColor}
+	stickersButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.SocialMood, Enabled: true, OnClick: stickers.ShowWithModal, Color: stickersColor}
+	photoButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.ImagePhotoLibrary, Enabled: true, OnClick: composer.ChoosePhotos, Color: photoColor}
 	videoButton := &IconButton{Theme: fonts.DefaultTheme, Icon: icons.AVVideoCall, Color: videoColor}
 	audioMakeButton.Color = audioColor
 	voiceMessageSwitch.Color = voiceColor
@@ -544,8 +558,13 @@ func NewIconStack(modeSwitch func(*IconButton) func(), appendFile func(mapping *
 		VisibilityAnimation: &iconStackAnimation,
 		IconButtons: []*IconButton{
 			settingsButton,
+			audioSettingsButton,
+			membersButton,
+			transfersButton,
 			filesButton,
+			folderButton,
 			photoButton,
+			stickersButton,
 			videoButton,
 			audioMakeButton,
 			voiceMessageSwitch,
@@ -0,0 +1,309 @@
+package view
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/CoyAce/wi"
+)
+
+// chunkSize is the unit of transfer and verification for published files.
+const chunkSize = 1 << 20
+
+// Chunk is a slice of a published file, published under an id derived from
+// the file id and its index, see chunkID.
+type Chunk struct {
+	ID     uint32 `json:"id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Hash   string `json:"hash"`
+}
+
+// ChunkManifest describes how a published file is split and lets the
+// receiver verify every chunk and the whole file.
+type ChunkManifest struct {
+	FileId    uint32  `json:"fileId"`
+	Size      int64   `json:"size"`
+	ChunkSize int64   `json:"chunkSize"`
+	Hash      string  `json:"hash"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// manifestID derives the content id under which the manifest of fileId is published.
+func manifestID(fileId uint32) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte("manifest"))
+	_ = binary.Write(h, binary.BigEndian, fileId)
+	return nonZero(h.Sum32())
+}
+
+// chunkID derives the content id of the index-th chunk of fileId, 0 is
+// reserved for icons. salt is bumped while the id is taken by another content.
+func chunkID(fileId uint32, index int, salt uint32) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte("chunk"))
+	_ = binary.Write(h, binary.BigEndian, [3]uint32{fileId, uint32(index), salt})
+	return nonZero(h.Sum32())
+}
+
+func nonZero(id uint32) uint32 {
+	if id == 0 {
+		return 1
+	}
+	return id
+}
+
+func chunkName(id uint32) string {
+	return fmt.Sprintf("%08x.chunk", id)
+}
+
+func manifestName(id uint32) string {
+	return fmt.Sprintf("%08x.manifest", id)
+}
+
+// hashChunks reads r to the end and builds the manifest of its content.
+func hashChunks(r io.Reader, fileId uint32) (*ChunkManifest, error) {
+	m := &ChunkManifest{FileId: fileId, ChunkSize: chunkSize}
+	whole := sha256.New()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			whole.Write(buf[:n])
+			m.Chunks = append(m.Chunks, Chunk{ID: chunkID(fileId, len(m.Chunks), 0), Offset: m.Size, Size: int64(n), Hash: hex.EncodeToString(sum[:])})
+			m.Size += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.Hash = hex.EncodeToString(whole.Sum(nil))
+	return m, nil
+}
+
+// verifyChunk reports whether data matches the digest recorded for c.
+func verifyChunk(c Chunk, data []byte) bool {
+	sum := sha256.Sum256(data)
+	return int64(len(data)) == c.Size && hex.EncodeToString(sum[:]) == c.Hash
+}
+
+type chunkRef struct {
+	fileId uint32
+	chunk  Chunk
+}
+
+// Seeder serves manifests and chunks of published files. Manifests are built
+// when a file is published, or on the first request for files published
+// before a restart. Chunks are only known after that.
+type Seeder struct {
+	manifests map[uint32]*ChunkManifest
+	owners    map[uint32]uint32
+	chunks    map[uint32]chunkRef
+	lock      sync.Mutex
+}
+
+func NewSeeder() *Seeder {
+	return &Seeder{
+		manifests: make(map[uint32]*ChunkManifest),
+		owners:    make(map[uint32]uint32),
+		chunks:    make(map[uint32]chunkRef),
+	}
+}
+
+// Register makes the manifest of a published file requestable.
+func (s *Seeder) Register(fileId uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.owners[manifestID(fileId)] = fileId
+}
+
+// Prepare builds the manifest of fd ahead of the first request, so that
+// receivers don't wait for a large file to be hashed.
+func (s *Seeder) Prepare(fd *FileDescription, find func(uint32) *FileDescription) {
+	if _, err := s.manifest(fd, find); err != nil {
+		log.Printf("Build manifest of %s failed: %v", fd.Name, err)
+	}
+}
+
+// Serve publishes the manifest or chunk requested by id, it reports false
+// for ids it doesn't know about.
+func (s *Seeder) Serve(id uint32, find func(uint32) *FileDescription) bool {
+	s.lock.Lock()
+	fileId, isManifest := s.owners[id]
+	ref, isChunk := s.chunks[id]
+	s.lock.Unlock()
+	switch {
+	case isManifest:
+		fd := find(fileId)
+		if fd == nil {
+			return false
+		}
+		go s.serveManifest(id, fd, find)
+	case isChunk:
+		fd := find(ref.fileId)
+		if fd == nil {
+			return false
+		}
+		go s.serveChunk(fd, ref.chunk)
+	default:
+		return false
+	}
+	return true
+}
+
+// manifest builds the manifest of fd once. Chunk ids that are already taken by
+// a manifest, a chunk of another file or a published file are salted again,
+// the receiver only knows chunks by the ids listed in the manifest.
+func (s *Seeder) manifest(fd *FileDescription, find func(uint32) *FileDescription) (*ChunkManifest, error) {
+	s.lock.Lock()
+	m := s.manifests[fd.ID]
+	s.lock.Unlock()
+	if m != nil {
+		return m, nil
+	}
+	r, err := fd.Content()()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	m, err = hashChunks(r, fd.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range m.Chunks {
+		c := &m.Chunks[i]
+		for salt := uint32(1); !s.claim(fd.ID, *c, find); salt++ {
+			c.ID = chunkID(fd.ID, i, salt)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.manifests[fd.ID] = m
+	return m, nil
+}
+
+// claim registers c under its id unless the id already names other content.
+// Published files are looked up without the lock, their keeper registers
+// manifests while holding its own.
+func (s *Seeder) claim(fileId uint32, c Chunk, find func(uint32) *FileDescription) bool {
+	if find(c.ID) != nil || Stickers.Find(c.ID) != nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ref := chunkRef{fileId: fileId, chunk: c}
+	_, isManifest := s.owners[c.ID]
+	other, isChunk := s.chunks[c.ID]
+	if isManifest || isChunk && other != ref {
+		return false
+	}
+	s.chunks[c.ID] = ref
+	return true
+}
+
+func (s *Seeder) serveManifest(id uint32, fd *FileDescription, find func(uint32) *FileDescription) {
+	m, err := s.manifest(fd, find)
+	if err != nil {
+		log.Printf("Build manifest of %s failed: %v", fd.Name, err)
+		return
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("Marshall manifest failed: %v", err)
+		return
+	}
+	content := func() (io.ReadSeekCloser, error) {
+		return nopSeekCloser{bytes.NewReader(data)}, nil
+	}
+	err = wi.DefaultClient.PublishContent(content, manifestName(id), uint64(len(data)), id)
+	if err != nil {
+		log.Printf("Publish manifest failed: %v", err)
+	}
+}
+
+func (s *Seeder) serveChunk(fd *FileDescription, c Chunk) {
+	content := func() (io.ReadSeekCloser, error) {
+		r, err := fd.Content()()
+		if err != nil {
+			return nil, err
+		}
+		return newSectionSeekCloser(r, c.Offset, c.Size), nil
+	}
+	t := Transfers.Track(Upload, fd.ID, fd.Name, fd.Size)
+	// chunks before c were sent before a restart of the download
+	t.skip(c.Offset)
+	Transfers.Publish(t, content, chunkName(c.ID), uint64(c.Size), c.ID)
+}
+
+var Seeds = NewSeeder()
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// sectionSeekCloser exposes a window of r, seeks are relative to the window.
+type sectionSeekCloser struct {
+	r      io.ReadSeekCloser
+	offset int64
+	size   int64
+	pos    int64
+	seeked bool
+}
+
+func newSectionSeekCloser(r io.ReadSeekCloser, offset, size int64) *sectionSeekCloser {
+	return &sectionSeekCloser{r: r, offset: offset, size: size}
+}
+
+func (s *sectionSeekCloser) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if !s.seeked {
+		if _, err := s.r.Seek(s.offset+s.pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.seeked = true
+	}
+	if remain := s.size - s.pos; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *sectionSeekCloser) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of chunk")
+	}
+	s.pos = offset
+	s.seeked = false
+	return offset, nil
+}
+
+func (s *sectionSeekCloser) Close() error {
+	return s.r.Close()
+}
@@ -0,0 +1,59 @@
+package view
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestChunkManifest(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8+3)
+	m, err := hashChunks(bytes.NewReader(data), 42)
+	if err != nil {
+		t.Fatalf("hashChunks failed: %v", err)
+	}
+	if m.Size != int64(len(data)) {
+		t.Errorf("manifest size should be %d, but %d", len(data), m.Size)
+	}
+	if len(m.Chunks) != 3 {
+		t.Fatalf("chunks length should be 3, but %d", len(m.Chunks))
+	}
+	for _, c := range m.Chunks {
+		if !verifyChunk(c, data[c.Offset:c.Offset+c.Size]) {
+			t.Errorf("chunk at %d should verify", c.Offset)
+		}
+	}
+	corrupted := bytes.Clone(data[:chunkSize])
+	corrupted[7] ^= 0xff
+	if verifyChunk(m.Chunks[0], corrupted) {
+		t.Errorf("corrupted chunk should not verify")
+	}
+	if m.Chunks[0].ID == m.Chunks[1].ID {
+		t.Errorf("chunks with same content should have distinct ids")
+	}
+	if chunkID(42, 0, 0) == chunkID(43, 0, 0) || chunkID(42, 0, 0) == chunkID(42, 0, 1) {
+		t.Errorf("chunk ids should differ per file and salt")
+	}
+	if manifestID(42) == manifestID(43) {
+		t.Errorf("manifest ids should differ per file")
+	}
+}
+
+func TestSectionSeekCloser(t *testing.T) {
+	data := []byte("hello beautiful world")
+	s := newSectionSeekCloser(nopSeekCloser{bytes.NewReader(data)}, 6, 9)
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("read section failed: %v", err)
+	}
+	if string(got) != "beautiful" {
+		t.Errorf("section should be beautiful, but %s", got)
+	}
+	if _, err = s.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatalf("seek section failed: %v", err)
+	}
+	got, _ = io.ReadAll(s)
+	if string(got) != "tiful" {
+		t.Errorf("section tail should be tiful, but %s", got)
+	}
+}
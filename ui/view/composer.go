@@ -0,0 +1,348 @@
+package view
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"mushin/assets/fonts"
+	"mushin/assets/icons"
+	"mushin/internal/imaging"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	modal "mushin/ui/layout"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	"github.com/CoyAce/wi"
+)
+
+type Attachment struct {
+	FileDescription
+	FileControl
+	thumb        image.Image
+	removeButton widget.Clickable
+}
+
+// Composer collects images and files picked, pasted or dropped, lets the user
+// review them and add a caption, then sends photos as an album.
+type Composer struct {
+	*material.Theme
+	modalContent    *modal.ModalContent
+	appendFile      func(*FileDescription)
+	items           []*Attachment
+	caption         *component.TextField
+	addFileButton   IconButton
+	addFolderButton IconButton
+	addPhotoButton  IconButton
+	sendButton      IconButton
+	showing         bool
+	lock            sync.Mutex
+}
+
+func NewComposer(appendFile func(*FileDescription)) *Composer {
+	c := &Composer{
+		Theme:           fonts.NewTheme(),
+		appendFile:      appendFile,
+		caption:         &component.TextField{Editor: widget.Editor{}},
+		addFileButton:   IconButton{Theme: fonts.DefaultTheme, Icon: icons.FileFolder, Enabled: true},
+		addFolderButton: IconButton{Theme: fonts.DefaultTheme, Icon: icons.FileCreateNewFolder, Enabled: true, Hidden: runtime.GOOS == "android" || runtime.GOOS == "ios"},
+		addPhotoButton:  IconButton{Theme: fonts.DefaultTheme, Icon: icons.ImagePhotoLibrary, Enabled: true},
+		sendButton:      IconButton{Theme: fonts.DefaultTheme, Icon: icons.ContentSend, Enabled: true},
+	}
+	c.Theme.TextSize = 0.75 * c.Theme.TextSize
+	c.addFileButton.OnClick = c.ChooseFiles
+	c.addFolderButton.OnClick = c.ChooseFolder
+	c.addPhotoButton.OnClick = c.ChoosePhotos
+	c.sendButton.OnClick = c.send
+	c.modalContent = modal.NewModalContent(fonts.DefaultTheme, c.dismiss)
+	c.modalContent.SetTitle("Send")
+	return c
+}
+
+// ChooseFiles picks files and adds them to the composer.
+func (c *Composer) ChooseFiles() {
+	go func() {
+		fds, err := ChooseFiles()
+		if err != nil {
+			log.Printf("Choose file failed: %v", err)
+			return
+		}
+		for _, fd := range fds {
+			c.Add(fd)
+		}
+	}()
+}
+
+// ChooseFolder picks a directory that is sent as an archive.
+func (c *Composer) ChooseFolder() {
+	go func() {
+		dir, err := ChooseFolder()
+		if errors.Is(err, errNoFolderPicker) {
+			log.Printf("Choose folder failed: %v", err)
+			HintRequest <- "❌未找到文件夹选择器，请安装zenity或kdialog"
+			return
+		}
+		if err != nil {
+			log.Printf("Choose folder failed: %v", err)
+			return
+		}
+		c.Add(FileDescription{Name: filepath.Base(dir), Path: dir})
+	}()
+}
+
+// ChoosePhotos picks images and adds them to the composer.
+func (c *Composer) ChoosePhotos() {
+	go func() {
+		fds, err := ChooseImages()
+		if err != nil {
+			log.Printf("choose image failed, %v", err)
+			return
+		}
+		for _, fd := range fds {
+			c.Add(fd)
+		}
+	}()
+}
+
+// Add stages an attachment and shows the composer, it is safe to call from any goroutine.
+func (c *Composer) Add(fd FileDescription) {
+	if fd.File != nil {
+		// content is read again by path when sending
+		_ = fd.File.Close()
+		fd.File = nil
+	}
+	a := &Attachment{
+		FileDescription: fd,
+		FileControl:     FileControl{Filename: fd.Name, Path: fd.Path, Size: uint64(fd.Size), Mime: NewMine(fd.Name)},
+	}
+	switch {
+	case isDir(fd.Path):
+		if archive, err := NewFolderArchive(fd.Path); err == nil {
+			a.Folder = &archive.Summary
+			a.Mime = Archive
+		}
+	case isPhoto(fd.Name):
+		a.thumb = loadThumbnail(fd.Path)
+	}
+	c.lock.Lock()
+	c.items = append(c.items, a)
+	show := !c.showing
+	c.showing = true
+	c.lock.Unlock()
+	if show {
+		c.ShowWithModal()
+	}
+	InvalidateRequest <- struct{}{}
+}
+
+func loadThumbnail(path string) image.Image {
+	file, err := Open(path)
+	if err != nil {
+		log.Printf("open %s failed: %v", path, err)
+		return nil
+	}
+	defer file.Close()
+	img, err := decodeImage(file)
+	if err != nil {
+		log.Printf("decode %s failed: %v", path, err)
+		return nil
+	}
+	const size = 160
+	return imaging.Fit(img, size, size, imaging.Area)
+}
+
+func (c *Composer) remove(a *Attachment) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, item := range c.items {
+		if item == a {
+			c.items = append(c.items[:i], c.items[i+1:]...)
+			discardStaged(a.FileDescription)
+			return
+		}
+	}
+}
+
+func (c *Composer) send() {
+	c.lock.Lock()
+	items := c.items
+	c.items = nil
+	c.lock.Unlock()
+	caption := strings.TrimSpace(c.caption.Text())
+	if len(items) == 0 && caption == "" {
+		return
+	}
+	c.dismiss()
+	go func() {
+		var photos []FileDescription
+		for _, a := range items {
+			fd := keepStaged(a.FileDescription)
+			switch {
+			case isDir(fd.Path):
+				SendFolder(fd, c.appendFile)
+			case isPhoto(a.Name):
+				photos = append(photos, fd)
+			default:
+				PublishFile(fd, c.appendFile)
+			}
+		}
+		switch {
+		case (len(photos) > 1 || len(photos) == 1 && caption != "") && !Peers.Legacy():
+			SendAlbum(photos, caption)
+		case len(photos) > 0:
+			// older builds in the room can't read albums, photos go on their own
+			for _, fd := range photos {
+				SendPhoto(fd, c.appendFile)
+			}
+			sendCaption(caption)
+		default:
+			sendCaption(caption)
+		}
+	}()
+}
+
+// sendCaption sends the caption as a text message, if any.
+func sendCaption(caption string) {
+	if caption == "" {
+		return
+	}
+	message := NewTextMessage(caption)
+	MessageBox <- message
+	if wi.DefaultClient.SendText(caption) == nil {
+		message.State = Sent
+	} else {
+		message.State = Failed
+	}
+}
+
+func (c *Composer) dismiss() {
+	c.lock.Lock()
+	for _, a := range c.items {
+		discardStaged(a.FileDescription)
+	}
+	c.items = nil
+	c.showing = false
+	c.lock.Unlock()
+	c.caption.Clear()
+	modal.DefaultModal.Dismiss(nil)
+}
+
+func (c *Composer) Layout(gtx layout.Context) layout.Dimensions {
+	c.lock.Lock()
+	items := append([]*Attachment(nil), c.items...)
+	c.lock.Unlock()
+	for _, a := range items {
+		if a.removeButton.Clicked(gtx) {
+			c.remove(a)
+		}
+	}
+	c.sendButton.Enabled = len(items) > 0 || c.caption.Text() != ""
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	children := make([]layout.FlexChild, 0, len(items)*2+4)
+	for _, a := range items {
+		children = append(children,
+			layout.Rigid(c.drawItem(a)),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(10)}.Layout),
+		)
+	}
+	children = append(children,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return c.caption.Layout(gtx, c.Theme, "Caption")
+		}),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(20)}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Spacing: layout.SpaceSides, Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return c.addPhotoButton.Layout(gtx, 1.0, 0, 0)
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return c.addFileButton.Layout(gtx, 1.0, 0, 0)
+				}),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					if c.addFolderButton.Hidden {
+						return layout.Dimensions{}
+					}
+					return layout.Inset{Left: unit.Dp(16)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+						return c.addFolderButton.Layout(gtx, 1.0, 0, 0)
+					})
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return c.sendButton.Layout(gtx, 1.0, 0, 0)
+				}),
+			)
+		}),
+	)
+	margins := layout.Inset{Top: unit.Dp(12), Bottom: unit.Dp(24), Left: unit.Dp(16), Right: unit.Dp(16)}
+	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	})
+}
+
+func (c *Composer) drawItem(a *Attachment) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				size := gtx.Dp(64)
+				gtx.Constraints = layout.Exact(image.Pt(size, size))
+				if a.thumb == nil {
+					return a.FileControl.drawIcon(true)(gtx)
+				}
+				defer clip.UniformRRect(image.Rectangle{Max: gtx.Constraints.Max}, gtx.Dp(6)).Push(gtx.Ops).Pop()
+				return widget.Image{Src: paint.NewImageOp(a.thumb), Fit: widget.Cover, Position: layout.Center}.Layout(gtx)
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(12)}.Layout),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						label := material.Label(c.Theme, c.TextSize, a.Filename)
+						label.Font.Weight = font.Bold
+						label.MaxLines = 1
+						return label.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						size := a.toHumanReadable(float32(a.FileDescription.Size))
+						if a.Folder != nil {
+							size = fmt.Sprintf("%d files · %s", a.Folder.Files, a.toHumanReadable(float32(a.Folder.Size)))
+						}
+						label := material.Label(c.Theme, c.TextSize*0.85, size)
+						label.Color.A = 160
+						return label.Layout(gtx)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return a.removeButton.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(24)
+					return icons.ClearIcon.Layout(gtx, c.Theme.ContrastBg)
+				})
+			}),
+		)
+	}
+}
+
+func (c *Composer) ShowWithModal() {
+	modal.DefaultModal.Show(c.ZoomInWithModalContent, c.dismiss, component.VisibilityAnimation{
+		Duration: time.Millisecond * 250,
+		State:    component.Invisible,
+		Started:  time.Time{},
+	})
+}
+
+func (c *Composer) ZoomInWithModalContent(gtx layout.Context) layout.Dimensions {
+	gtx.Constraints.Max.X = int(float32(gtx.Constraints.Max.X) * 0.85)
+	gtx.Constraints.Max.Y = int(float32(gtx.Constraints.Max.Y) * 0.85)
+	return c.modalContent.DrawContent(gtx, c.Layout)
+}
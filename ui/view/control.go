@@ -0,0 +1,187 @@
+package view
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CoyAce/wi"
+)
+
+// controlPrefix marks a signed text payload as a control message.
+// Control messages ride on SendText but are never shown or persisted.
+const controlPrefix = "\x00ctrl:"
+
+type ControlKind string
+
+const (
+	ControlPresence ControlKind = "presence"
+	ControlActivity ControlKind = "activity"
+	ControlAlbum    ControlKind = "album"
+	ControlFolder   ControlKind = "folder"
+	ControlImage    ControlKind = "image"
+	ControlSticker  ControlKind = "sticker"
+)
+
+// ControlMessage is a lightweight signal exchanged between members of a sign room.
+type ControlMessage struct {
+	Kind     ControlKind     `json:"kind"`
+	Sign     string          `json:"sign"`
+	Presence PresenceState   `json:"presence,omitempty"`
+	Activity Activity        `json:"activity,omitempty"`
+	Album    *AlbumManifest  `json:"album,omitempty"`
+	Folder   *FolderManifest `json:"folder,omitempty"`
+	Image    *ImageManifest  `json:"image,omitempty"`
+	Sticker  *StickerRef     `json:"sticker,omitempty"`
+}
+
+// ControlEvent is a received ControlMessage together with its origin.
+type ControlEvent struct {
+	ControlMessage
+	UUID      string
+	CreatedAt time.Time
+}
+
+// errOldPeers is returned by SendControl while a member of the room runs
+// a build that shows control messages as text.
+var errOldPeers = errors.New("a member can't read control messages")
+
+// legacyTTL is how long a member that only sent text counts as an older build.
+const legacyTTL = 30 * time.Minute
+
+// peerRegistry tells members that read control messages from older builds.
+// Members announce their presence when they sign in, so one that recently
+// sent text but never a control message is taken as an older build.
+type peerRegistry struct {
+	sign    string
+	capable map[string]bool
+	legacy  map[string]time.Time
+	lock    sync.Mutex
+}
+
+// Seen records a message of uuid sent at, control tells whether it was a control message.
+func (r *peerRegistry) Seen(uuid string, control bool, at time.Time) {
+	if uuid == wi.DefaultClient.ID() || !control && time.Since(at) > legacyTTL {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.resetIfSignChanged()
+	if control {
+		r.capable[uuid] = true
+		delete(r.legacy, uuid)
+	} else if !r.capable[uuid] {
+		r.legacy[uuid] = at
+	}
+}
+
+// Legacy reports whether a member of the current room can't read control messages.
+func (r *peerRegistry) Legacy() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.resetIfSignChanged()
+	for uuid, at := range r.legacy {
+		if time.Since(at) > legacyTTL {
+			delete(r.legacy, uuid)
+		}
+	}
+	return len(r.legacy) > 0
+}
+
+func (r *peerRegistry) resetIfSignChanged() {
+	if r.sign != wi.DefaultClient.Sign {
+		r.sign = wi.DefaultClient.Sign
+		clear(r.capable)
+		clear(r.legacy)
+	}
+}
+
+var Peers = &peerRegistry{capable: make(map[string]bool), legacy: make(map[string]time.Time)}
+
+// SendControl sends the control message to the current sign room, it
+// returns errOldPeers instead while an older build would show it as text.
+func SendControl(c ControlMessage) error {
+	if Peers.Legacy() {
+		return errOldPeers
+	}
+	c.Sign = wi.DefaultClient.Sign
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return wi.DefaultClient.SendText(controlPrefix + string(data))
+}
+
+// ParseControl reports whether payload is a control message and decodes it.
+func ParseControl(payload []byte) (ControlMessage, bool) {
+	var c ControlMessage
+	s := string(payload)
+	if !strings.HasPrefix(s, controlPrefix) {
+		return c, false
+	}
+	if err := json.Unmarshal([]byte(s[len(controlPrefix):]), &c); err != nil {
+		log.Printf("Unmarshall control message failed: %v", err)
+	}
+	return c, true
+}
+
+// dispatchControl routes a control event to its handler.
+// Events from other rooms or from ourselves are dropped.
+// A non-nil message is returned for control messages that are shown in the chat.
+func dispatchControl(e ControlEvent) *Message {
+	if e.Sign != wi.DefaultClient.Sign || e.UUID == wi.DefaultClient.ID() {
+		return nil
+	}
+	switch e.Kind {
+	case ControlPresence:
+		DefaultPresence.Update(e.UUID, e.Presence, e.CreatedAt)
+	case ControlActivity:
+		DefaultComposing.Update(e.UUID, e.Activity, e.CreatedAt)
+	case ControlAlbum:
+		if e.Album == nil || len(e.Album.Files) == 0 {
+			return nil
+		}
+		items := make([]AlbumItem, 0, len(e.Album.Files))
+		for i, name := range e.Album.Files {
+			item := AlbumItem{Filename: name}
+			if i < len(e.Album.FileIds) {
+				item.FileId = e.Album.FileIds[i]
+			}
+			if i < len(e.Album.Previews) {
+				item.Preview = e.Album.Previews[i]
+			}
+			items = append(items, item)
+		}
+		message := NewAlbumMessage(FromSender(e.UUID), items, e.Album.Caption)
+		message.State = Sent
+		message.CreatedAt = e.CreatedAt
+		message.expectAlbumPreviews()
+		return message
+	case ControlFolder:
+		if e.Folder != nil {
+			Folders.Remember(e.UUID, e.Folder)
+		}
+	case ControlImage:
+		if e.Image == nil || e.Image.FileId == 0 {
+			return nil
+		}
+		message := NewImageMessage(e.UUID, e.Image)
+		message.CreatedAt = e.CreatedAt
+		message.expectPreview()
+		return message
+	case ControlSticker:
+		if e.Sticker == nil || !e.Sticker.Valid() {
+			return nil
+		}
+		message := NewStickerMessage(FromSender(e.UUID), *e.Sticker)
+		message.State = Sent
+		message.CreatedAt = e.CreatedAt
+		Stickers.Fetch(*e.Sticker, e.UUID)
+		return message
+	default:
+	}
+	return nil
+}
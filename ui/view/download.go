@@ -0,0 +1,539 @@
+package view
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CoyAce/wi"
+)
+
+const (
+	// manifestTimeout is how long to wait for a manifest before falling back
+	// to a plain download, peers running an older build don't serve manifests.
+	manifestTimeout = 10 * time.Second
+	// manifestHashRate is the slowest rate at which a sender is expected to
+	// hash a file it hasn't built the manifest of yet.
+	manifestHashRate = 20 << 20
+	chunkTimeout     = 2 * time.Minute
+	chunkRetries     = 3
+)
+
+var (
+	errCorrupt   = errors.New("file hash mismatch")
+	errSuspended = errors.New("download suspended")
+	errTimeout   = errors.New("content not received in time")
+)
+
+// PartialDownload is the persisted progress of a chunked download,
+// Verified counts chunks that were checked and written to the .part file.
+type PartialDownload struct {
+	FileId   uint32        `json:"fileId"`
+	Sender   string        `json:"sender"`
+	Filename string        `json:"filename"`
+	Manifest ChunkManifest `json:"manifest"`
+	Verified int           `json:"verified"`
+}
+
+func (p *PartialDownload) verifiedBytes() int64 {
+	if p.Verified == 0 {
+		return 0
+	}
+	c := p.Manifest.Chunks[p.Verified-1]
+	return c.Offset + c.Size
+}
+
+func (p *PartialDownload) percent(extra int64) int {
+	if p.Manifest.Size == 0 {
+		return 0
+	}
+	// 100 means done, keep it for the final hash check
+	return min(int((p.verifiedBytes()+extra)*100/p.Manifest.Size), 99)
+}
+
+func partPath(sender, filename string) string {
+	return GetPath(sender, filename) + ".part"
+}
+
+type downloadTask struct {
+	*FileControl
+	transfer *Transfer
+	sender   string
+	running  bool
+	// paused by the user, not resumed on reconnect
+	paused bool
+	stop   chan struct{}
+	reason error
+}
+
+func (t *downloadTask) report(progress int, speed int) {
+	t.updateProgress(progress)
+	t.updateSpeed(speed)
+	t.transfer.report(progress, speed)
+	invalidate()
+}
+
+// Downloader fetches published files chunk by chunk, verifies each chunk and
+// keeps a partial manifest next to download.log so that an interrupted
+// download resumes from the last verified chunk.
+type Downloader struct {
+	filename string
+	partials map[uint32]*PartialDownload
+	tasks    map[uint32]*downloadTask
+	waiters  map[uint32]chan wi.WriteReq
+	// plain downloads waiting for their content, see Received
+	plain      map[uint32]chan struct{}
+	onComplete func(*FileDescription)
+	lock       sync.Mutex
+	flushLock  sync.Mutex
+}
+
+func NewDownloader(filename string) *Downloader {
+	return &Downloader{
+		filename: filename,
+		partials: make(map[uint32]*PartialDownload),
+		tasks:    make(map[uint32]*downloadTask),
+		waiters:  make(map[uint32]chan wi.WriteReq),
+		plain:    make(map[uint32]chan struct{}),
+	}
+}
+
+// Load reads partial downloads stored under the data dir.
+func (d *Downloader) Load() {
+	data, err := os.ReadFile(GetDataPath(d.filename))
+	if err != nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err = json.Unmarshal(data, &d.partials); err != nil {
+		log.Printf("Unmarshall partial downloads failed: %v", err)
+	}
+}
+
+// OnComplete sets the callback invoked when a file is downloaded and verified.
+func (d *Downloader) OnComplete(f func(*FileDescription)) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.onComplete = f
+}
+
+// Track attaches a file bubble to a partial download restored from disk.
+func (d *Downloader) Track(f *FileControl, sender string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p, ok := d.partials[f.FileId]
+	if !ok {
+		return
+	}
+	progress := p.percent(0)
+	f.updateState(func(f *FileControl) {
+		f.progress = progress
+		f.resumable = true
+	})
+	if _, ok = d.tasks[f.FileId]; !ok {
+		t := d.newTask(f, sender)
+		t.transfer.report(progress, 0)
+		t.transfer.setState(Paused, nil)
+	}
+}
+
+func (d *Downloader) newTask(f *FileControl, sender string) *downloadTask {
+	t := &downloadTask{FileControl: f, sender: sender}
+	t.transfer = Transfers.Track(Download, f.FileId, f.Filename, int64(f.Size))
+	resume := func() { d.Start(t.FileControl, sender) }
+	t.transfer.handle(func() { d.Pause(f.FileId) }, resume, func() { d.Cancel(f.FileId) }, resume)
+	d.tasks[f.FileId] = t
+	return t
+}
+
+// Start downloads the file of f from sender, resuming a partial download if any.
+func (d *Downloader) Start(f *FileControl, sender string) {
+	d.lock.Lock()
+	t, ok := d.tasks[f.FileId]
+	if !ok {
+		t = d.newTask(f, sender)
+	} else if t.transfer.State().finished() {
+		Transfers.Restart(t.transfer)
+	}
+	if t.running {
+		d.lock.Unlock()
+		return
+	}
+	t.FileControl = f
+	t.paused = false
+	d.begin(t)
+	d.lock.Unlock()
+	go d.run(t)
+}
+
+func (d *Downloader) begin(t *downloadTask) {
+	t.running = true
+	t.stop = make(chan struct{})
+	t.reason = nil
+	t.transfer.setState(Queued, nil)
+}
+
+// Pause stops the download of fileId, verified chunks are kept.
+func (d *Downloader) Pause(fileId uint32) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	t, ok := d.tasks[fileId]
+	if !ok {
+		return
+	}
+	t.paused = true
+	if t.running {
+		d.halt(t, errPaused)
+	} else {
+		t.transfer.setState(Paused, nil)
+	}
+}
+
+// Cancel stops the download of fileId and discards what was received.
+func (d *Downloader) Cancel(fileId uint32) {
+	d.lock.Lock()
+	t, ok := d.tasks[fileId]
+	if !ok {
+		d.lock.Unlock()
+		return
+	}
+	if t.running {
+		d.halt(t, errCanceled)
+		d.lock.Unlock()
+		return
+	}
+	d.lock.Unlock()
+	d.discard(t)
+	t.transfer.setState(Canceled, nil)
+	invalidate()
+}
+
+func (d *Downloader) discard(t *downloadTask) {
+	d.remove(t.FileId)
+	_ = os.Remove(partPath(t.sender, t.Filename))
+	t.updateState(func(f *FileControl) {
+		f.progress = 0
+		f.resumable = false
+	})
+}
+
+// halt stops a running task, the caller holds the lock.
+func (d *Downloader) halt(t *downloadTask, reason error) {
+	select {
+	case <-t.stop:
+	default:
+		t.reason = reason
+		close(t.stop)
+	}
+}
+
+// Resume restarts downloads interrupted by a sign out or a restart.
+func (d *Downloader) Resume() {
+	d.lock.Lock()
+	var tasks []*downloadTask
+	for id, t := range d.tasks {
+		if _, ok := d.partials[id]; ok && !t.running && !t.paused {
+			d.begin(t)
+			tasks = append(tasks, t)
+		}
+	}
+	d.lock.Unlock()
+	for _, t := range tasks {
+		go d.run(t)
+	}
+}
+
+// Suspend aborts running downloads, their verified chunks are kept.
+func (d *Downloader) Suspend() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, t := range d.tasks {
+		if t.running {
+			d.halt(t, errSuspended)
+		}
+	}
+}
+
+// Deliver hands received content to a waiting download,
+// it reports false if nobody is waiting for req.FileId.
+func (d *Downloader) Deliver(req wi.WriteReq) bool {
+	d.lock.Lock()
+	ch, ok := d.waiters[req.FileId]
+	d.lock.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- req:
+	default:
+	}
+	return true
+}
+
+func (d *Downloader) run(t *downloadTask) {
+	t.updateState(func(f *FileControl) {
+		f.corrupt = false
+		f.transferring = true
+	})
+	err := errCanceled
+	if Transfers.downloads.acquire(t.stop) {
+		t.transfer.setState(Active, nil)
+		invalidate()
+		err = d.download(t)
+		Transfers.downloads.release()
+	}
+	if errors.Is(err, errCanceled) || errors.Is(err, errPaused) || errors.Is(err, errSuspended) {
+		// the reason wins over the error of whatever was interrupted
+		d.lock.Lock()
+		err = t.reason
+		d.lock.Unlock()
+	}
+	var resumable bool
+	t.updateState(func(f *FileControl) {
+		f.transferring = false
+		f.speed = 0
+		resumable = f.resumable
+	})
+	switch {
+	case err == nil:
+		if !resumable {
+			// a plain download completes when its content arrives
+			break
+		}
+		t.transfer.setState(Completed, nil)
+	case errors.Is(err, errPaused), errors.Is(err, errSuspended):
+		t.transfer.setState(Paused, nil)
+	case errors.Is(err, errCanceled):
+		d.discard(t)
+		t.transfer.setState(Canceled, nil)
+	case errors.Is(err, errCorrupt):
+		log.Printf("Download %s failed: %v", t.Filename, err)
+		t.updateState(func(f *FileControl) { f.corrupt = true })
+		d.discard(t)
+		t.transfer.setState(Failed, err)
+	default:
+		log.Printf("Download %s interrupted: %v", t.Filename, err)
+		t.transfer.setState(Failed, err)
+	}
+	d.lock.Lock()
+	t.running = false
+	d.lock.Unlock()
+	invalidate()
+}
+
+func (d *Downloader) download(t *downloadTask) error {
+	m, err := d.fetchManifest(t)
+	if errors.Is(err, errTimeout) && d.partial(t.FileId) == nil {
+		return d.legacy(t)
+	}
+	if err != nil {
+		return err
+	}
+	t.updateState(func(f *FileControl) { f.resumable = true })
+	// chunk ids change when the sender restarts, only the progress is kept
+	p := &PartialDownload{FileId: t.FileId, Sender: t.sender, Filename: t.Filename, Manifest: *m}
+	if old := d.partial(t.FileId); old != nil && old.Manifest.Hash == m.Hash && len(old.Manifest.Chunks) == len(m.Chunks) {
+		p.Verified = old.Verified
+	}
+	part, err := os.OpenFile(partPath(t.sender, t.Filename), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	if info, err := part.Stat(); err == nil {
+		// chunks beyond the file end were never written
+		for p.Verified > 0 && info.Size() < p.verifiedBytes() {
+			p.Verified--
+		}
+	}
+	if err = part.Truncate(p.verifiedBytes()); err != nil {
+		return err
+	}
+	d.save(p)
+	for p.Verified < len(p.Manifest.Chunks) {
+		c := p.Manifest.Chunks[p.Verified]
+		data, err := d.fetchChunk(t, p, c)
+		if err != nil {
+			return err
+		}
+		if _, err = part.WriteAt(data, c.Offset); err != nil {
+			return err
+		}
+		p.Verified++
+		d.save(p)
+		t.report(p.percent(0), t.transfer.Speed())
+	}
+	if _, err = part.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(h, part); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != p.Manifest.Hash {
+		return errCorrupt
+	}
+	_ = part.Close()
+	if err = os.Rename(partPath(t.sender, t.Filename), GetPath(t.sender, t.Filename)); err != nil {
+		return err
+	}
+	d.remove(t.FileId)
+	t.updateProgress(100)
+	d.lock.Lock()
+	onComplete := d.onComplete
+	d.lock.Unlock()
+	if onComplete != nil {
+		onComplete(&FileDescription{ID: t.FileId, Name: t.Filename, Size: p.Manifest.Size})
+	}
+	return nil
+}
+
+func (d *Downloader) fetchManifest(t *downloadTask) (*ChunkManifest, error) {
+	id := manifestID(t.FileId)
+	// the sender may hash the whole file before it serves the manifest
+	timeout := manifestTimeout + time.Duration(t.Size/manifestHashRate)*time.Second
+	req, err := d.fetch(t, id, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	path := GetPath(t.sender, req.Filename)
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m ChunkManifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (d *Downloader) fetchChunk(t *downloadTask, p *PartialDownload, c Chunk) ([]byte, error) {
+	var err error
+	for range chunkRetries {
+		var req wi.WriteReq
+		req, err = d.fetch(t, c.ID, chunkTimeout, func(percent, speed int) {
+			t.report(p.percent(c.Size*int64(percent)/100), speed)
+		})
+		if err != nil {
+			return nil, err
+		}
+		path := GetPath(t.sender, req.Filename)
+		data, readErr := os.ReadFile(path)
+		_ = os.Remove(path)
+		if readErr == nil && verifyChunk(c, data) {
+			return data, nil
+		}
+		log.Printf("Chunk %s of %s failed verification", chunkName(c.ID), t.Filename)
+		err = errCorrupt
+	}
+	return nil, err
+}
+
+// fetch subscribes content id from the task sender and waits until it is received.
+func (d *Downloader) fetch(t *downloadTask, id uint32, timeout time.Duration, progress func(int, int)) (wi.WriteReq, error) {
+	ch := make(chan wi.WriteReq, 1)
+	d.lock.Lock()
+	d.waiters[id] = ch
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		delete(d.waiters, id)
+		d.lock.Unlock()
+		_ = wi.DefaultClient.UnsubscribeFile(id, t.sender)
+	}()
+	if progress == nil {
+		progress = func(int, int) {}
+	}
+	if err := wi.DefaultClient.SubscribeFile(id, t.sender, progress); err != nil {
+		return wi.WriteReq{}, err
+	}
+	select {
+	case req := <-ch:
+		return req, nil
+	case <-t.stop:
+		return wi.WriteReq{}, errPaused
+	case <-time.After(timeout):
+		return wi.WriteReq{}, errTimeout
+	}
+}
+
+// legacy downloads the whole file at once from peers that don't serve manifests.
+// It holds the download slot until the content is received or the task stops.
+func (d *Downloader) legacy(t *downloadTask) error {
+	ch := make(chan struct{})
+	d.lock.Lock()
+	d.plain[t.FileId] = ch
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		delete(d.plain, t.FileId)
+		d.lock.Unlock()
+	}()
+	if err := wi.DefaultClient.SubscribeFile(t.FileId, t.sender, t.report); err != nil {
+		return err
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-t.stop:
+		_ = wi.DefaultClient.UnsubscribeFile(t.FileId, t.sender)
+		return errPaused
+	}
+}
+
+// Received ends the plain download of fileId once its content arrived.
+func (d *Downloader) Received(fileId uint32) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if ch, ok := d.plain[fileId]; ok {
+		close(ch)
+		delete(d.plain, fileId)
+	}
+}
+
+func (d *Downloader) partial(fileId uint32) *PartialDownload {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.partials[fileId]
+}
+
+func (d *Downloader) save(p *PartialDownload) {
+	d.lock.Lock()
+	d.partials[p.FileId] = p
+	d.lock.Unlock()
+	d.flush()
+}
+
+func (d *Downloader) remove(fileId uint32) {
+	d.lock.Lock()
+	delete(d.partials, fileId)
+	d.lock.Unlock()
+	d.flush()
+}
+
+func (d *Downloader) flush() {
+	// the latest state must be written last
+	d.flushLock.Lock()
+	defer d.flushLock.Unlock()
+	d.lock.Lock()
+	data, err := json.Marshal(d.partials)
+	d.lock.Unlock()
+	if err != nil {
+		log.Printf("Marshall partial downloads failed: %v", err)
+		return
+	}
+	if err = writeFileAtomic(GetDataPath(d.filename), data); err != nil {
+		log.Printf("Write partial downloads failed: %v", err)
+	}
+}
+
+var Downloads = NewDownloader("download.partial.json")
@@ -0,0 +1,100 @@
+package view
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// draftDebounce delays writes so that bursts of edits hit the disk once.
+const draftDebounce = 800 * time.Millisecond
+
+// Draft is an unsent message of a room, including the caret position.
+type Draft struct {
+	Text       string    `json:"text"`
+	CaretStart int       `json:"caretStart"`
+	CaretEnd   int       `json:"caretEnd"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// DraftStore keeps drafts per room (chat sign) and persists them as json
+// in the data dir. It is safe for concurrent use.
+type DraftStore struct {
+	filename string
+	drafts   map[string]Draft
+	timer    *time.Timer
+	dirty    bool
+	lock     sync.Mutex
+}
+
+// NewDraftStore loads drafts stored in filename under the data dir.
+func NewDraftStore(filename string) *DraftStore {
+	s := &DraftStore{filename: filename, drafts: make(map[string]Draft)}
+	data, err := os.ReadFile(GetDataPath(filename))
+	if err != nil {
+		return s
+	}
+	if err = json.Unmarshal(data, &s.drafts); err != nil {
+		log.Printf("Unmarshall drafts failed: %v", err)
+	}
+	return s
+}
+
+// Load returns the draft of room.
+func (s *DraftStore) Load(room string) (Draft, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	d, ok := s.drafts[room]
+	return d, ok
+}
+
+// Save updates the draft of room, an empty draft removes it.
+// The change is written to disk after draftDebounce.
+func (s *DraftStore) Save(room string, d Draft) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.drafts[room]; ok && old.Text == d.Text && old.CaretStart == d.CaretStart && old.CaretEnd == d.CaretEnd {
+		return
+	}
+	if d.Text == "" {
+		delete(s.drafts, room)
+	} else {
+		d.UpdatedAt = time.Now()
+		s.drafts[room] = d
+	}
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(draftDebounce, s.Flush)
+	} else {
+		s.timer.Reset(draftDebounce)
+	}
+}
+
+// Delete removes the draft of room.
+func (s *DraftStore) Delete(room string) {
+	s.Save(room, Draft{})
+}
+
+// Flush writes pending changes immediately.
+func (s *DraftStore) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dirty {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	data, err := json.Marshal(s.drafts)
+	if err != nil {
+		log.Printf("Marshall drafts failed: %v", err)
+		return
+	}
+	if err = writeFileAtomic(GetDataPath(s.filename), data); err != nil {
+		log.Printf("Write drafts failed: %v", err)
+		return
+	}
+	s.dirty = false
+}
@@ -0,0 +1,27 @@
+package view
+
+import (
+	"testing"
+
+	"github.com/CoyAce/wi"
+)
+
+func TestDraftPersistence(t *testing.T) {
+	wi.DefaultClient = &wi.Client{Identity: wi.Identity{UUID: "#00001"}}
+	wi.Mkdir(GetDir(wi.DefaultClient.ID()))
+	wi.RemoveFile(GetDataPath("drafts.json"))
+	s := NewDraftStore("drafts.json")
+	s.Save("room-a", Draft{Text: "hello wor", CaretStart: 5, CaretEnd: 5})
+	s.Save("room-b", Draft{Text: "bye"})
+	s.Delete("room-b")
+	s.Flush()
+
+	s = NewDraftStore("drafts.json")
+	d, ok := s.Load("room-a")
+	if !ok || d.Text != "hello wor" || d.CaretStart != 5 {
+		t.Errorf("draft of room-a should be restored, but %+v", d)
+	}
+	if _, ok = s.Load("room-b"); ok {
+		t.Errorf("draft of room-b should be deleted")
+	}
+}
@@ -0,0 +1,478 @@
+package view
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"mushin/assets/icons"
+	"mushin/internal/audio"
+	"os"
+	"sync"
+	"time"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/CoyAce/apm"
+)
+
+// EnhancerProfile is the enhancement setup saved in the config dir. Preset
+// names the profile it came from and is empty once tuned by hand.
+// Calibrations hold the echo delay measured for each microphone and speaker
+// pair, they outlive preset changes.
+type EnhancerProfile struct {
+	Preset       string                       `json:"preset"`
+	Config       *audio.EnhancementConfig     `json:"config"`
+	Calibrations map[string]audio.Calibration `json:"calibrations,omitempty"`
+}
+
+// calibrationKey names the microphone and speaker pair selected in p, an
+// empty name is the system default.
+func calibrationKey(p Preferences) string {
+	return p.CaptureDevice + "|" + p.PlaybackDevice
+}
+
+// enhancerDebounce delays saving and applying the profile so that stepping a
+// setting several times rebuilds the echo canceller once.
+const enhancerDebounce = 400 * time.Millisecond
+
+// EnhancerStore persists the EnhancerProfile and applies it to the enhancer.
+// It is safe for concurrent use.
+type EnhancerStore struct {
+	filename string
+	profile  EnhancerProfile
+	timer    *time.Timer
+	dirty    bool
+	lock     sync.Mutex
+}
+
+// LoadEnhancerProfile reads filename under the config dir, the Voice preset
+// is used when there is none.
+func LoadEnhancerProfile(filename string) *EnhancerStore {
+	s := &EnhancerStore{filename: filename, profile: EnhancerProfile{Preset: "Voice", Config: audio.VoicePreset()}}
+	data, err := os.ReadFile(GetConfig(filename))
+	if err != nil {
+		return s
+	}
+	var profile EnhancerProfile
+	if err = json.Unmarshal(data, &profile); err != nil || profile.Config == nil {
+		log.Printf("Unmarshall enhancer profile failed: %v", err)
+		return s
+	}
+	if profile.Config.ApmConfig == nil {
+		profile.Config.ApmConfig = audio.RawPreset().ApmConfig
+	}
+	s.profile = profile
+	return s
+}
+
+// Get returns a copy of the current profile.
+func (s *EnhancerStore) Get() EnhancerProfile {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return EnhancerProfile{Preset: s.profile.Preset, Config: s.profile.Config.Clone(), Calibrations: maps.Clone(s.profile.Calibrations)}
+}
+
+// Calibration returns the calibration of the devices selected now.
+func (s *EnhancerStore) Calibration() (audio.Calibration, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := s.profile.Calibrations[calibrationKey(Prefs.Get())]
+	return c, ok
+}
+
+// Apply hands the profile to the enhancer, running calls and recordings
+// pick it up with their next frame. The echo delay calibrated for the
+// selected devices replaces the one of the profile.
+func (s *EnhancerStore) Apply() {
+	config := s.Get().Config
+	if c, ok := s.Calibration(); ok && config.ApmConfig != nil {
+		config.ApmConfig.EchoCancellation.StreamDelayMs = c.StreamDelayMs
+	}
+	enhancer.UpdateConfig(config)
+}
+
+// Update applies f under the lock, the result is saved and applied shortly
+// after the last update.
+func (s *EnhancerStore) Update(f func(p *EnhancerProfile)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(&s.profile)
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(enhancerDebounce, s.Flush)
+	} else {
+		s.timer.Reset(enhancerDebounce)
+	}
+}
+
+// Flush saves and applies pending changes immediately.
+func (s *EnhancerStore) Flush() {
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.dirty = false
+	data, err := json.Marshal(s.profile)
+	s.lock.Unlock()
+	s.Apply()
+	if err != nil {
+		log.Printf("Marshall enhancer profile failed: %v", err)
+		return
+	}
+	if err = writeFileAtomic(GetConfig(s.filename), data); err != nil {
+		log.Printf("Write enhancer profile failed: %v", err)
+	}
+}
+
+var Enhancement = &EnhancerStore{filename: "enhancer.json", profile: EnhancerProfile{Preset: "Voice", Config: audio.VoicePreset()}}
+
+// enhancerToggle switches a stage of the enhancer on or off.
+type enhancerToggle struct {
+	label string
+	value func(c *audio.EnhancementConfig) *bool
+	widget.Bool
+}
+
+// enhancerStep steps a setting of the enhancer between min and max.
+type enhancerStep struct {
+	label      string
+	get        func(c *audio.EnhancementConfig) float64
+	set        func(c *audio.EnhancementConfig, v float64)
+	step       float64
+	min, max   float64
+	format     func(v float64) string
+	less, more widget.Clickable
+}
+
+type enhancerSection struct {
+	title   string
+	toggles []*enhancerToggle
+	steps   []*enhancerStep
+}
+
+func unitFormat(format string) func(v float64) string {
+	return func(v float64) string { return fmt.Sprintf(format, v) }
+}
+
+var nsLevels = []string{"Low", "Moderate", "High", "Very high"}
+
+// EnhancerSettings edits the Enhancement profile, the stages of the pipeline
+// in the order the audio passes them. The preamp is only switched on and off,
+// its target level and max gain are fixed.
+type EnhancerSettings struct {
+	*material.Theme
+	presets  []widget.Clickable
+	sections []*enhancerSection
+}
+
+func NewEnhancerSettings(theme *material.Theme) *EnhancerSettings {
+	s := &EnhancerSettings{Theme: theme, presets: make([]widget.Clickable, len(audio.Presets))}
+	s.sections = []*enhancerSection{
+		{
+			title: "High-pass",
+			toggles: []*enhancerToggle{
+				{label: "80 Hz filter", value: func(c *audio.EnhancementConfig) *bool { return &c.HighPassFilterEnabled }},
+				{label: "Echo canceller filter", value: func(c *audio.EnhancementConfig) *bool { return &c.ApmConfig.HighPassFilterEnabled }},
+			},
+		},
+		{
+			title: "Preamp",
+			toggles: []*enhancerToggle{
+				{label: "Preamp", value: func(c *audio.EnhancementConfig) *bool { return &c.PreampEnabled }},
+				{label: "Capture level", value: func(c *audio.EnhancementConfig) *bool { return &c.ApmConfig.CaptureLevelAdjustment.Enabled }},
+			},
+			steps: []*enhancerStep{
+				{
+					label: "Pre-gain",
+					get: func(c *audio.EnhancementConfig) float64 {
+						return float64(c.ApmConfig.CaptureLevelAdjustment.PreGainFactor)
+					},
+					set: func(c *audio.EnhancementConfig, v float64) {
+						c.ApmConfig.CaptureLevelAdjustment.PreGainFactor = float32(v)
+					},
+					step: 0.5, min: 0.5, max: 16, format: unitFormat("×%.1f"),
+				},
+				{
+					label: "Analog level",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.StreamAnalogLevel) },
+					set: func(c *audio.EnhancementConfig, v float64) {
+						c.StreamAnalogLevel = int(v)
+						c.ApmConfig.CaptureLevelAdjustment.AnalogMicGainEmulation.InitialLevel = int(v)
+					},
+					step: 10, min: 0, max: 250, format: unitFormat("%.0f"),
+				},
+			},
+		},
+		{
+			title: "Echo cancellation",
+			toggles: []*enhancerToggle{
+				{label: "Echo cancellation", value: func(c *audio.EnhancementConfig) *bool { return &c.ApmConfig.EchoCancellation.Enabled }},
+			},
+			steps: []*enhancerStep{
+				{
+					label: "Default delay",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.ApmConfig.EchoCancellation.StreamDelayMs) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.ApmConfig.EchoCancellation.StreamDelayMs = int(v) },
+					step:  10, min: 0, max: 500, format: unitFormat("%.0f ms"),
+				},
+			},
+		},
+		{
+			title: "Noise suppression",
+			toggles: []*enhancerToggle{
+				{label: "Noise suppression", value: func(c *audio.EnhancementConfig) *bool { return &c.ApmConfig.NoiseSuppression.Enabled }},
+			},
+			steps: []*enhancerStep{
+				{
+					label: "Level",
+					get: func(c *audio.EnhancementConfig) float64 {
+						return float64(c.ApmConfig.NoiseSuppression.SuppressionLevel)
+					},
+					set: func(c *audio.EnhancementConfig, v float64) {
+						c.ApmConfig.NoiseSuppression.SuppressionLevel = apm.NsLevel(v)
+					},
+					step: 1, min: 0, max: float64(len(nsLevels) - 1),
+					format: func(v float64) string { return nsLevels[int(v)] },
+				},
+			},
+		},
+		{
+			title: "Gain control",
+			toggles: []*enhancerToggle{
+				{label: "Echo canceller gain", value: func(c *audio.EnhancementConfig) *bool { return &c.ApmConfig.GainControl.Enabled }},
+				{label: "Automatic gain", value: func(c *audio.EnhancementConfig) *bool { return &c.AGC.Enabled }},
+			},
+			steps: []*enhancerStep{
+				{
+					label: "Max gain",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.ApmConfig.GainControl.MaxGainDB) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.ApmConfig.GainControl.MaxGainDB = float32(v) },
+					step:  5, min: 0, max: 60, format: unitFormat("%.0f dB"),
+				},
+				{
+					label: "Target level",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.AGC.TargetLevel) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.AGC.TargetLevel = float32(v) },
+					step:  1, min: -40, max: -6, format: unitFormat("%.0f dBFS"),
+				},
+				{
+					label: "Noise gate",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.AGC.NoiseGateThreshold) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.AGC.NoiseGateThreshold = float32(v) },
+					step:  5, min: -70, max: -20, format: unitFormat("%.0f dB"),
+				},
+			},
+		},
+		s.equalizerSection(),
+		{
+			title: "De-esser",
+			toggles: []*enhancerToggle{
+				{label: "De-esser", value: func(c *audio.EnhancementConfig) *bool { return &c.DeEsser.Enabled }},
+			},
+			steps: []*enhancerStep{
+				{
+					label: "Threshold",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.DeEsser.Threshold) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.DeEsser.Threshold = float32(v) },
+					step:  1, min: -50, max: 0, format: unitFormat("%.0f dB"),
+				},
+				{
+					label: "Reduction",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.DeEsser.Reduction) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.DeEsser.Reduction = float32(v) },
+					step:  0.1, min: 0, max: 1, format: unitFormat("%.1f"),
+				},
+			},
+		},
+		{
+			title: "Compressor",
+			toggles: []*enhancerToggle{
+				{label: "Compressor", value: func(c *audio.EnhancementConfig) *bool { return &c.Compression.Enabled }},
+			},
+			steps: []*enhancerStep{
+				{
+					label: "Threshold",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.Compression.Threshold) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.Compression.Threshold = float32(v) },
+					step:  1, min: -40, max: 0, format: unitFormat("%.0f dB"),
+				},
+				{
+					label: "Ratio",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.Compression.Ratio) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.Compression.Ratio = float32(v) },
+					step:  0.5, min: 1, max: 20, format: unitFormat("%.1f:1"),
+				},
+				{
+					label: "Makeup gain",
+					get:   func(c *audio.EnhancementConfig) float64 { return float64(c.Compression.MakeupGain) },
+					set:   func(c *audio.EnhancementConfig, v float64) { c.Compression.MakeupGain = float32(v) },
+					step:  1, min: 0, max: 20, format: unitFormat("%.0f dB"),
+				},
+			},
+		},
+	}
+	return s
+}
+
+// equalizerSection has a gain step for each band of the Voice preset, all
+// presets share its bands.
+func (s *EnhancerSettings) equalizerSection() *enhancerSection {
+	section := &enhancerSection{
+		title: "Equalizer",
+		toggles: []*enhancerToggle{
+			{label: "Equalizer", value: func(c *audio.EnhancementConfig) *bool { return &c.Equalizer.Enabled }},
+		},
+	}
+	for i, band := range audio.VoicePreset().Equalizer.Bands {
+		section.steps = append(section.steps, &enhancerStep{
+			label: fmt.Sprintf("%.0f Hz", band.Frequency),
+			get: func(c *audio.EnhancementConfig) float64 {
+				if i >= len(c.Equalizer.Bands) {
+					return 0
+				}
+				return float64(c.Equalizer.Bands[i].Gain)
+			},
+			set: func(c *audio.EnhancementConfig, v float64) {
+				if i < len(c.Equalizer.Bands) {
+					c.Equalizer.Bands[i].Gain = float32(v)
+				}
+			},
+			step: 0.5, min: -12, max: 12, format: unitFormat("%+.1f dB"),
+		})
+	}
+	return section
+}
+
+func (s *EnhancerSettings) update(gtx layout.Context) {
+	for i := range s.presets {
+		if s.presets[i].Clicked(gtx) {
+			name := audio.Presets[i]
+			Enhancement.Update(func(p *EnhancerProfile) {
+				p.Preset, p.Config = name, audio.Preset(name)
+			})
+		}
+	}
+	profile := Enhancement.Get()
+	for _, section := range s.sections {
+		for _, t := range section.toggles {
+			t.Value = *t.value(profile.Config)
+			if t.Update(gtx) {
+				value := t.Value
+				Enhancement.Update(func(p *EnhancerProfile) {
+					p.Preset = ""
+					*t.value(p.Config) = value
+				})
+			}
+		}
+		for _, step := range section.steps {
+			v := step.get(profile.Config)
+			if step.less.Clicked(gtx) {
+				v = max(step.min, v-step.step)
+			}
+			if step.more.Clicked(gtx) {
+				v = min(step.max, v+step.step)
+			}
+			if v != step.get(profile.Config) {
+				Enhancement.Update(func(p *EnhancerProfile) {
+					p.Preset = ""
+					step.set(p.Config, v)
+				})
+			}
+		}
+	}
+}
+
+func (s *EnhancerSettings) Layout(gtx layout.Context) layout.Dimensions {
+	s.update(gtx)
+	profile := Enhancement.Get()
+	children := []layout.FlexChild{
+		layout.Rigid(s.drawPresets(profile.Preset)),
+	}
+	for _, section := range s.sections {
+		children = append(children,
+			layout.Rigid(layout.Spacer{Height: unit.Dp(12)}.Layout),
+			layout.Rigid(s.drawSection(section, profile.Config)),
+		)
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+func (s *EnhancerSettings) drawPresets(current string) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		children := make([]layout.FlexChild, 0, 2*len(s.presets)+1)
+		for i, name := range audio.Presets {
+			children = append(children,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					button := material.Button(s.Theme, &s.presets[i], name)
+					if name != current {
+						button.Background.A = 80
+					}
+					return button.Layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+			)
+		}
+		if current == "" {
+			children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(s.Theme, s.TextSize*0.85, "Custom")
+				label.Font.Style = font.Italic
+				return label.Layout(gtx)
+			}))
+		}
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx, children...)
+	}
+}
+
+func (s *EnhancerSettings) drawSection(section *enhancerSection, config *audio.EnhancementConfig) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		children := []layout.FlexChild{
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(s.Theme, s.TextSize*0.9, section.title)
+				label.Font.Weight = font.Bold
+				return label.Layout(gtx)
+			}),
+		}
+		for _, t := range section.toggles {
+			children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, material.Label(s.Theme, s.TextSize*0.85, t.label).Layout),
+					layout.Rigid(material.Switch(s.Theme, &t.Bool, t.label).Layout),
+				)
+			}))
+		}
+		for _, step := range section.steps {
+			children = append(children, layout.Rigid(s.drawStep(step, step.format(step.get(config)))))
+		}
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	}
+}
+
+func (s *EnhancerSettings) drawStep(step *enhancerStep, value string) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		action := func(button *widget.Clickable, icon *widget.Icon) layout.FlexChild {
+			return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(24)
+					return icon.Layout(gtx, s.ContrastBg)
+				})
+			})
+		}
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Flexed(1, material.Label(s.Theme, s.TextSize*0.85, step.label).Layout),
+			action(&step.less, icons.RemoveIcon),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(96)
+				return layout.Center.Layout(gtx, material.Label(s.Theme, s.TextSize*0.85, value).Layout)
+			}),
+			action(&step.more, icons.AddIcon),
+		)
+	}
+}
@@ -5,11 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/draw"
-	"image/gif"
 	"io"
 	"log"
-	"math/rand"
+	"mushin/internal/cache"
+	"mushin/internal/imaging"
 	"mushin/ui/native"
 	"os"
 	"os/exec"
@@ -27,12 +26,17 @@ import (
 
 var Picker *explorer.Explorer
 
+// maxDisplayEdge bounds the images kept for drawing, originals are opened externally.
+const maxDisplayEdge = 2048
+
 type FileDescription struct {
 	ID   uint32
 	File io.ReadSeekCloser `json:"-"`
 	Name string
 	Path string
 	Size int64
+	// Strip sends the photo at Path without its metadata, Size is the stripped size
+	Strip bool `json:",omitempty"`
 }
 
 // OpenInFinder 在Finder中打开指定路径
@@ -64,6 +68,86 @@ func ChooseFile() (FileDescription, error) {
 	return ResolveFileDescription(file)
 }
 
+// ChooseFiles lets the user pick several files at once.
+func ChooseFiles(extensions ...string) ([]FileDescription, error) {
+	files, err := Picker.ChooseFiles(extensions...)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]FileDescription, 0, len(files))
+	for _, file := range files {
+		fd, err := ResolveFileDescription(file)
+		if err != nil {
+			log.Printf("resolve file failed: %v", err)
+			continue
+		}
+		ret = append(ret, fd)
+	}
+	return ret, nil
+}
+
+// ChooseImages lets the user pick several images, iOS photo picker is limited to one.
+func ChooseImages() ([]FileDescription, error) {
+	if runtime.GOOS == "ios" {
+		fd, err := ChooseImage()
+		if err != nil {
+			return nil, err
+		}
+		return []FileDescription{fd}, nil
+	}
+	return ChooseFiles(".jpg", ".jpeg", ".png", ".apng", ".webp", ".gif")
+}
+
+// errNoFolderPicker is returned by ChooseFolder where the system has no folder dialog,
+// on linux when none of the known dialogs is installed.
+var errNoFolderPicker = fmt.Errorf("choose folder unsupported on %s", runtime.GOOS)
+
+// ChooseFolder asks for a directory with the system dialog, the explorer has no folder picker.
+// A cancelled dialog is an error as well.
+func ChooseFolder() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", "POSIX path of (choose folder)")
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms;"+
+				"$d = New-Object System.Windows.Forms.FolderBrowserDialog;"+
+				"if ($d.ShowDialog() -eq 'OK') { $d.SelectedPath }")
+	case "linux":
+		cmd = linuxFolderPicker()
+		if cmd == nil {
+			return "", errNoFolderPicker
+		}
+	default:
+		return "", errNoFolderPicker
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", errors.New("no folder chosen")
+	}
+	return filepath.Clean(dir), nil
+}
+
+// linuxFolderPicker returns the first installed folder dialog, nil if there is none.
+func linuxFolderPicker() *exec.Cmd {
+	pickers := [][]string{
+		{"zenity", "--file-selection", "--directory"},
+		{"kdialog", "--getexistingdirectory"},
+		{"qarma", "--file-selection", "--directory"},
+	}
+	for _, p := range pickers {
+		if _, err := exec.LookPath(p[0]); err == nil {
+			return exec.Command(p[0], p[1:]...)
+		}
+	}
+	return nil
+}
+
 func ResolveFileDescription(file io.ReadCloser) (FileDescription, error) {
 	if file == nil {
 		return FileDescription{}, errors.New("file is nil")
@@ -92,7 +176,7 @@ func ChooseImage() (FileDescription, error) {
 		}
 		file, err = os.Open(path)
 	} else {
-		file, err = Picker.ChooseFile(".jpg", ".jpeg", ".png", ".webp", ".gif")
+		file, err = Picker.ChooseFile(".jpg", ".jpeg", ".png", ".apng", ".webp", ".gif")
 		if err != nil {
 			return FileDescription{}, err
 		}
@@ -100,148 +184,134 @@ func ChooseImage() (FileDescription, error) {
 	return ResolveFileDescription(file)
 }
 
-func decodeGif(file io.ReadCloser) (*gif.GIF, error) {
-	gif, err := gif.DecodeAll(file)
-	if err != nil {
-		return nil, err
-	}
-	return gif, nil
-}
-
 func decodeImage(file io.ReadCloser) (image.Image, error) {
-	var img, _, err = image.Decode(bufio.NewReader(file))
+	r := bufio.NewReaderSize(file, 1<<16)
+	// the EXIF segment fits in the first 64KB of a jpeg
+	header, _ := r.Peek(1 << 16)
+	orientation := imaging.Orientation(header)
+	var img, _, err = image.Decode(r)
 	if err != nil {
 		// try with webp
 		log.Printf("image decode error:%v", err)
 		img, err = webp.Decode(bufio.NewReader(file))
 	}
-	return img, err
-}
-
-func LoadImage(filePath string, reload bool) *image.Image {
-	img := ICache.Load(filePath)
-	if img != nil && !reload {
-		return img
+	if err != nil {
+		return nil, err
 	}
-	return ICache.Reload(filePath)
+	return imaging.Orient(img, orientation), nil
 }
 
-func LoadAvatar(filePath string, reload bool) *image.Image {
-	img := ACache.Load(filePath)
-	if img != nil && !reload {
-		return img
-	}
-	return ACache.Reload(filePath)
+type mediaKind uint8
+
+const (
+	mediaImage mediaKind = iota
+	mediaGif
+	// mediaSticker is a gif composed at sticker size
+	mediaSticker
+	// mediaAnimated is whether a file holds an animation, nil while it is read
+	mediaAnimated
+)
+
+type mediaKey struct {
+	kind mediaKind
+	path string
 }
 
-func IsGPUFriendly(img image.Image) bool {
-	switch img.(type) {
-	case *image.Uniform:
-		return true
-	case *image.RGBA:
-		return true
+const (
+	// mediaBudget bounds the decoded bytes of images, avatars and gifs together.
+	mediaBudget = 192 << 20
+	// retryAfter is how long a failed load is remembered before the file is tried again.
+	retryAfter = 10 * time.Second
+	// animatedCost is charged for a cached animation flag, so that flags are
+	// evicted along with the media.
+	animatedCost = 64
+)
+
+// MediaCache holds every decoded image, avatar and gif, costed by their pixel bytes.
+var MediaCache = cache.New[mediaKey, any](mediaBudget)
+
+func LoadImage(filePath string, reload bool) *image.Image {
+	key := mediaKey{kind: mediaImage, path: filePath}
+	if v, ok := MediaCache.Get(key); ok && !reload {
+		return v.(*image.Image)
 	}
-	return false
+	return loadImage(key)
 }
 
-func ConvertToGPUFriendlyImage(src image.Image) *image.RGBA {
-	sz := src.Bounds().Size()
-	// Copy the image into a GPU friendly format.
-	dst := image.NewRGBA(image.Rectangle{
-		Max: sz,
-	})
-	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
-	return dst
+func LoadAvatar(filePath string, reload bool) *image.Image {
+	return LoadImage(filePath, reload)
 }
 
 func LoadGif(filePath string, reload bool) *Gif {
-	gifImg := GCache.Load(filePath)
-	if gifImg != nil && !reload {
-		return gifImg
-	}
-	return GCache.Reload(filePath)
+	return loadGif(mediaKey{kind: mediaGif, path: filePath}, gifMaxEdge, reload)
 }
 
-type GifEntry struct {
-	path string
-	gif  *Gif
-	ttl  time.Time
-	hit  int
-}
-
-type GifCache struct {
-	data          []GifEntry
-	capacity      int
-	ratio         int
-	youngCapacity int
-	youngHead     int
-}
-
-func (g *GifCache) Load(path string) *Gif {
-	for i, entry := range g.data {
-		if entry.path == path {
-			if i < g.youngCapacity {
-				g.data[i].hit++
-				if g.data[i].hit >= 15 {
-					g.promote(i)
-				} else {
-					g.setTTL(i)
-				}
-			}
-			return entry.gif
-		}
-	}
-	return g.add(path)
+// LoadSticker loads an animated sticker with its frames composed to fit edge.
+func LoadSticker(filePath string, edge int) *Gif {
+	return loadGif(mediaKey{kind: mediaSticker, path: filePath}, edge, false)
 }
 
-func (g *GifCache) Reload(path string) *Gif {
-	for i, entry := range g.data {
-		if entry.path == path {
-			gifImg, err := g.load(path)
-			if err != nil {
-				return g.data[i].gif
-			}
-			g.data[i].gif = gifImg
-			g.setTTL(i)
-			return gifImg
+func loadGif(key mediaKey, edge int, reload bool) *Gif {
+	v, ok := MediaCache.Get(key)
+	if ok && !reload {
+		return v.(*Gif)
+	}
+	a, err := loadAnimation(key.path)
+	if err != nil {
+		if ok {
+			// keep showing what was decoded before
+			return v.(*Gif)
 		}
+		gifImg := &Gif{}
+		MediaCache.Set(key, gifImg, 0)
+		forgetLater(key, gifImg)
+		return gifImg
 	}
-	return g.Load(path)
-}
-
-func (g *GifCache) promote(i int) {
-	idx := rand.Intn(g.capacity-g.youngCapacity) + g.youngCapacity
-	temp := g.data[idx]
-	temp.hit = 0
-	g.data[idx] = g.data[i]
-	g.data[i] = temp
+	return storeGif(key, a, edge)
 }
 
-func (g *GifCache) addYoung(e GifEntry) *Gif {
-	g.data[g.youngHead] = e
-	g.setTTL(g.youngHead)
-	g.youngHead = (g.youngHead + 1) % g.youngCapacity
-	return e.gif
+// StoreGif caches a as the animation at path, its frames are composed in the background.
+func StoreGif(path string, a *imaging.Animation) *Gif {
+	return storeGif(mediaKey{kind: mediaGif, path: path}, a, gifMaxEdge)
 }
 
-func (g *GifCache) addDefault(path string) *Gif {
-	gifImg := new(Gif)
-	*gifImg = Gif{}
-	e := GifEntry{path: path, gif: gifImg}
-	g.addYoung(e)
+func storeGif(key mediaKey, a *imaging.Animation, edge int) *Gif {
+	gifImg := newGif(a, edge)
+	MediaCache.Set(key, gifImg, gifCost(a, edge))
 	return gifImg
 }
 
-func (g *GifCache) add(path string) *Gif {
-	gifImg, err := g.load(path)
-	if err != nil {
-		return g.addDefault(path)
-	}
-	e := GifEntry{path: path, gif: gifImg}
-	return g.addYoung(e)
+// loadImage decodes in the background, the returned pointer is filled in
+// when done and stays nil if the file cannot be read.
+func loadImage(key mediaKey) *image.Image {
+	ptr := new(image.Image)
+	MediaCache.Set(key, ptr, 0)
+	go func() {
+		file, err := Open(key.path)
+		if err != nil {
+			log.Printf("open %v error: %v", key.path, err)
+			forgetLater(key, ptr)
+			return
+		}
+		defer file.Close()
+
+		img, err := decodeImage(file)
+		if err != nil {
+			log.Printf("failed to decode image: %v", err)
+			forgetLater(key, ptr)
+			return
+		}
+		// convert and scale in one pass, chat images are never drawn larger
+		img = imaging.Fit(img, maxDisplayEdge, maxDisplayEdge, imaging.Area)
+		*ptr = img
+		MediaCache.SetCost(key, imageCost(img))
+		InvalidateRequest <- struct{}{}
+	}()
+	return ptr
 }
 
-func (g *GifCache) load(path string) (*Gif, error) {
+// loadAnimation decodes a GIF, APNG or animated WebP.
+func loadAnimation(path string) (*imaging.Animation, error) {
 	file, err := Open(path)
 	if err != nil {
 		log.Printf("open %v error: %v", path, err)
@@ -249,138 +319,112 @@ func (g *GifCache) load(path string) (*Gif, error) {
 	}
 	defer file.Close()
 
-	gifImg, err := gif.DecodeAll(file)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("failed to decode gif: %v", err)
+		log.Printf("read %v error: %v", path, err)
 		return nil, err
 	}
-	ret := &Gif{GIF: gifImg}
-	return ret, nil
-}
-
-func (g *GifCache) setTTL(idx int) {
-	ttl := 10 * time.Second
-	g.data[idx].ttl = time.Now().Add(ttl)
-	time.AfterFunc(ttl, func() {
-		if g.data[idx].gif != nil && time.Now().After(g.data[idx].ttl) {
-			g.data[idx] = GifEntry{}
-		}
-	})
-}
-
-func (g *GifCache) Reset() {
-	g.data = make([]GifEntry, g.capacity)
-	g.youngHead = 0
+	a, err := imaging.DecodeAnimation(data)
+	if err != nil {
+		log.Printf("failed to decode animation: %v", err)
+		return nil, err
+	}
+	return a, nil
 }
 
-func NewGifCache(capacity int, ratio int) *GifCache {
-	return &GifCache{
-		capacity:      capacity,
-		data:          make([]GifEntry, capacity),
-		ratio:         ratio,
-		youngCapacity: int(float32(capacity) * float32(ratio) / float32(ratio+1)),
+// animatedByExt tells animations apart by the extension of path, decided is
+// false when the header has to be read.
+func animatedByExt(path string) (animated, decided bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif":
+		return true, true
+	case ".png", ".apng", ".webp":
+		return false, false
+	default:
+		return false, true
 	}
 }
 
-type ImageEntry struct {
-	path string
-	img  *image.Image
-	ttl  time.Time
-	hit  int
-}
-
-type ImageCache struct {
-	data          []ImageEntry
-	capacity      int
-	ratio         int
-	youngCapacity int
-	youngHead     int
-}
-
-func (c *ImageCache) Load(path string) *image.Image {
-	for i, entry := range c.data {
-		if entry.path == path {
-			if i < c.youngCapacity {
-				c.data[i].hit++
-				if c.data[i].hit >= 15 {
-					c.promote(i)
-				} else {
-					c.setTTL(i)
-				}
-			}
-			return entry.img
-		}
+func readAnimated(path string) (bool, error) {
+	file, err := Open(path)
+	if err != nil {
+		return false, err
 	}
-	return c.add(path)
+	defer file.Close()
+	header := make([]byte, 1<<16)
+	n, _ := io.ReadFull(file, header)
+	return imaging.AnimationFormat(header[:n]) != "", nil
 }
 
-func (c *ImageCache) Reload(path string) *image.Image {
-	for i, entry := range c.data {
-		if entry.path == path {
-			img := c.load(path)
-			c.data[i].img = img
-			c.setTTL(i)
-			return img
+// isAnimated reports whether the image at path is a GIF, APNG or animated WebP.
+// It reads the header when it isn't cached, the UI goroutine uses animatedState.
+func isAnimated(path string) bool {
+	if animated, decided := animatedByExt(path); decided {
+		return animated
+	}
+	key := mediaKey{kind: mediaAnimated, path: path}
+	if v, ok := MediaCache.Get(key); ok {
+		if animated, ok := v.(bool); ok {
+			return animated
 		}
 	}
-	return c.Load(path)
-}
-
-func (c *ImageCache) promote(i int) {
-	idx := rand.Intn(c.capacity-c.youngCapacity) + c.youngCapacity
-	temp := c.data[idx]
-	temp.hit = 0
-	c.data[idx] = c.data[i]
-	c.data[i] = temp
+	animated, err := readAnimated(path)
+	if err != nil {
+		// not arrived yet, ask again later
+		return false
+	}
+	MediaCache.Set(key, animated, animatedCost)
+	return animated
 }
 
-func (c *ImageCache) addYoung(e ImageEntry) *image.Image {
-	c.data[c.youngHead] = e
-	c.setTTL(c.youngHead)
-	c.youngHead = (c.youngHead + 1) % c.youngCapacity
-	return e.img
+// animatedState is isAnimated without blocking the UI goroutine, the header is
+// read in the background and known is false until it was.
+func animatedState(path string) (animated, known bool) {
+	if animated, decided := animatedByExt(path); decided {
+		return animated, true
+	}
+	key := mediaKey{kind: mediaAnimated, path: path}
+	if v, ok := MediaCache.Get(key); ok {
+		animated, known = v.(bool)
+		return animated, known
+	}
+	MediaCache.Set(key, nil, animatedCost)
+	go func() {
+		animated, err := readAnimated(path)
+		if err != nil {
+			forgetLater(key, nil)
+			return
+		}
+		MediaCache.Set(key, animated, animatedCost)
+		invalidate()
+	}()
+	return false, false
 }
 
-func (c *ImageCache) setTTL(idx int) {
-	ttl := 10 * time.Second
-	c.data[idx].ttl = time.Now().Add(ttl)
-	time.AfterFunc(ttl, func() {
-		if c.data[idx].img != nil && time.Now().After(c.data[idx].ttl) {
-			c.data[idx] = ImageEntry{}
-		}
+// forgetLater drops the failed entry value so the file is read again once it
+// may have arrived, an entry stored again meanwhile is left alone.
+func forgetLater(key mediaKey, value any) {
+	time.AfterFunc(retryAfter, func() {
+		MediaCache.DeleteIf(key, func(v any) bool { return v == value })
 	})
 }
 
-func (c *ImageCache) add(path string) *image.Image {
-	img := c.load(path)
-	e := ImageEntry{path: path, img: img}
-	return c.addYoung(e)
+func imageCost(img image.Image) int64 {
+	b := img.Bounds()
+	return int64(b.Dx()) * int64(b.Dy()) * 4
 }
 
-func (c *ImageCache) load(path string) *image.Image {
-	ptr := new(image.Image)
-	go func() {
-		file, err := Open(path)
-		if err != nil {
-			log.Printf("open %v error: %v", path, err)
-			ptr = nil
-			return
-		}
-		defer file.Close()
-
-		img, err := decodeImage(file)
-		if err != nil {
-			log.Printf("failed to decode image: %v", err)
-			ptr = nil
-			return
+// gifCost counts the composed frames, which are scaled to fit edge.
+func gifCost(a *imaging.Animation, edge int) int64 {
+	w, h := a.Width, a.Height
+	if w > edge || h > edge {
+		if w > h {
+			w, h = edge, max(1, h*edge/w)
+		} else {
+			w, h = max(1, w*edge/h), edge
 		}
-		if !IsGPUFriendly(img) {
-			img = ConvertToGPUFriendlyImage(img)
-		}
-		*ptr = img
-		InvalidateRequest <- struct{}{}
-	}()
-	return ptr
+	}
+	return int64(len(a.Frames)) * int64(w) * int64(h) * 4
 }
 
 func Open(path string) (io.ReadCloser, error) {
@@ -390,24 +434,6 @@ func Open(path string) (io.ReadCloser, error) {
 	return os.Open(path)
 }
 
-func (c *ImageCache) Reset() {
-	c.data = make([]ImageEntry, c.capacity)
-	c.youngHead = 0
-}
-
-func NewImageCache(capacity int, ratio int) *ImageCache {
-	return &ImageCache{
-		capacity:      capacity,
-		data:          make([]ImageEntry, capacity),
-		ratio:         ratio,
-		youngCapacity: int(float32(capacity) * float32(ratio) / float32(ratio+1)),
-	}
-}
-
-var ACache = NewImageCache(10, 2)
-var ICache = NewImageCache(8, 2)
-var GCache = NewGifCache(15, 2)
-
 func GetDataDir() string {
 	dir, _ := app.DataDir()
 	if runtime.GOOS == "android" {
@@ -464,17 +490,3 @@ func SaveImg(img image.Image, filename string, rewrite bool) {
 		log.Printf("%s saved to %s", filename, filePath)
 	}
 }
-
-func SaveGif(gifImg *gif.GIF, filename string, rewrite bool) {
-	filePath := GetDataPath(filename)
-	_, err := os.Stat(filePath)
-	if err == nil && !rewrite {
-		return
-	}
-	file, err := os.Create(filePath)
-	defer file.Close()
-	if err != nil {
-		log.Printf("create file failed, %v", err)
-	}
-	wi.EncodeGif(file, filename, gifImg)
-}
@@ -1,62 +1,68 @@
 package view
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"log"
-	"mushin/assets/fonts"
-	"time"
-	"unsafe"
-
-	"github.com/CoyAce/wi"
+	"mushin/internal/cache"
+	"mushin/internal/imaging"
 )
 
-func ChooseAndSendFile(appendFile func(description *FileDescription)) func() {
-	return func() {
-		go func() {
-			fd, err := ChooseFile()
-			if err != nil {
-				log.Printf("Choose file failed: %v", err)
-				return
-			}
-			id := wi.Hash(unsafe.Pointer(&fd))
-			fc := FileControl{
-				Filename: fd.Name,
-				FileId:   id,
-				Path:     fd.Path,
-				Size:     uint64(fd.Size),
-				Mime:     NewMine(fd.Name),
-			}
-			message := &Message{
-				State: Stateless,
-				MessageStyle: MessageStyle{
-					Theme: fonts.DefaultTheme,
-				},
-				FileControl: fc,
-				Contacts:    FromMyself(),
-				MessageType: File,
-				CreatedAt:   time.Now(),
-			}
-			MessageBox <- message
-			fd.ID = id
-			appendFile(&fd)
-			err = wi.DefaultClient.PublishFile(fd.Name, uint64(fd.Size), id)
-			if err != nil {
-				log.Printf("Publish file failed, %v", err)
-				message.State = Failed
-			} else {
-				message.State = Sent
-			}
-		}()
+// strippedBudget bounds the stripped photos kept for serving, every chunk of
+// a photo is read from the same copy.
+const strippedBudget = 64 << 20
+
+// strippedFiles holds stripped photo bytes by path, costed by their length.
+var strippedFiles = cache.New[string, []byte](strippedBudget)
+
+// PublishContent uploads a published file, it blocks while the upload is queued.
+func PublishContent(fd *FileDescription) {
+	t := Transfers.Track(Upload, fd.ID, fd.Name, fd.Size)
+	Transfers.Publish(t, fd.Content(), fd.Name, uint64(fd.Size), fd.ID)
+}
+
+// Content opens what peers receive of fd, photos are stripped in memory so
+// that no copy is left behind.
+func (fd FileDescription) Content() func() (io.ReadSeekCloser, error) {
+	if !fd.Strip {
+		return Content(fd.Path)
+	}
+	return func() (io.ReadSeekCloser, error) {
+		stripped, err := stripFile(fd.Path)
+		if err != nil {
+			return nil, err
+		}
+		return nopSeekCloser{bytes.NewReader(stripped)}, nil
 	}
 }
 
-func PublishContent(fd *FileDescription) {
-	_ = wi.DefaultClient.PublishContent(Content(fd.Path), fd.Name, uint64(fd.Size), fd.ID)
+// stripFile returns the photo at path without metadata, stripped once while cached.
+func stripFile(path string) ([]byte, error) {
+	if data, ok := strippedFiles.Get(path); ok {
+		return data, nil
+	}
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		return nil, err
+	}
+	stripped, err := imaging.StripMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	strippedFiles.Set(path, stripped, int64(len(stripped)))
+	return stripped, nil
 }
 
 func Content(path string) func() (io.ReadSeekCloser, error) {
 	return func() (io.ReadSeekCloser, error) {
+		if isDir(path) {
+			return OpenFolderArchive(path)
+		}
 		r, err := Picker.ReadFile(path)
 		if err != nil {
 			return nil, err
@@ -1,10 +1,9 @@
 package view
 
 import (
-	"image"
-	"image/color"
-	"image/draw"
-	"image/gif"
+	"mushin/internal/imaging"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"gioui.org/f32"
@@ -20,73 +19,92 @@ const (
 	ShortEdgeFixed
 )
 
+// gifMaxEdge bounds composed frames, gifs are drawn smaller everywhere but the viewer.
+const gifMaxEdge = 480
+
+// gifAnimation holds the composed frames, uploaded to the GPU once and
+// then shared by every row showing the same file.
+type gifAnimation struct {
+	frames []paint.ImageOp
+	// ends is when each frame stops showing, counted from the start of a loop
+	ends  []time.Duration
+	total time.Duration
+}
+
+// Gif plays an animated GIF, APNG or WebP. The embedded Animation keeps only
+// the size and play count, the frames are composed by NewGif in the background.
 type Gif struct {
-	*gif.GIF
-	index     int
-	prevFrame image.Image
-	nextFrame time.Time
+	*imaging.Animation
+	anim  atomic.Pointer[gifAnimation]
+	start time.Time
+}
+
+// NewGif composes the frames of a in the background, nothing is drawn until they are ready.
+func NewGif(a *imaging.Animation) *Gif {
+	return newGif(a, gifMaxEdge)
+}
+
+// newGif composes the frames of a to fit edge.
+func newGif(a *imaging.Animation, edge int) *Gif {
+	ret := &Gif{Animation: &imaging.Animation{Width: a.Width, Height: a.Height, Plays: a.Plays}}
+	go func() {
+		frames := imaging.Compose(a, edge, edge, imaging.Area)
+		anim := &gifAnimation{}
+		for i, frame := range frames {
+			anim.frames = append(anim.frames, paint.NewImageOp(frame))
+			anim.total += a.Frames[i].Delay
+			anim.ends = append(anim.ends, anim.total)
+		}
+		ret.anim.Store(anim)
+		InvalidateRequest <- struct{}{}
+	}()
+	return ret
+}
+
+// frameAt returns the frame shown after elapsed and when the next one is due,
+// next is zero once the animation has stopped.
+func (a *gifAnimation) frameAt(elapsed time.Duration, plays int) (index int, next time.Duration) {
+	if len(a.frames) < 2 {
+		return 0, 0
+	}
+	n := int(elapsed / a.total)
+	if plays > 0 && n >= plays {
+		return len(a.frames) - 1, 0
+	}
+	loop := time.Duration(n) * a.total
+	index = sort.Search(len(a.ends), func(i int) bool { return a.ends[i] > elapsed-loop })
+	return index, loop + a.ends[index]
 }
 
 func (g *Gif) Layout(gtx layout.Context, fit Fit) layout.Dimensions {
 	v := gtx.Constraints.Min.X
-	gtx.Constraints.Min.Y = int(float32(g.Config.Height) / float32(g.Config.Width) * float32(v))
-	if fit == ShortEdgeFixed && g.Config.Width > g.Config.Height {
+	gtx.Constraints.Min.Y = int(float32(g.Height) / float32(g.Width) * float32(v))
+	if fit == ShortEdgeFixed && g.Width > g.Height {
 		gtx.Constraints.Min.Y = v
-		gtx.Constraints.Min.X = int(float32(g.Config.Width) / float32(g.Config.Height) * float32(v))
+		gtx.Constraints.Min.X = int(float32(g.Width) / float32(g.Height) * float32(v))
 	}
+	d := layout.Dimensions{Size: gtx.Constraints.Min}
 
-	delay := time.Duration(10*g.Delay[g.index]) * time.Millisecond
-	if g.nextFrame == (time.Time{}) {
-		g.nextFrame = time.Now().Add(delay)
+	anim := g.anim.Load()
+	if anim == nil || len(anim.frames) == 0 {
+		return d
 	}
-	if !time.Now().Before(g.nextFrame) {
-		g.index = (g.index + 1) % len(g.Image)
-		delay = time.Duration(10*g.Delay[g.index]) * time.Millisecond
-		g.nextFrame = time.Now().Add(delay)
+	if g.start.IsZero() {
+		g.start = gtx.Now
 	}
+	index, next := anim.frameAt(gtx.Now.Sub(g.start), g.Plays)
+	frame := anim.frames[index]
 
+	size := frame.Size()
 	scale := f32.Point{
-		X: float32(gtx.Constraints.Min.X) / float32(g.Config.Width), Y: float32(gtx.Constraints.Min.Y) / float32(g.Config.Height),
+		X: float32(gtx.Constraints.Min.X) / float32(size.X), Y: float32(gtx.Constraints.Min.Y) / float32(size.Y),
 	}
 	defer op.Affine(f32.AffineId().Scale(f32.Point{}, scale)).Push(gtx.Ops).Pop()
-
-	canvas := g.extractFrame()
-	paint.NewImageOp(canvas).Add(gtx.Ops)
+	frame.Add(gtx.Ops)
 	paint.PaintOp{}.Add(gtx.Ops)
 
-	nextFrame := gtx.Now.Add(delay)
-	gtx.Execute(op.InvalidateCmd{At: nextFrame})
-
-	return layout.Dimensions{Size: gtx.Constraints.Min}
-}
-
-func (g *Gif) extractFrame() *image.RGBA {
-	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
-	frame := g.Image[g.index]
-	switch g.Disposal[g.index] {
-	default:
-		fallthrough
-	case gif.DisposalNone:
-		// 不做特殊处理，直接叠加
-		if g.index != 0 {
-			draw.Draw(canvas, g.prevFrame.Bounds(), g.prevFrame, image.Point{}, draw.Over)
-		}
-		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
-		g.prevFrame = canvas
-	case gif.DisposalBackground:
-		// 清除帧覆盖的区域，并用背景色填充
-		draw.Draw(canvas, g.prevFrame.Bounds(), g.prevFrame, image.Point{}, draw.Over)
-		var bgColor color.Color = color.Transparent
-		if palette, ok := g.Config.ColorModel.(color.Palette); ok {
-			bgColor = palette[g.BackgroundIndex]
-		}
-		draw.Draw(canvas, frame.Bounds(), &image.Uniform{C: bgColor}, frame.Bounds().Min, draw.Src)
-		g.prevFrame = canvas
-	case gif.DisposalPrevious:
-		// 恢复到前一帧的状态
-		// 与DisposalNone的区别是不更新prevFrame
-		draw.Draw(canvas, g.prevFrame.Bounds(), g.prevFrame, image.Point{}, draw.Over)
-		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	if next > 0 {
+		gtx.Execute(op.InvalidateCmd{At: g.start.Add(next)})
 	}
-	return canvas
+	return d
 }
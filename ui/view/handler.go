@@ -14,10 +14,7 @@ var OnSettingsSubmit = func() {
 	modal.DefaultModal.Dismiss(nil)
 }
 var SyncIcon = func() {
-	paths := []string{
-		GetPath(wi.DefaultClient.ID(), "icon.png"), GetPath(wi.DefaultClient.ID(), "icon.gif"),
-	}
-	for _, path := range paths {
+	for _, path := range iconPaths(wi.DefaultClient.ID()) {
 		i, err := os.Stat(path)
 		if err != nil {
 			continue
@@ -30,10 +27,7 @@ var SyncIcon = func() {
 }
 
 var PublishIcon = func() {
-	paths := []string{
-		GetPath(wi.DefaultClient.ID(), "icon.png"), GetPath(wi.DefaultClient.ID(), "icon.gif"),
-	}
-	for _, path := range paths {
+	for _, path := range iconPaths(wi.DefaultClient.ID()) {
 		i, err := os.Stat(path)
 		if err != nil {
 			continue
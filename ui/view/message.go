@@ -1,8 +1,6 @@
 package view
 
 import (
-	"bytes"
-	"context"
 	"errors"
 	"fmt"
 	"image"
@@ -15,13 +13,13 @@ import (
 	"mushin/assets/icons"
 	"mushin/internal/audio"
 	"mushin/ui/native"
-	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gioui.org/f32"
@@ -38,8 +36,6 @@ import (
 	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
-	"gioui.org/x/component"
-	"github.com/CoyAce/opus/ogg"
 	"github.com/CoyAce/wi"
 	"golang.org/x/exp/shiny/iconvg"
 	"golang.org/x/exp/shiny/materialdesign/colornames"
@@ -66,6 +62,8 @@ const (
 	GIF
 	Voice
 	File
+	Album
+	Sticker
 )
 
 // LongPressDuration is the default duration of a long press gesture.
@@ -205,6 +203,9 @@ type Message struct {
 	InteractiveSpan `json:"-"`
 	FileControl
 	TextControl
+	AlbumControl
+	ImageControl
+	StickerControl
 	MessageType
 	Contacts
 	CreatedAt time.Time
@@ -267,13 +268,14 @@ const (
 	Video
 	Ebook
 	Apk
+	Archive
 )
 
 func NewMine(filename string) Mime {
 	switch filepath.Ext(filename) {
 	case ".apk":
 		return Apk
-	case ".jpg", ".jpeg", ".png", ".webp", ".gif", ".svg":
+	case ".jpg", ".jpeg", ".png", ".apng", ".webp", ".gif", ".svg":
 		return Picture
 	case ".epub", ".pdf":
 		return Ebook
@@ -281,6 +283,8 @@ func NewMine(filename string) Mime {
 		return Music
 	case ".mp4", ".avi", ".mov", ".webm":
 		return Video
+	case ".tar", ".zip":
+		return Archive
 	default:
 		return Unknown
 	}
@@ -292,19 +296,33 @@ type FileControl struct {
 	Path     string
 	Size     uint64
 	Mime
+	Folder         *FolderSummary `json:",omitempty"`
 	progress       int
 	speed          int
+	transferring   bool
+	resumable      bool
+	corrupt        bool
 	saveButton     widget.Clickable
 	downloadButton widget.Clickable
 	browseButton   widget.Clickable
+	extractButton  widget.Clickable
 	imageBroken    bool
 }
 
+// fileState guards the transfer state of file bubbles, downloads change it
+// while frames read it.
+var fileState sync.RWMutex
+
 func (f *FileControl) downloading() bool {
-	return f.progress > 0 && f.progress < 100
+	fileState.RLock()
+	defer fileState.RUnlock()
+	// a chunked download may stop half way and be resumed later
+	return f.transferring || !f.resumable && f.progress > 0 && f.progress < 100
 }
 
 func (f *FileControl) downloaded() bool {
+	fileState.RLock()
+	defer fileState.RUnlock()
 	return f.progress == 100
 }
 
@@ -313,17 +331,7 @@ func (f *FileControl) processFileDownload(gtx layout.Context, sender string) {
 		return
 	}
 	log.Printf("downloading...")
-	go func() {
-		err := wi.DefaultClient.SubscribeFile(f.FileId, sender,
-			func(p int, s int) {
-				f.updateProgress(p)
-				f.updateSpeed(s)
-				InvalidateRequest <- struct{}{}
-			})
-		if err != nil {
-			log.Printf("Subsrcibe file failed: %v", err)
-		}
-	}()
+	Downloads.Start(f, sender)
 }
 
 func (f *FileControl) processFileBrowse(gtx layout.Context, path string) {
@@ -339,6 +347,30 @@ func (f *FileControl) processFileBrowse(gtx layout.Context, path string) {
 	}()
 }
 
+func (f *FileControl) processFolderExtract(gtx layout.Context, path string) {
+	if !f.extractButton.Clicked(gtx) || f.Folder == nil {
+		return
+	}
+	go func() {
+		dir, err := ChooseFolder()
+		if errors.Is(err, errNoFolderPicker) {
+			// no folder picker, extract next to the archive
+			dir, err = filepath.Dir(path), nil
+		}
+		if err != nil {
+			log.Printf("Choose folder failed: %v", err)
+			return
+		}
+		if err = ExtractArchive(path, dir); err != nil {
+			log.Printf("Extract %s failed: %v", path, err)
+			HintRequest <- "❌解压失败"
+			return
+		}
+		HintRequest <- "✅完成"
+		_ = OpenInFinder(filepath.Join(dir, strings.TrimSuffix(f.Filename, ".tar")))
+	}()
+}
+
 func (f *FileControl) processPhotoSave(gtx layout.Context, path string) {
 	if !f.saveButton.Clicked(gtx) || path == "" {
 		return
@@ -381,11 +413,24 @@ func (f *FileControl) processFileSave(gtx layout.Context, path string) {
 }
 
 func (f *FileControl) updateProgress(p int) {
-	f.progress = p
+	f.updateState(func(f *FileControl) { f.progress = p })
 }
 
 func (f *FileControl) updateSpeed(s int) {
-	f.speed = s
+	f.updateState(func(f *FileControl) { f.speed = s })
+}
+
+// updateState changes the transfer state of f under fileState.
+func (f *FileControl) updateState(update func(f *FileControl)) {
+	fileState.Lock()
+	defer fileState.Unlock()
+	update(f)
+}
+
+func (f *FileControl) percent() int {
+	fileState.RLock()
+	defer fileState.RUnlock()
+	return f.progress
 }
 
 func (f *FileControl) loadGif(filepath string) *Gif {
@@ -440,10 +485,13 @@ func (f *FileControl) drawSpeed(theme *material.Theme) func(gtx layout.Context)
 }
 
 func (f *FileControl) getSpeed() string {
-	if f.speed == 0 {
+	fileState.RLock()
+	speed := f.speed
+	fileState.RUnlock()
+	if speed == 0 {
 		return "-"
 	}
-	return f.toHumanReadable(float32(f.speed)) + "/s"
+	return f.toHumanReadable(float32(speed)) + "/s"
 }
 
 func (f *FileControl) drawIcon(isPrimary bool) func(gtx layout.Context) layout.Dimensions {
@@ -546,6 +594,11 @@ func (f *FileControl) getProgress() string {
 	if f.Path != "" {
 		return "-"
 	}
+	fileState.RLock()
+	defer fileState.RUnlock()
+	if f.corrupt {
+		return "文件损坏"
+	}
 	if f.progress == 0 {
 		return "未下载"
 	}
@@ -554,7 +607,11 @@ func (f *FileControl) getProgress() string {
 
 func (f *FileControl) drawSize(theme *material.Theme) func(gtx layout.Context) layout.Dimensions {
 	return func(gtx layout.Context) layout.Dimensions {
-		label := material.Label(theme, theme.TextSize, f.toHumanReadable(float32(f.Size)))
+		size := f.toHumanReadable(float32(f.Size))
+		if f.Folder != nil {
+			size = fmt.Sprintf("%d files · %s", f.Folder.Files, f.toHumanReadable(float32(f.Folder.Size)))
+		}
+		label := material.Label(theme, theme.TextSize, size)
 		label.Color = theme.ContrastFg
 		gtx.Constraints.Min.X = 0
 		margins := layout.Inset{Top: unit.Dp(4), Bottom: unit.Dp(4)}
@@ -585,6 +642,8 @@ func (f *FileControl) getIconByMimeType() *widget.Icon {
 		return icons.MusicIcon
 	case Video:
 		return icons.VideoIcon
+	case Archive:
+		return icons.FilesIcon
 	default:
 		return icons.UnknownIcon
 	}
@@ -603,6 +662,8 @@ func (f *FileControl) getIconVGData() []byte {
 		return mdicons.ImageImage
 	case Ebook:
 		return mdicons.ActionBook
+	case Archive:
+		return mdicons.FileFolder
 	default:
 		return mdicons.FileAttachment
 	}
@@ -737,6 +798,10 @@ func renderGlitchIcon(iconVGData []byte, baseColor color.NRGBA, iconSize int) *i
 }
 
 func (f *FileControl) toHumanReadable(v float32) string {
+	return toHumanReadable(v)
+}
+
+func toHumanReadable(v float32) string {
 	suffix := "B"
 	if v < 1024 {
 		suffix = "B"
@@ -754,9 +819,10 @@ type MediaControl struct {
 	audio.StreamConfig `json:"-"`
 	Duration           uint32
 	playButton         widget.Clickable
-	animation          *component.Progress
 	pauseButton        widget.Clickable
-	cancel             context.CancelFunc
+	speedButton        widget.Clickable
+	playback           *voicePlayback
+	seek               seekState
 	waveform           []float32   // Audio waveform amplitudes
 	list               layout.List // Scrollable list for waveform
 }
@@ -771,8 +837,13 @@ func (m *MediaControl) Layout(gtx layout.Context, filePath string, isPrimary boo
 			return m.drawController(gtx, filePath, isPrimary)
 		}),
 		layout.Flexed(0.95, func(gtx layout.Context) layout.Dimensions {
+			m.processSeek(gtx, filePath)
 			// Draw waveform visualization with timestamp overlay
-			return m.drawWaveform(gtx, m.animation.Progress(), isPrimary)
+			d := m.drawWaveform(gtx, m.playedFraction(filePath), isPrimary)
+			defer clip.Rect{Max: d.Size}.Push(gtx.Ops).Pop()
+			pointer.CursorPointer.Add(gtx.Ops)
+			event.Op(gtx.Ops, &m.seek)
+			return d
 		}),
 	)
 }
@@ -780,32 +851,28 @@ func (m *MediaControl) Layout(gtx layout.Context, filePath string, isPrimary boo
 func (m *MediaControl) drawController(gtx layout.Context, filePath string, isPrimary bool) layout.Dimensions {
 	btn := &m.playButton
 	icon := icons.AVPlayArrow
-	if m.animation == nil {
-		m.animation = &component.Progress{}
-	}
 	if m.playButton.Clicked(gtx) {
-		m.animation.Start(gtx.Now, component.Forward, time.Duration(m.Duration)*time.Millisecond)
-		m.playAudioAsync(filePath)
+		m.play(filePath)
 	}
 	if m.pauseButton.Clicked(gtx) {
-		m.animation.Stop()
-		if m.cancel != nil {
-			m.cancel()
-		}
+		m.playback.stop()
+	}
+	if m.speedButton.Clicked(gtx) {
+		m.nextSpeed()
 	}
-	if m.animation.Started() {
+	playing := m.playback.active()
+	if playing {
 		gtx.Execute(op.InvalidateCmd{})
 		btn = &m.pauseButton
 		icon = icons.AVPause
 		// Auto-scroll waveform to follow playback progress
-		progress := m.animation.Progress()
+		progress := m.playedFraction(filePath)
 		// Scroll to show current position
-		if len(m.waveform) > 0 {
+		if len(m.waveform) > 0 && !m.seek.active {
 			scrollOffset := int(float32(len(m.waveform)) * progress)
 			m.list.ScrollTo(scrollOffset)
 		}
 	}
-	m.animation.Update(gtx.Now)
 
 	// Draw button with integrated time display
 	// Stack icon and time vertically
@@ -829,7 +896,7 @@ func (m *MediaControl) drawController(gtx layout.Context, filePath string, isPri
 		}),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 			// Format time as MM:SS
-			currentSec := int(math.Ceil(m.getLeftDuration().Seconds()))
+			currentSec := int(math.Ceil(m.getLeftDuration(filePath).Seconds()))
 			currentMin := currentSec / 60
 			currentSec = currentSec % 60
 
@@ -840,15 +907,29 @@ func (m *MediaControl) drawController(gtx layout.Context, filePath string, isPri
 			timeLabel.Color = fonts.DimWhite
 			return layout.Inset{Top: unit.Dp(2)}.Layout(gtx, timeLabel.Layout)
 		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if !playing {
+				return layout.Dimensions{}
+			}
+			speed := strconv.FormatFloat(Prefs.Get().VoiceSpeed, 'f', -1, 64) + "×"
+			return m.speedButton.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				speedLabel := material.Label(fonts.DefaultTheme, unit.Sp(10), speed)
+				speedLabel.Color = fonts.BrightCyan
+				if !isPrimary {
+					speedLabel.Color = fonts.BrightPurple
+				}
+				return layout.Inset{Top: unit.Dp(2), Left: unit.Dp(4), Right: unit.Dp(4)}.Layout(gtx, speedLabel.Layout)
+			})
+		}),
 	)
 }
 
-func (m *MediaControl) getLeftDuration() time.Duration {
-	size := time.Duration(m.Duration) * time.Millisecond
-	if !m.animation.Started() {
-		return size
+func (m *MediaControl) getLeftDuration(filePath string) time.Duration {
+	left := m.total() - m.position(filePath)
+	if m.seek.active {
+		left = time.Duration(float32(m.total()) * (1 - m.seek.fraction))
 	}
-	return time.Duration(float32(size) * (1 - m.animation.Progress()))
+	return max(left, 0)
 }
 
 // generateWaveform extracts amplitude data from audio file
@@ -856,31 +937,30 @@ func (m *MediaControl) generateWaveform(filePath string) {
 	if len(m.waveform) > 0 {
 		return // Already generated
 	}
+	if m.waveform = loadPeaks(filePath); len(m.waveform) > 0 {
+		return
+	}
 
-	pcmBytes, _, err := m.readAudio(filePath)
+	src, closer, err := openVoice(filePath)
 	if err != nil {
 		log.Printf("read audio failed, %v", err)
 		return
 	}
+	defer closer.Close()
 
-	pcmInts := ogg.ToInts(pcmBytes)
 	// Generate waveform by sampling PCM data
 	const barCount = 100 // Number of bars in waveform
-	samplesPerBar := len(pcmInts) / barCount
+	rmsValues, err := audio.Levels(src, barCount)
+	if err != nil {
+		log.Printf("read audio failed, %v", err)
+		return
+	}
 	m.waveform = make([]float32, barCount)
 
 	// Find maximum RMS for normalization
 	var maxRMS float32
-	rmsValues := make([]float32, barCount)
-
-	for i := 0; i < barCount; i++ {
-		start := i * samplesPerBar
-		end := start + samplesPerBar
-
-		rmsValues[i] = audio.CalculateRMS(audio.Int16ToFloat32(pcmInts[start:min(end, len(pcmInts))]))
-		if rmsValues[i] > maxRMS {
-			maxRMS = rmsValues[i]
-		}
+	for _, rms := range rmsValues {
+		maxRMS = max(maxRMS, rms)
 	}
 
 	// Normalize waveform with smoothstep interpolation
@@ -898,6 +978,7 @@ func (m *MediaControl) generateWaveform(filePath string) {
 			m.waveform[i] = 0
 		}
 	}
+	savePeaks(filePath, m.waveform)
 }
 
 // drawWaveform renders the audio waveform visualization
@@ -915,8 +996,8 @@ func (m *MediaControl) drawWaveform(gtx layout.Context, progress float32, isPrim
 
 	// Calculate bar width and spacing based on container width
 	// Use fixed bar width to allow horizontal scrolling
-	barWidthDp := unit.Dp(3)   // Fixed 3dp per bar
-	barSpacingDp := unit.Dp(1) // Fixed 1dp spacing
+	barWidthDp := waveBarWidth
+	barSpacingDp := waveBarSpacing
 
 	// Center the waveform vertically
 	availableHeight := int(float32(containerHeight) * maxHeight)
@@ -1006,33 +1087,6 @@ func (m *MediaControl) drawWaveform(gtx layout.Context, progress float32, isPrim
 	})
 }
 
-func (m *MediaControl) playAudioAsync(filePath string) {
-	go func() {
-		pcm, channels, err := m.readAudio(filePath)
-		if err != nil {
-			log.Printf("read audio failed, %v", err)
-			return
-		}
-		m.StreamConfig.Channels = channels
-		reader := bytes.NewReader(pcm)
-		var ctx context.Context
-		ctx, m.cancel = context.WithCancel(context.Background())
-		if err := audio.Playback(ctx, reader, m.StreamConfig); err != nil && !errors.Is(err, io.EOF) {
-			log.Printf("audio playback: %v", err)
-		}
-		m.animation.Stop()
-	}()
-}
-
-func (m *MediaControl) readAudio(filePath string) ([]byte, int, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer file.Close()
-	return ogg.Decode(file)
-}
-
 func (m *Message) Layout(gtx layout.Context) (d layout.Dimensions) {
 	if m.MessageType == Text && m.Text == "" {
 		return d
@@ -1040,6 +1094,12 @@ func (m *Message) Layout(gtx layout.Context) (d layout.Dimensions) {
 	if (m.MessageType == Image || m.MessageType == Voice) && m.fileNotExist() {
 		return d
 	}
+	if m.MessageType == Album && len(m.Album) == 0 {
+		return d
+	}
+	if m.MessageType == Sticker && (m.Sticker == nil || !m.Sticker.Valid()) {
+		return d
+	}
 
 	margins := layout.Inset{Left: unit.Dp(8), Right: unit.Dp(8)}
 	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
@@ -1062,7 +1122,9 @@ func (m *Message) Layout(gtx layout.Context) (d layout.Dimensions) {
 
 func (m *Message) drawAvatar(gtx layout.Context, uuid string) layout.Dimensions {
 	avatar := AvatarCache.LoadOrElseNew(uuid)
-	return avatar.Layout(gtx)
+	d := avatar.Layout(gtx)
+	drawPresenceDot(gtx, d.Size, DefaultPresence.StateOf(uuid))
+	return d
 }
 
 func (m *Message) isMe() bool {
@@ -1134,8 +1196,11 @@ func (m *Message) drawMessage(gtx layout.Context) layout.Dimensions {
 }
 
 func (m *Message) processFileViewAndSave(gtx layout.Context) {
+	if m.progressive() {
+		m.processFileDownload(gtx, m.Sender)
+	}
 	if runtime.GOOS == "ios" && (m.MessageType == Image || m.MessageType == GIF) {
-		m.processFileBrowse(gtx, m.OptimizedFilePath())
+		m.processImageView(gtx)
 		m.processPhotoSave(gtx, m.OptimizedFilePath())
 		return
 	}
@@ -1143,8 +1208,12 @@ func (m *Message) processFileViewAndSave(gtx layout.Context) {
 	case Voice:
 		m.processFileBrowse(gtx, m.FilePath())
 		m.processFileSave(gtx, m.FilePath())
+	case Image, GIF:
+		m.processImageView(gtx)
+		m.processFileSave(gtx, m.OptimizedFilePath())
 	case File:
 		m.processFileDownload(gtx, m.Sender)
+		m.processFolderExtract(gtx, m.FilePath())
 		fallthrough
 	default:
 		m.processFileBrowse(gtx, m.OptimizedFilePath())
@@ -1152,6 +1221,28 @@ func (m *Message) processFileViewAndSave(gtx layout.Context) {
 	}
 }
 
+// processImageView opens the image in the viewer instead of leaving the app.
+func (m *Message) processImageView(gtx layout.Context) {
+	if m.browseButton.Clicked(gtx) {
+		DefaultViewer.Open(m.OptimizedFilePath())
+	}
+}
+
+// viewerPath is the image shown first when the message is tapped, empty if it has none.
+func (m *Message) viewerPath() string {
+	switch m.MessageType {
+	case Image, GIF:
+		if !m.imageBroken {
+			return m.OptimizedFilePath()
+		}
+	case Album:
+		if len(m.Album) > 0 {
+			return m.albumItemPath(m.Album[0])
+		}
+	}
+	return ""
+}
+
 func (m *Message) processLongPressEvents(gtx layout.Context) (releaseFunc func()) {
 	releaseFunc = func() {}
 	for {
@@ -1170,6 +1261,9 @@ func (m *Message) processLongPressEvents(gtx layout.Context) (releaseFunc func()
 				gtx.Execute(op.InvalidateCmd{})
 			}
 		} else if e.Type == Click || e.Type == Press {
+			if path := m.viewerPath(); e.Type == Click && path != "" && !m.longPressed {
+				DefaultViewer.Open(path)
+			}
 			gtx.Execute(op.InvalidateCmd{})
 		}
 	}
@@ -1199,7 +1293,7 @@ func (m *Message) drawStateAndContent(gtx layout.Context) layout.Dimensions {
 }
 
 func (m *Message) operationNeeded() bool {
-	if m.MessageType == File {
+	if m.MessageType == File || m.progressive() {
 		if m.isMe() || m.downloading() {
 			return false
 		}
@@ -1226,6 +1320,9 @@ func (m *Message) OptimizedFilePath() string {
 		}
 		return m.Path
 	}
+	if m.progressive() {
+		return m.bestImagePath()
+	}
 	return m.FilePath()
 }
 
@@ -1236,9 +1333,19 @@ func (m *Message) drawOperation(gtx layout.Context) layout.Dimensions {
 	switch m.MessageType {
 	case Text:
 		return m.drawCopyButton(gtx)
+	case Album:
+		if m.Text != "" {
+			return m.drawCopyButton(gtx)
+		}
 	case Image, Voice, GIF:
+		if m.progressive() && !m.downloaded() {
+			return m.drawCloudDownloadButton(gtx)
+		}
 		return m.drawViewAndSave(gtx)
 	case File:
+		if m.downloaded() && m.Folder != nil {
+			return m.drawViewSaveAndExtract(gtx)
+		}
 		if m.downloaded() {
 			return m.drawViewAndSave(gtx)
 		}
@@ -1267,6 +1374,22 @@ func (m *Message) drawViewAndSave(gtx layout.Context) layout.Dimensions {
 	)
 }
 
+func (m *Message) drawViewSaveAndExtract(gtx layout.Context) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+		layout.Rigid(m.drawViewButton),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(12)}.Layout),
+		layout.Rigid(m.drawSaveButton),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(12)}.Layout),
+		layout.Rigid(m.drawExtractButton),
+	)
+}
+
+func (m *Message) drawExtractButton(gtx layout.Context) layout.Dimensions {
+	return m.extractButton.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return icons.UnarchiveIcon.Layout(gtx, m.ContrastBg)
+	})
+}
+
 func (m *Message) drawViewButton(gtx layout.Context) layout.Dimensions {
 	return m.browseButton.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return icons.BrowseIcon.Layout(gtx, m.ContrastBg)
@@ -1280,6 +1403,12 @@ func (m *Message) drawSaveButton(gtx layout.Context) layout.Dimensions {
 }
 
 func (m *Message) drawContent(gtx layout.Context) layout.Dimensions {
+	if m.MessageType == Album {
+		return m.drawAlbum(gtx)
+	}
+	if m.MessageType == Sticker {
+		return m.drawSticker(gtx)
+	}
 	if m.Text == "" && m.fileNotExist() {
 		log.Printf("text: %v, name: %v, path: %v", m.Text, m.Filename, m.Path)
 		return layout.Dimensions{}
@@ -1306,6 +1435,9 @@ func (m *Message) drawContent(gtx layout.Context) layout.Dimensions {
 		m.drawBorder(gtx, d, call)
 		return d
 	case Image:
+		if m.progressive() {
+			return m.drawProgressiveImage(gtx)
+		}
 		img := m.loadImage(m.OptimizedFilePath())
 		if img == nil {
 			return m.drawBrokenImage(gtx)
@@ -1316,7 +1448,7 @@ func (m *Message) drawContent(gtx layout.Context) layout.Dimensions {
 		return m.drawImage(gtx, *img)
 	case GIF:
 		gifImg := m.loadGif(m.OptimizedFilePath())
-		if gifImg.GIF == nil {
+		if gifImg.Animation == nil {
 			return m.drawBrokenImage(gtx)
 		}
 		return m.drawGif(gtx, gifImg)
@@ -1354,6 +1486,9 @@ func (m *Message) drawVoice(gtx layout.Context) layout.Dimensions {
 	d := m.MediaControl.Layout(gtx, m.FilePath(), m.isPrimary())
 	call := macro.Stop()
 	m.drawBorder(gtx, d, call)
+	if m.unplayed() {
+		drawUnplayedDot(gtx, d.Size)
+	}
 	return d
 }
 
@@ -12,7 +12,10 @@ import (
 
 	"gioui.org/f32"
 	"gioui.org/io/clipboard"
+	"gioui.org/io/event"
+	"gioui.org/io/key"
 	"gioui.org/io/pointer"
+	"gioui.org/io/transfer"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -29,6 +32,11 @@ type MessageEditor struct {
 	EditorOperator
 	ExpandButton
 	widget.Editor
+	Drafts       *DraftStore
+	OnAttach     func(FileDescription)
+	attachTarget struct{}
+	room         string
+	restored     bool
 	submitButton widget.Clickable
 	startTime    time.Time
 	focused      bool
@@ -41,6 +49,7 @@ func (e *MessageEditor) Layout(gtx layout.Context) layout.Dimensions {
 	}
 	//defer clip.Rect(image.Rectangle{Max: gtx.Constraints.Max}).Push(gtx.Ops).Pop()
 	e.InteractiveSpan.Layout(gtx)
+	event.Op(gtx.Ops, &e.attachTarget)
 
 	// Draw rounded background with geek-style gradient border effect at outer layer
 	macro := op.Record(gtx.Ops)
@@ -112,10 +121,12 @@ func (e *MessageEditor) Layout(gtx layout.Context) layout.Dimensions {
 }
 
 func (e *MessageEditor) update(gtx layout.Context) {
+	e.restoreDraft()
 	e.processSubmit(gtx)
 	e.processCut(gtx)
 	e.processCopy(gtx)
 	e.processPaste(gtx)
+	e.processAttach(gtx)
 }
 
 func (e *MessageEditor) operationBarNeeded(gtx layout.Context) bool {
@@ -139,8 +150,33 @@ func (e *MessageEditor) processPaste(gtx layout.Context) {
 			e.Editor.Delete(1)
 		}
 		gtx.Execute(clipboard.ReadCmd{Tag: &e.Editor})
+		gtx.Execute(clipboard.ReadCmd{Tag: &e.attachTarget})
 		e.hideOperationBar()
 	}
+	for {
+		_, ok := gtx.Event(key.Filter{Focus: &e.Editor, Name: "V", Required: key.ModShortcut})
+		if !ok {
+			break
+		}
+		// the editor reads text on its own, only images are asked for here
+		gtx.Execute(clipboard.ReadCmd{Tag: &e.attachTarget})
+	}
+}
+
+// processAttach receives image data pasted from the clipboard.
+func (e *MessageEditor) processAttach(gtx layout.Context) {
+	for {
+		ev, ok := gtx.Event(
+			transfer.TargetFilter{Target: &e.attachTarget, Type: MimePNG},
+			transfer.TargetFilter{Target: &e.attachTarget, Type: MimeJPEG},
+		)
+		if !ok {
+			break
+		}
+		if ev, ok := ev.(transfer.DataEvent); ok && e.OnAttach != nil {
+			ReceiveTransfer(ev, e.OnAttach)
+		}
+	}
 }
 
 func (e *MessageEditor) processCopy(gtx layout.Context) {
@@ -179,6 +215,15 @@ func (e *MessageEditor) Submitted(gtx layout.Context) bool {
 func (e *MessageEditor) submittedByCarriageReturn(gtx layout.Context) (submit bool) {
 	for {
 		ev, ok := e.Editor.Update(gtx)
+		switch ev.(type) {
+		case widget.ChangeEvent:
+			e.saveDraft()
+			if gtx.Focused(&e.Editor) {
+				e.notifyActivity()
+			}
+		case widget.SelectEvent:
+			e.saveDraft()
+		}
 		if _, submit = ev.(widget.SubmitEvent); submit {
 			break
 		}
@@ -189,14 +234,51 @@ func (e *MessageEditor) submittedByCarriageReturn(gtx layout.Context) (submit bo
 	return submit
 }
 
+// restoreDraft loads the draft of current room on start or after the room changed.
+func (e *MessageEditor) restoreDraft() {
+	room := wi.DefaultClient.Sign
+	if e.Drafts == nil || e.restored && e.room == room {
+		return
+	}
+	e.room, e.restored = room, true
+	d, _ := e.Drafts.Load(room)
+	e.Editor.SetText(d.Text)
+	e.Editor.SetCaret(d.CaretStart, d.CaretEnd)
+}
+
+func (e *MessageEditor) saveDraft() {
+	if e.Drafts == nil || !e.restored {
+		return
+	}
+	start, end := e.Editor.Selection()
+	e.Drafts.Save(e.room, Draft{Text: e.Editor.Text(), CaretStart: start, CaretEnd: end})
+}
+
+func (e *MessageEditor) notifyActivity() {
+	if strings.TrimSpace(e.Editor.Text()) == "" {
+		DefaultActivity.Stop()
+	} else {
+		DefaultActivity.Notify(Typing)
+	}
+}
+
 func (e *MessageEditor) processSubmit(gtx layout.Context) {
 	// ---------- Handle input ----------
 	if e.Submitted(gtx) {
 		msg := strings.TrimSpace(e.Editor.Text())
 		e.Editor.SetText("")
+		e.saveDraft()
+		DefaultActivity.Stop()
 		if msg == "" {
 			return
 		}
+		if name, ok := stickerShortcode(msg); ok {
+			// a shortcode alone is sent as its sticker, like a custom emoji
+			if ref, found := Stickers.Lookup(name); found {
+				go SendSticker(ref)
+				return
+			}
+		}
 		go func() {
 			message := NewTextMessage(msg)
 			MessageBox <- message
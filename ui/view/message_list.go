@@ -9,7 +9,6 @@ import (
 	"mushin/assets/icons"
 	"mushin/internal/audio"
 	"os"
-	"path/filepath"
 	"runtime"
 	"slices"
 	"sync"
@@ -17,7 +16,9 @@ import (
 	"time"
 
 	"gioui.org/app"
+	"gioui.org/io/event"
 	"gioui.org/io/key"
+	"gioui.org/io/transfer"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -40,6 +41,7 @@ type MessageManager struct {
 	*VoiceRecorder
 	*MessageEditor
 	*Hint
+	composer   *Composer
 	audioStack *IconStack
 	iconStack  *IconStack
 }
@@ -59,6 +61,7 @@ func (m *MessageManager) Process(window *app.Window, c *wi.Client) {
 	go wi.DefaultClient.Pull()
 	go ConsumeAudioData(m.StreamConfig)
 	go m.MessageKeeper.Loop()
+	go DefaultPresence.Loop()
 	go func() {
 		for {
 			select {
@@ -83,6 +86,23 @@ func (m *MessageManager) Process(window *app.Window, c *wi.Client) {
 				}
 				message = msg
 			case msg := <-c.SignedMessages:
+				ctrl, ok := ParseControl(msg.Payload)
+				Peers.Seen(msg.UUID, ok, time.UnixMilli(msg.CreatedAt))
+				if ok {
+					message = dispatchControl(ControlEvent{ControlMessage: ctrl, UUID: msg.UUID, CreatedAt: time.UnixMilli(msg.CreatedAt)})
+					if message == nil {
+						continue
+					}
+					message.Sign, message.Block = wi.DefaultClient.Sign, msg.Block
+					if message.progressive() {
+						// the original is downloaded on demand like a published file
+						m.MessageKeeper.AppendDownloadable(&FileDescription{
+							ID: message.FileId, Name: message.Filename, Size: int64(message.Size),
+						})
+					}
+					AvatarCache.LoadOrElseNew(msg.UUID).Load()
+					break
+				}
 				AvatarCache.LoadOrElseNew(msg.UUID).Load()
 				message = &Message{
 					State:       Sent,
@@ -118,9 +138,17 @@ func (m *MessageManager) Process(window *app.Window, c *wi.Client) {
 				}
 				switch msg.Code {
 				case wi.OpSendImage:
+					if Previews.Ready(msg.UUID, msg.Filename) {
+						// replaces the placeholder of its photo
+						window.Invalidate()
+						continue
+					}
 					message.MessageType = Image
+					// albums list their images by id
+					message.FileId = msg.FileId
 				case wi.OpSendGif:
 					message.MessageType = GIF
+					message.FileId = msg.FileId
 				case wi.OpSendVoice:
 					mediaControl := MediaControl{StreamConfig: m.StreamConfig, Duration: msg.Duration}
 					mediaControl.Format = malgo.FormatS16
@@ -138,11 +166,20 @@ func (m *MessageManager) Process(window *app.Window, c *wi.Client) {
 					})
 					message.MessageType = File
 					message.FileControl = fileControl
+					Folders.Attach(msg.UUID, &message.FileControl)
 				default:
 					m.handleOp(msg)
 					continue
 				}
 			}
+			if message.MessageType == Album {
+				Albums.Claim(message)
+				m.MessageList.RemoveClaimed()
+			} else if Albums.Claimed(message) {
+				// shown inside its album
+				window.Invalidate()
+				continue
+			}
 			message.AddTo(m.MessageList)
 			message.SendTo(m.MessageKeeper)
 			m.MessageList.ScrollToEnd = true
@@ -167,18 +204,26 @@ func (m *MessageManager) handleOp(req wi.WriteReq) {
 			m.reloadAvatar(req)
 			return
 		}
+		if Downloads.Deliver(req) {
+			// manifest or chunk of a chunked download
+			return
+		}
 		fd := m.findDownloadableFile(req.FileId)
 		if fd != nil {
 			m.MessageKeeper.AppendDownloaded(fd)
+			Transfers.Complete(Download, req.FileId)
+			Downloads.Received(req.FileId)
 			_ = wi.DefaultClient.UnsubscribeFile(req.FileId, req.UUID)
+			return
 		}
+		Stickers.Deliver(req)
 	default:
 	}
 }
 
 func (m *MessageManager) reloadAvatar(req wi.WriteReq) {
 	avatar := AvatarCache.LoadOrElseNew(req.UUID)
-	if filepath.Ext(req.Filename) == ".gif" {
+	if animatedIcon(req.Filename) {
 		avatar.Reload(GIF_IMG)
 	} else {
 		avatar.Reload(IMG)
@@ -194,14 +239,22 @@ func (m *MessageManager) publishContent(msg wi.ReadReq) {
 	}
 	fd := m.findPublishedFile(msg.FileId)
 	if fd != nil {
-		PublishContent(fd)
+		go PublishContent(fd)
+		return
+	}
+	if fd = Stickers.Find(msg.FileId); fd != nil {
+		go PublishContent(fd)
+		return
+	}
+	if !Seeds.Serve(msg.FileId, m.findPublishedFile) {
+		log.Printf("unknown content %d requested", msg.FileId)
 	}
 }
 
-func (m *MessageManager) findPublishedFile(id uint32) *FileDescription {
-	m.MessageKeeper.lock.Lock()
-	defer m.MessageKeeper.lock.Unlock()
-	return m.MessageKeeper.PublishedFiles[id]
+func (k *MessageKeeper) findPublishedFile(id uint32) *FileDescription {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.PublishedFiles[id]
 }
 
 func (m *MessageManager) findDownloadableFile(id uint32) *FileDescription {
@@ -214,6 +267,7 @@ func (m *MessageManager) Layout(gtx layout.Context) {
 	// Draw dark geek-themed background
 	defer clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops).Pop()
 	paint.FillShape(gtx.Ops, m.MessageList.Bg, clip.Rect{Max: gtx.Constraints.Max}.Op())
+	m.processDrop(gtx)
 
 	w := m.MessageEditor.Layout
 	if *m.VoiceMode {
@@ -222,6 +276,7 @@ func (m *MessageManager) Layout(gtx layout.Context) {
 	layout.Flex{Axis: layout.Vertical, Spacing: layout.SpaceBetween}.Layout(gtx,
 		layout.Flexed(1, m.MessageList.Layout),
 		layout.Rigid(layout.Spacer{Height: unit.Dp(7)}.Layout),
+		layout.Rigid(DefaultComposing.Layout),
 		layout.Rigid(w),
 	)
 	m.Hint.Layout(gtx)
@@ -230,6 +285,24 @@ func (m *MessageManager) Layout(gtx layout.Context) {
 	m.iconStack.Layout(gtx)
 }
 
+// processDrop accepts files and images dropped onto the window.
+func (m *MessageManager) processDrop(gtx layout.Context) {
+	for {
+		ev, ok := gtx.Event(
+			transfer.TargetFilter{Target: m.composer, Type: MimeURIList},
+			transfer.TargetFilter{Target: m.composer, Type: MimePNG},
+			transfer.TargetFilter{Target: m.composer, Type: MimeJPEG},
+		)
+		if !ok {
+			break
+		}
+		if ev, ok := ev.(transfer.DataEvent); ok {
+			ReceiveTransfer(ev, m.composer.Add)
+		}
+	}
+	event.Op(gtx.Ops, m.composer)
+}
+
 func NewMessageManager(streamConfig audio.StreamConfig) MessageManager {
 	mode := new(VoiceMode)
 	voiceRecorder := &VoiceRecorder{StreamConfig: streamConfig}
@@ -241,11 +314,28 @@ func NewMessageManager(streamConfig audio.StreamConfig) MessageManager {
 		Theme: fonts.DefaultTheme,
 	}
 	messageList.Messages.Store(new(messageKeeper.Messages(streamConfig)))
+	DefaultViewer.Source = messageList
 	submit := runtime.GOOS != "ios" && runtime.GOOS != "android"
-	messageEditor := &MessageEditor{Editor: widget.Editor{Submit: submit, LineHeight: fonts.DefaultLineHeight}, Theme: fonts.DefaultTheme}
+	Prefs = LoadPreferences("preferences.json")
+	Stickers = LoadStickers(GetConfig("stickers"))
+	Playbacks = NewPlaybackStore("playback.json")
+	applyAudioDevices(Prefs.Get())
+	Enhancement = LoadEnhancerProfile("enhancer.json")
+	Enhancement.Apply()
+	go voiceRecorder.recoverTakes()
+	Transfers.Apply(Prefs.Get())
+	Downloads.OnComplete(messageKeeper.AppendDownloaded)
+	composer := NewComposer(messageKeeper.AppendPublish)
+	messageEditor := &MessageEditor{
+		Editor:   widget.Editor{Submit: submit, LineHeight: fonts.DefaultLineHeight},
+		Theme:    fonts.DefaultTheme,
+		Drafts:   NewDraftStore("drafts.json"),
+		OnAttach: composer.Add,
+	}
 	return MessageManager{
+		composer:      composer,
 		audioStack:    NewAudioIconStack(streamConfig),
-		iconStack:     NewIconStack(mode.SwitchBetweenTextAndVoice, messageKeeper.AppendPublish),
+		iconStack:     NewIconStack(mode.SwitchBetweenTextAndVoice, composer, streamConfig),
 		VoiceMode:     mode,
 		Hint:          &Hint{MSG: "✅完成", Progress: &component.Progress{}},
 		VoiceRecorder: voiceRecorder,
@@ -266,6 +356,7 @@ func (l *MessageList) Layout(gtx layout.Context) layout.Dimensions {
 	l.getFocusAndResetIconStackIfClicked(gtx)
 	// We visualize the text using a list where each paragraph is a separate item.
 	messages := *l.Messages.Load()
+	l.playNext(gtx, messages)
 	dimensions := l.Clickable.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return l.List.Layout(gtx, len(messages), func(gtx layout.Context, index int) layout.Dimensions {
 			return messages[index].Layout(gtx)
@@ -275,6 +366,32 @@ func (l *MessageList) Layout(gtx layout.Context) layout.Dimensions {
 	return dimensions
 }
 
+// playNext continues with the next unplayed voice message once one was
+// played to the end, scrolling it into view.
+func (l *MessageList) playNext(gtx layout.Context, messages []*Message) {
+	i, ok := autoPlay.next(messages)
+	if !ok {
+		return
+	}
+	if i < l.Position.First || i >= l.Position.First+l.Position.Count {
+		l.List.ScrollTo(i)
+	}
+	next := messages[i]
+	next.MediaControl.play(next.FilePath())
+	gtx.Execute(op.InvalidateCmd{})
+}
+
+// RemoveClaimed drops standalone images that arrived before their album.
+func (l *MessageList) RemoveClaimed() {
+	messages := *l.Messages.Load()
+	if !slices.ContainsFunc(messages, Albums.Claimed) {
+		return
+	}
+	ret := slices.DeleteFunc(slices.Clone(messages), Albums.Claimed)
+	adjustPrimaryForAll(ret)
+	l.Messages.Store(&ret)
+}
+
 func (l *MessageList) scrollToEndIfFirstAndLastItemVisible() {
 	// at end of list
 	if !l.Position.BeforeEnd {
@@ -345,6 +462,8 @@ func (k *MessageKeeper) AppendPublish(fd *FileDescription) {
 	defer k.lock.Unlock()
 	k.PublishedFiles[fd.ID] = fd
 	k.append("file.log", fd)
+	Seeds.Register(fd.ID)
+	go Seeds.Prepare(fd, k.findPublishedFile)
 }
 
 func (k *MessageKeeper) AppendDownloaded(fd *FileDescription) {
@@ -424,6 +543,10 @@ func (k *MessageKeeper) Messages(streamConfig audio.StreamConfig) []*Message {
 	k.PublishedFiles = k.ReadPublishedFiles()
 	k.DownloadedFiles = k.ReadDownloadedFiles()
 	k.DownloadableFiles = k.ReadDownloadableFiles()
+	for id := range k.PublishedFiles {
+		Seeds.Register(id)
+	}
+	Downloads.Load()
 	filePath := GetDataPath("message.log")
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -448,15 +571,25 @@ func (k *MessageKeeper) Messages(streamConfig audio.StreamConfig) []*Message {
 			msg.StreamConfig = streamConfig
 		}
 		if k.DownloadedFiles[msg.FileId] != nil {
-			msg.progress = 100
+			msg.updateProgress(100)
+		} else if msg.MessageType == File && !msg.isMe() || msg.progressive() {
+			Downloads.Track(&msg.FileControl, msg.Sender)
+		}
+		if msg.progressive() && !msg.previewAvailable() {
+			msg.expectPreview()
 		}
 		if !msg.isMe() {
 			wi.DefaultClient.Track(&wi.SignBody{Sign: msg.Sign, UUID: msg.Sender}, msg.Block)
 		} else {
 			wi.DefaultClient.MultiTrack(&wi.SignBody{Sign: msg.Sign, UUID: msg.Sender}, wi.FullRange)
 		}
+		if msg.MessageType == Album {
+			Albums.Claim(&msg)
+			msg.expectAlbumPreviews()
+		}
 		ret = append(ret, &msg)
 	}
+	ret = slices.DeleteFunc(ret, Albums.Claimed)
 	slices.SortFunc(ret, func(i, j *Message) int {
 		switch {
 		case i.CreatedAt.Before(j.CreatedAt):
@@ -0,0 +1,461 @@
+package view
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"mushin/internal/audio"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+)
+
+// playbackDebounce delays writes so that a drag across the waveform hits the disk once.
+const playbackDebounce = 800 * time.Millisecond
+
+// voiceSpeeds are the playback speeds the speed button cycles through.
+var voiceSpeeds = []float64{1, 1.5, 2}
+
+const (
+	waveBarWidth   = unit.Dp(3)
+	waveBarSpacing = unit.Dp(1)
+)
+
+// playbackIndex is the persisted state of voice messages, by file path.
+type playbackIndex struct {
+	// Positions are where messages were paused, in milliseconds
+	Positions map[string]int64 `json:"positions"`
+	Played    map[string]bool  `json:"played"`
+	// Since is when played messages were first tracked, older ones count as played
+	Since time.Time `json:"since"`
+}
+
+// PlaybackStore remembers which voice messages were played and where they
+// were paused, and persists it as json in the data dir. It is safe for concurrent use.
+type PlaybackStore struct {
+	filename string
+	index    playbackIndex
+	timer    *time.Timer
+	dirty    bool
+	lock     sync.Mutex
+}
+
+func newPlaybackStore(filename string) *PlaybackStore {
+	return &PlaybackStore{filename: filename, index: playbackIndex{Positions: make(map[string]int64), Played: make(map[string]bool)}}
+}
+
+// NewPlaybackStore loads the state stored in filename under the data dir.
+func NewPlaybackStore(filename string) *PlaybackStore {
+	s := newPlaybackStore(filename)
+	data, err := os.ReadFile(GetDataPath(filename))
+	if err == nil {
+		if err = json.Unmarshal(data, &s.index); err != nil {
+			log.Printf("Unmarshall playback state failed: %v", err)
+		}
+	}
+	if s.index.Positions == nil {
+		s.index.Positions = make(map[string]int64)
+	}
+	if s.index.Played == nil {
+		s.index.Played = make(map[string]bool)
+	}
+	if s.index.Since.IsZero() {
+		s.index.Since = time.Now()
+		s.save()
+	}
+	return s
+}
+
+// Since is when the store was first loaded, voice messages received before
+// that were listened to by a build that didn't track it.
+func (s *PlaybackStore) Since() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.index.Since
+}
+
+// Position returns where the voice message at path was left off.
+func (s *PlaybackStore) Position(path string) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return time.Duration(s.index.Positions[path]) * time.Millisecond
+}
+
+// Played reports whether the voice message at path was ever played.
+func (s *PlaybackStore) Played(path string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.index.Played[path]
+}
+
+// SetPlayed marks the voice message at path as played.
+func (s *PlaybackStore) SetPlayed(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.index.Played[path] {
+		return
+	}
+	s.index.Played[path] = true
+	s.save()
+}
+
+// SetPosition remembers d for path, 0 forgets it.
+// The change is written to disk after playbackDebounce.
+func (s *PlaybackStore) SetPosition(path string, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ms := d.Milliseconds()
+	if s.index.Positions[path] == ms {
+		return
+	}
+	if ms <= 0 {
+		delete(s.index.Positions, path)
+	} else {
+		s.index.Positions[path] = ms
+	}
+	s.save()
+}
+
+// save schedules a write, the lock is held.
+func (s *PlaybackStore) save() {
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(playbackDebounce, s.Flush)
+	} else {
+		s.timer.Reset(playbackDebounce)
+	}
+}
+
+// Flush writes pending changes immediately.
+func (s *PlaybackStore) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dirty {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	data, err := json.Marshal(s.index)
+	if err != nil {
+		log.Printf("Marshall playback state failed: %v", err)
+		return
+	}
+	if err = writeFileAtomic(GetDataPath(s.filename), data); err != nil {
+		log.Printf("Write playback state failed: %v", err)
+		return
+	}
+	s.dirty = false
+}
+
+var Playbacks = newPlaybackStore("playback.json")
+
+// voicePlayback is a running playback of a voice message, started from the
+// UI and driven by the sound device in the background.
+type voicePlayback struct {
+	player  *audio.Player
+	cancel  context.CancelFunc
+	running bool
+	lock    sync.Mutex
+}
+
+func (v *voicePlayback) begin(cancel context.CancelFunc) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.running {
+		return false
+	}
+	v.running, v.cancel = true, cancel
+	return true
+}
+
+func (v *voicePlayback) attach(p *audio.Player) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.player = p
+}
+
+func (v *voicePlayback) end() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.running, v.player, v.cancel = false, nil, nil
+}
+
+func (v *voicePlayback) stop() {
+	if v == nil {
+		return
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.cancel != nil {
+		v.cancel()
+	}
+}
+
+// current is the player once the audio is decoded, nil when stopped.
+func (v *voicePlayback) current() *audio.Player {
+	if v == nil {
+		return nil
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.player
+}
+
+func (v *voicePlayback) active() bool {
+	if v == nil {
+		return false
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.running
+}
+
+// seekState is the waveform drag in progress, fraction is where it points.
+type seekState struct {
+	active   bool
+	fraction float32
+}
+
+// openVoice opens the voice message at path for streaming decode.
+func openVoice(path string) (audio.Source, io.Closer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := audio.NewOpusReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return src, file, nil
+}
+
+// peaksPath is where the waveform of the voice message at path is cached.
+func peaksPath(path string) string {
+	return path + ".peaks"
+}
+
+// loadPeaks returns the cached waveform of the voice message at path, if any.
+func loadPeaks(path string) []float32 {
+	data, err := os.ReadFile(peaksPath(path))
+	if err != nil {
+		return nil
+	}
+	peaks := make([]float32, len(data))
+	for i, v := range data {
+		peaks[i] = float32(v) / 255
+	}
+	return peaks
+}
+
+// savePeaks caches the waveform next to the voice message, one byte per bar.
+// It is written atomically, loadPeaks takes any file it finds as complete.
+func savePeaks(path string, peaks []float32) {
+	data := make([]byte, len(peaks))
+	for i, v := range peaks {
+		data[i] = byte(max(0, min(v, 1))*255 + 0.5)
+	}
+	if err := writeFileAtomic(peaksPath(path), data); err != nil {
+		log.Printf("Write waveform failed: %v", err)
+	}
+}
+
+// play streams the voice message from where it was left off.
+func (m *MediaControl) play(filePath string) {
+	if m.playback == nil {
+		m.playback = &voicePlayback{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if !m.playback.begin(cancel) {
+		cancel()
+		return
+	}
+	playback, config := m.playback, m.StreamConfig
+	start := Playbacks.Position(filePath)
+	Playbacks.SetPlayed(filePath)
+	go func() {
+		defer invalidate()
+		defer playback.end()
+		src, closer, err := openVoice(filePath)
+		if err != nil {
+			log.Printf("read audio failed, %v", err)
+			return
+		}
+		defer closer.Close()
+		config.Channels, config.SampleRate = src.Channels(), src.SampleRate()
+		player := audio.NewPlayer(src)
+		player.SetSpeed(Prefs.Get().VoiceSpeed)
+		if start < player.Duration() {
+			player.Seek(start)
+		}
+		playback.attach(player)
+		err = audio.Playback(ctx, player, config)
+		if errors.Is(err, io.EOF) {
+			// played to the end, start over next time and go on with the next message
+			Playbacks.SetPosition(filePath, 0)
+			autoPlay.finish(filePath)
+			return
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("audio playback: %v", err)
+		}
+		Playbacks.SetPosition(filePath, player.Position())
+	}()
+}
+
+// nextSpeed switches to the next playback speed, the playing message follows.
+func (m *MediaControl) nextSpeed() {
+	speed := Prefs.Get().VoiceSpeed
+	i := slices.Index(voiceSpeeds, speed)
+	speed = voiceSpeeds[(i+1)%len(voiceSpeeds)]
+	Prefs.Update(func(p *Preferences) { p.VoiceSpeed = speed })
+	if p := m.playback.current(); p != nil {
+		p.SetSpeed(speed)
+	}
+}
+
+func (m *MediaControl) total() time.Duration {
+	if p := m.playback.current(); p != nil {
+		return p.Duration()
+	}
+	return time.Duration(m.Duration) * time.Millisecond
+}
+
+func (m *MediaControl) position(filePath string) time.Duration {
+	if p := m.playback.current(); p != nil {
+		return p.Position()
+	}
+	return Playbacks.Position(filePath)
+}
+
+// playedFraction is the played part of the message, or where the waveform is dragged to.
+func (m *MediaControl) playedFraction(filePath string) float32 {
+	if m.seek.active {
+		return m.seek.fraction
+	}
+	total := m.total()
+	if total <= 0 {
+		return 0
+	}
+	return min(float32(m.position(filePath))/float32(total), 1)
+}
+
+// processSeek moves playback to where the waveform is tapped or dragged to.
+func (m *MediaControl) processSeek(gtx layout.Context, filePath string) {
+	for {
+		ev, ok := gtx.Event(pointer.Filter{
+			Target: &m.seek,
+			Kinds:  pointer.Press | pointer.Drag | pointer.Release | pointer.Cancel,
+		})
+		if !ok {
+			break
+		}
+		e, ok := ev.(pointer.Event)
+		if !ok {
+			continue
+		}
+		switch e.Kind {
+		case pointer.Press:
+			// keep the list from scrolling while seeking
+			gtx.Execute(pointer.GrabCmd{Tag: &m.seek, ID: e.PointerID})
+			m.seek = seekState{active: true, fraction: m.seekFraction(gtx, e.Position.X)}
+		case pointer.Drag:
+			m.seek.fraction = m.seekFraction(gtx, e.Position.X)
+		case pointer.Release:
+			m.seekTo(filePath, m.seekFraction(gtx, e.Position.X))
+			m.seek.active = false
+		case pointer.Cancel:
+			m.seek.active = false
+		}
+		gtx.Execute(op.InvalidateCmd{})
+	}
+}
+
+// seekFraction maps x on the scrolled waveform to a fraction of the message.
+func (m *MediaControl) seekFraction(gtx layout.Context, x float32) float32 {
+	bar := gtx.Dp(waveBarWidth + waveBarSpacing)
+	width := float32(len(m.waveform) * bar)
+	if width == 0 {
+		return 0
+	}
+	at := float32(m.list.Position.First*bar+m.list.Position.Offset) + x
+	return max(0, min(at/width, 1))
+}
+
+func (m *MediaControl) seekTo(filePath string, fraction float32) {
+	d := time.Duration(float32(m.total()) * fraction)
+	if p := m.playback.current(); p != nil {
+		p.Seek(d)
+		return
+	}
+	if fraction >= 1 {
+		d = 0
+	}
+	Playbacks.SetPosition(filePath, d)
+}
+
+// voiceQueue hands the voice message that was played to the end over to the
+// message list, which continues with the next unplayed one on the UI goroutine.
+type voiceQueue struct {
+	finished atomic.Pointer[string]
+}
+
+var autoPlay voiceQueue
+
+func (q *voiceQueue) finish(path string) {
+	q.finished.Store(&path)
+	invalidate()
+}
+
+// next returns the index of the voice message to play after the one that
+// finished, if any. Only the voice messages right after it are considered,
+// played and own ones are skipped.
+func (q *voiceQueue) next(messages []*Message) (int, bool) {
+	path := q.finished.Swap(nil)
+	if path == nil {
+		return 0, false
+	}
+	i := slices.IndexFunc(messages, func(m *Message) bool {
+		return m.MessageType == Voice && m.FilePath() == *path
+	})
+	if i < 0 {
+		return 0, false
+	}
+	for j := i + 1; j < len(messages) && messages[j].MessageType == Voice; j++ {
+		if messages[j].unplayed() {
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// unplayed reports whether m is a received voice message nobody played yet.
+func (m *Message) unplayed() bool {
+	if m.MessageType != Voice || m.isMe() || m.fileNotExist() || m.CreatedAt.Before(Playbacks.Since()) {
+		return false
+	}
+	return !Playbacks.Played(m.FilePath())
+}
+
+var unplayedColor = color.NRGBA{R: 255, G: 82, B: 82, A: 255}
+
+// drawUnplayedDot marks an unplayed voice message at the top right corner of its bubble.
+func drawUnplayedDot(gtx layout.Context, size image.Point) {
+	d, inset := gtx.Dp(7), gtx.Dp(10)
+	rect := image.Rect(size.X-inset-d, inset, size.X-inset, inset+d)
+	paint.FillShape(gtx.Ops, unplayedColor, clip.Ellipse(rect).Op(gtx.Ops))
+}
@@ -0,0 +1,74 @@
+package view
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CoyAce/wi"
+)
+
+func TestPlaybackStore(t *testing.T) {
+	wi.DefaultClient = &wi.Client{Identity: wi.Identity{UUID: "#00001"}}
+	wi.Mkdir(GetDir(wi.DefaultClient.ID()))
+	wi.RemoveFile(GetDataPath("playback_test.json"))
+	s := NewPlaybackStore("playback_test.json")
+	s.SetPosition("a.ogg", 1500*time.Millisecond)
+	s.SetPlayed("a.ogg")
+	s.SetPosition("b.ogg", time.Second)
+	s.SetPosition("b.ogg", 0)
+	s.Flush()
+
+	s = NewPlaybackStore("playback_test.json")
+	if pos := s.Position("a.ogg"); pos != 1500*time.Millisecond {
+		t.Errorf("position should be 1.5s, but %v", pos)
+	}
+	if !s.Played("a.ogg") || s.Played("b.ogg") {
+		t.Errorf("only a.ogg should be played")
+	}
+	if pos := s.Position("b.ogg"); pos != 0 {
+		t.Errorf("position reset to 0 should be forgotten, but %v", pos)
+	}
+}
+
+func TestVoiceQueueNext(t *testing.T) {
+	wi.DefaultClient = &wi.Client{Identity: wi.Identity{UUID: "#00001"}}
+	Playbacks = newPlaybackStore("playback_test.json")
+	voice := func(sender, filename string) *Message {
+		return &Message{MessageType: Voice, FileControl: FileControl{Filename: filename}, Contacts: Contacts{Sender: sender, UUID: "#00001"}}
+	}
+	messages := []*Message{
+		voice("alice#00002", "1.ogg"),
+		{TextControl: NewTextControl("hello"), Contacts: Contacts{Sender: "alice#00002", UUID: "#00001"}},
+		voice("#00001", "2.ogg"),
+		voice("alice#00002", "3.ogg"),
+		voice("alice#00002", "4.ogg"),
+	}
+	Playbacks.SetPlayed(messages[3].FilePath())
+
+	var q voiceQueue
+	if _, ok := q.next(messages); ok {
+		t.Fatalf("nothing should play before a message finished")
+	}
+	q.finish(messages[0].FilePath())
+	if _, ok := q.next(messages); ok {
+		t.Errorf("a message followed by text should not continue")
+	}
+	q.finish(messages[2].FilePath())
+	if i, ok := q.next(messages); !ok || i != 4 {
+		t.Errorf("own and played messages should be skipped to 4, but %d %v", i, ok)
+	}
+	if _, ok := q.next(messages); ok {
+		t.Errorf("a finished message should continue once")
+	}
+	q.finish(messages[4].FilePath())
+	if _, ok := q.next(messages); ok {
+		t.Errorf("the last message should not continue")
+	}
+	if !messages[4].unplayed() || messages[2].unplayed() {
+		t.Errorf("only received messages should be unplayed")
+	}
+	Playbacks.index.Since = time.Now()
+	if messages[4].unplayed() {
+		t.Errorf("messages received before playback was tracked should count as played")
+	}
+}
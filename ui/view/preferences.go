@@ -0,0 +1,86 @@
+package view
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Preferences are local settings that are not part of the wi client config.
+type Preferences struct {
+	// MaxTransfers limits concurrent uploads and, separately, concurrent downloads.
+	MaxTransfers int `json:"maxTransfers"`
+	// UploadLimit caps upload throughput in bytes per second, 0 means unlimited.
+	// Downloads are paced by the sender and are not limited.
+	UploadLimit int64 `json:"bandwidthLimit"`
+	// StripMetadata removes location and device info from photos before they are sent.
+	StripMetadata bool `json:"stripMetadata"`
+	// VoiceSpeed is the tempo voice messages are played at, one of voiceSpeeds.
+	VoiceSpeed float64 `json:"voiceSpeed"`
+	// MaxVoiceSeconds stops a voice recording after this long, one of maxVoiceSeconds.
+	MaxVoiceSeconds int `json:"maxVoiceSeconds"`
+	// CaptureDevice and PlaybackDevice are device names, empty for the system default.
+	CaptureDevice  string `json:"captureDevice"`
+	PlaybackDevice string `json:"playbackDevice"`
+	// CaptureDeviceID and PlaybackDeviceID identify the same devices, the
+	// names are matched when the IDs changed.
+	CaptureDeviceID  string `json:"captureDeviceId,omitempty"`
+	PlaybackDeviceID string `json:"playbackDeviceId,omitempty"`
+}
+
+func defaultPreferences() Preferences {
+	return Preferences{MaxTransfers: 3, StripMetadata: true, VoiceSpeed: 1, MaxVoiceSeconds: 60}
+}
+
+// PreferenceStore persists Preferences as json in the config dir.
+// It is safe for concurrent use.
+type PreferenceStore struct {
+	filename string
+	prefs    Preferences
+	lock     sync.Mutex
+}
+
+// LoadPreferences reads filename under the config dir, missing values keep their defaults.
+func LoadPreferences(filename string) *PreferenceStore {
+	s := &PreferenceStore{filename: filename, prefs: defaultPreferences()}
+	data, err := os.ReadFile(GetConfig(filename))
+	if err != nil {
+		return s
+	}
+	if err = json.Unmarshal(data, &s.prefs); err != nil {
+		log.Printf("Unmarshall preferences failed: %v", err)
+	}
+	if !slices.Contains(voiceSpeeds, s.prefs.VoiceSpeed) {
+		s.prefs.VoiceSpeed = 1
+	}
+	if !slices.Contains(maxVoiceSeconds, s.prefs.MaxVoiceSeconds) {
+		s.prefs.MaxVoiceSeconds = 60
+	}
+	return s
+}
+
+// Get returns a copy of the current preferences.
+func (s *PreferenceStore) Get() Preferences {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.prefs
+}
+
+// Update applies f under the lock and writes the result to disk.
+func (s *PreferenceStore) Update(f func(p *Preferences)) {
+	s.lock.Lock()
+	f(&s.prefs)
+	data, err := json.Marshal(s.prefs)
+	s.lock.Unlock()
+	if err != nil {
+		log.Printf("Marshall preferences failed: %v", err)
+		return
+	}
+	if err = writeFileAtomic(GetConfig(s.filename), data); err != nil {
+		log.Printf("Write preferences failed: %v", err)
+	}
+}
+
+var Prefs = &PreferenceStore{filename: "preferences.json", prefs: defaultPreferences()}
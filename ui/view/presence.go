@@ -0,0 +1,318 @@
+package view
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"log"
+	"mushin/assets/fonts"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	modal "mushin/ui/layout"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	"github.com/CoyAce/wi"
+)
+
+type PresenceState uint8
+
+const (
+	Offline PresenceState = iota
+	Online
+	Away
+)
+
+func (s PresenceState) String() string {
+	switch s {
+	case Online:
+		return "online"
+	case Away:
+		return "away"
+	default:
+		return "offline"
+	}
+}
+
+func (s PresenceState) Color() color.NRGBA {
+	switch s {
+	case Online:
+		return color.NRGBA{R: 102, G: 187, B: 106, A: 255}
+	case Away:
+		return color.NRGBA{R: 255, G: 183, B: 77, A: 255}
+	default:
+		return color.NRGBA{R: 120, G: 120, B: 120, A: 255}
+	}
+}
+
+const (
+	// heartbeatInterval is how often the local state is re-announced.
+	heartbeatInterval = 20 * time.Second
+	// presenceTTL after which a silent member is considered offline.
+	presenceTTL = 3 * heartbeatInterval
+)
+
+type Member struct {
+	UUID     string
+	State    PresenceState
+	LastSeen time.Time
+}
+
+// Presence tracks the members of the sign room. Announcements and the
+// lifecycle work queued with Do run in order on the Loop goroutine.
+type Presence struct {
+	members map[string]*Member
+	local   PresenceState
+	sign    string
+	queue   []func()
+	wake    chan struct{}
+	lock    sync.Mutex
+}
+
+func NewPresence() *Presence {
+	return &Presence{members: make(map[string]*Member), wake: make(chan struct{}, 1)}
+}
+
+// Update records a presence event, stale events (e.g. replayed by Pull) are ignored.
+func (p *Presence) Update(uuid string, state PresenceState, at time.Time) {
+	if time.Since(at) > presenceTTL {
+		return
+	}
+	p.lock.Lock()
+	p.resetIfSignChanged()
+	m, ok := p.members[uuid]
+	if !ok {
+		m = &Member{UUID: uuid}
+		p.members[uuid] = m
+	}
+	updated := !at.Before(m.LastSeen)
+	if updated {
+		m.State, m.LastSeen = state, at
+	}
+	p.lock.Unlock()
+	if !ok {
+		AvatarCache.LoadOrElseNew(uuid).Load()
+	}
+	if updated {
+		invalidate()
+	}
+}
+
+// StateOf returns the presence of uuid, taking expiry into account.
+func (p *Presence) StateOf(uuid string) PresenceState {
+	if uuid == wi.DefaultClient.ID() {
+		return p.Local()
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	m, ok := p.members[uuid]
+	if !ok || p.expired(m) {
+		return Offline
+	}
+	return m.State
+}
+
+func (p *Presence) expired(m *Member) bool {
+	return time.Since(m.LastSeen) > presenceTTL
+}
+
+// Members returns a snapshot of known members, online first.
+func (p *Presence) Members() []Member {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.resetIfSignChanged()
+	ret := make([]Member, 0, len(p.members)+1)
+	ret = append(ret, Member{UUID: wi.DefaultClient.ID(), State: p.local, LastSeen: time.Now()})
+	for _, m := range p.members {
+		member := *m
+		if p.expired(m) {
+			member.State = Offline
+		}
+		ret = append(ret, member)
+	}
+	rank := map[PresenceState]int{Online: 0, Away: 1, Offline: 2}
+	slices.SortFunc(ret[1:], func(a, b Member) int {
+		if rank[a.State] != rank[b.State] {
+			return rank[a.State] - rank[b.State]
+		}
+		return strings.Compare(a.UUID, b.UUID)
+	})
+	return ret
+}
+
+func (p *Presence) resetIfSignChanged() {
+	if p.sign != wi.DefaultClient.Sign {
+		p.sign = wi.DefaultClient.Sign
+		clear(p.members)
+	}
+}
+
+func (p *Presence) Local() PresenceState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.local
+}
+
+// SetLocal changes the local state, it is announced by the Loop goroutine
+// after the work queued before.
+func (p *Presence) SetLocal(state PresenceState) {
+	p.lock.Lock()
+	p.local = state
+	p.lock.Unlock()
+	p.Do(func() { p.announce(p.Local()) })
+}
+
+// Do queues f to run on the Loop goroutine after the work queued before,
+// so that signing in and out is ordered with the announcements.
+func (p *Presence) Do(f func()) {
+	p.lock.Lock()
+	p.queue = append(p.queue, f)
+	p.lock.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Leave announces the local member offline, it waits at most timeout for the
+// queued work to finish, the app is about to exit.
+func (p *Presence) Leave(timeout time.Duration) {
+	done := make(chan struct{})
+	p.SetLocal(Offline)
+	p.Do(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (p *Presence) announce(state PresenceState) {
+	err := SendControl(ControlMessage{Kind: ControlPresence, Presence: state})
+	if err != nil && !errors.Is(err, errOldPeers) {
+		log.Printf("announce presence failed: %v", err)
+	}
+}
+
+// run does the queued work in order.
+func (p *Presence) run() {
+	for {
+		p.lock.Lock()
+		if len(p.queue) == 0 {
+			p.lock.Unlock()
+			return
+		}
+		f := p.queue[0]
+		p.queue = p.queue[1:]
+		p.lock.Unlock()
+		f()
+	}
+}
+
+// Loop does the queued work, re-announces the local state and refreshes
+// expired members.
+func (p *Presence) Loop() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.wake:
+			p.run()
+		case <-ticker.C:
+			if state := p.Local(); state != Offline {
+				p.announce(state)
+			}
+			invalidate()
+		}
+	}
+}
+
+var DefaultPresence = NewPresence()
+
+// drawPresenceDot draws a status dot at the bottom right corner of an avatar.
+func drawPresenceDot(gtx layout.Context, size image.Point, state PresenceState) {
+	if state == Offline {
+		return
+	}
+	d := size.X / 4
+	border := gtx.Dp(2)
+	rect := image.Rectangle{Min: size.Sub(image.Pt(d+border, d+border)), Max: size}
+	paint.FillShape(gtx.Ops, fonts.DefaultTheme.Bg, clip.Ellipse(rect).Op(gtx.Ops))
+	rect = rect.Inset(border / 2)
+	paint.FillShape(gtx.Ops, state.Color(), clip.Ellipse(rect).Op(gtx.Ops))
+}
+
+type MembersPanel struct {
+	*material.Theme
+	modalContent *modal.ModalContent
+}
+
+func NewMembersPanel() *MembersPanel {
+	p := &MembersPanel{Theme: fonts.DefaultTheme}
+	p.modalContent = modal.NewModalContent(fonts.DefaultTheme, func() {
+		modal.DefaultModal.Dismiss(nil)
+	})
+	p.modalContent.SetTitle("Members")
+	return p
+}
+
+func (p *MembersPanel) Layout(gtx layout.Context) layout.Dimensions {
+	members := DefaultPresence.Members()
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	margins := layout.Inset{Top: unit.Dp(12), Bottom: unit.Dp(24), Left: unit.Dp(16), Right: unit.Dp(16)}
+	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		children := make([]layout.FlexChild, 0, len(members)*2)
+		for _, m := range members {
+			children = append(children,
+				layout.Rigid(p.drawMember(m)),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(10)}.Layout),
+			)
+		}
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	})
+}
+
+func (p *MembersPanel) drawMember(m Member) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				d := AvatarCache.LoadOrElseNew(m.UUID).Layout(gtx)
+				drawPresenceDot(gtx, d.Size, m.State)
+				return d
+			}),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(12)}.Layout),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(p.Theme, p.TextSize*0.85, nicknameOf(m.UUID))
+				label.Font.Weight = font.Bold
+				return label.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(p.Theme, p.TextSize*0.7, m.State.String())
+				label.Color = m.State.Color()
+				label.Font.Style = font.Italic
+				return label.Layout(gtx)
+			}),
+		)
+	}
+}
+
+func (p *MembersPanel) ShowWithModal() {
+	modal.DefaultModal.Show(p.ZoomInWithModalContent, nil, component.VisibilityAnimation{
+		Duration: time.Millisecond * 250,
+		State:    component.Invisible,
+		Started:  time.Time{},
+	})
+}
+
+func (p *MembersPanel) ZoomInWithModalContent(gtx layout.Context) layout.Dimensions {
+	gtx.Constraints.Max.X = int(float32(gtx.Constraints.Max.X) * 0.85)
+	gtx.Constraints.Max.Y = int(float32(gtx.Constraints.Max.Y) * 0.85)
+	return p.modalContent.DrawContent(gtx, p.Layout)
+}
@@ -0,0 +1,349 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"mushin/assets/fonts"
+	"mushin/internal/imaging"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/CoyAce/wi"
+)
+
+const (
+	// previewEdge is the longest edge of the chat-size derivative.
+	previewEdge    = 1280
+	previewQuality = 80
+	// placeholderEdge is the longest edge of the blurred placeholder sent inline.
+	placeholderEdge    = 16
+	placeholderQuality = 50
+	// progressiveMinSize is the original size from which derivatives are worth sending.
+	progressiveMinSize = 256 << 10
+)
+
+// ImageManifest announces a photo: a placeholder inline, the preview file
+// sent right after, and the original published for download on demand.
+type ImageManifest struct {
+	FileId      uint32 `json:"fileId"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Placeholder []byte `json:"placeholder,omitempty"`
+	Preview     string `json:"preview,omitempty"`
+}
+
+// ImageControl holds the derivatives of a received photo, the original is
+// downloaded through FileControl.
+type ImageControl struct {
+	Width          int    `json:",omitempty"`
+	Height         int    `json:",omitempty"`
+	Placeholder    []byte `json:",omitempty"`
+	Preview        string `json:",omitempty"`
+	placeholder    image.Image
+	previewReady   bool
+	previewChecked bool
+}
+
+// previewName names the preview of the photo sent as fileId, photos of the
+// same name from different folders get different previews.
+func previewName(fileId uint32) string {
+	return fmt.Sprintf("%08x.preview.jpg", fileId)
+}
+
+// makeDerivatives decodes the photo of fd and encodes its placeholder,
+// the preview of fileId is written into the data dir. It reports false when
+// the photo is small enough to be sent as is.
+func makeDerivatives(fd FileDescription, fileId uint32) (*ImageManifest, string, bool) {
+	file, err := Open(fd.Path)
+	if err != nil {
+		log.Printf("open %s failed: %v", fd.Path, err)
+		return nil, "", false
+	}
+	img, err := decodeImage(file)
+	_ = file.Close()
+	if err != nil {
+		log.Printf("decode %s failed: %v", fd.Path, err)
+		return nil, "", false
+	}
+	dx, dy := img.Bounds().Dx(), img.Bounds().Dy()
+	if fd.Size < progressiveMinSize && max(dx, dy) <= previewEdge {
+		return nil, "", false
+	}
+	m := &ImageManifest{Filename: fd.Name, Size: fd.Size, Width: dx, Height: dy, Preview: previewName(fileId)}
+	if m.Placeholder, err = encodePlaceholder(img); err != nil {
+		log.Printf("encode placeholder failed: %v", err)
+		return nil, "", false
+	}
+	path := GetDataPath(m.Preview)
+	out, err := os.Create(path)
+	if err != nil {
+		log.Printf("create %s failed: %v", path, err)
+		return nil, "", false
+	}
+	defer out.Close()
+	if err = jpeg.Encode(out, flatten(imaging.Fit(img, previewEdge, previewEdge, imaging.CatmullRom)), &jpeg.Options{Quality: previewQuality}); err != nil {
+		log.Printf("encode preview failed: %v", err)
+		return nil, "", false
+	}
+	return m, path, true
+}
+
+// encodePlaceholder encodes a few blurred pixels standing in for img.
+func encodePlaceholder(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	placeholder := blurImage(flatten(imaging.Fit(img, placeholderEdge, placeholderEdge, imaging.Area)))
+	if err := jpeg.Encode(&buf, placeholder, &jpeg.Options{Quality: placeholderQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten draws img over white, jpeg has no alpha.
+func flatten(img image.Image) *image.RGBA {
+	dst := image.NewRGBA(image.Rectangle{Max: img.Bounds().Size()})
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// blurImage applies a 3x3 box blur twice, enough for a placeholder of a few pixels.
+func blurImage(src *image.RGBA) *image.RGBA {
+	b := src.Bounds()
+	for range 2 {
+		dst := image.NewRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var r, g, bl, a, n int
+				for j := max(y-1, b.Min.Y); j <= min(y+1, b.Max.Y-1); j++ {
+					for i := max(x-1, b.Min.X); i <= min(x+1, b.Max.X-1); i++ {
+						c := src.RGBAAt(i, j)
+						r, g, bl, a, n = r+int(c.R), g+int(c.G), bl+int(c.B), a+int(c.A), n+1
+					}
+				}
+				dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)})
+			}
+		}
+		src = dst
+	}
+	return src
+}
+
+// sendProgressivePhoto announces fd with its placeholder, sends the preview
+// and publishes the original. It reports false if no derivative was made or
+// an older build in the room can't read the announcement.
+func sendProgressivePhoto(fd FileDescription, appendFile func(*FileDescription)) bool {
+	if Peers.Legacy() {
+		return false
+	}
+	id := wi.Hash(unsafe.Pointer(&fd))
+	manifest, preview, ok := makeDerivatives(fd, id)
+	if !ok {
+		return false
+	}
+	original := outgoingPhoto(fd)
+	manifest.FileId, manifest.Size = id, original.Size
+	message := &Message{
+		State: Stateless,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		Contacts:    FromMyself(),
+		MessageType: Image,
+		FileControl: FileControl{Path: fd.Path, Filename: fd.Name, FileId: id, Size: uint64(original.Size), Mime: NewMine(fd.Name)},
+		CreatedAt:   time.Now(),
+	}
+	MessageBox <- message
+	// peers download the photo stripped, the message shows the picked file
+	original.ID = id
+	appendFile(&original)
+	err := SendControl(ControlMessage{Kind: ControlImage, Image: manifest})
+	if err != nil {
+		log.Printf("send image manifest failed, %v", err)
+		message.State = Failed
+		return true
+	}
+	if err = sendPreview(preview, manifest.Preview); err != nil {
+		log.Printf("send image preview failed, %v", err)
+		message.State = Failed
+		return true
+	}
+	message.State = Sent
+	return true
+}
+
+// sendPreview sends the preview at path as an image called name.
+func sendPreview(path, name string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return wi.DefaultClient.SendFile(Content(path), wi.OpSendImage, wi.Hash(unsafe.Pointer(&name)), name, uint64(info.Size()), 0)
+}
+
+// NewImageMessage builds the message of a photo announced by sender.
+func NewImageMessage(sender string, m *ImageManifest) *Message {
+	return &Message{
+		State: Sent,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		Contacts:    FromSender(sender),
+		MessageType: Image,
+		FileControl: FileControl{Filename: m.Filename, FileId: m.FileId, Size: uint64(m.Size), Mime: NewMine(m.Filename)},
+		ImageControl: ImageControl{
+			Width: m.Width, Height: m.Height, Placeholder: m.Placeholder, Preview: m.Preview,
+		},
+	}
+}
+
+// previewRegistry pairs received previews with the photos and albums they
+// belong to, so that they replace the placeholder instead of being shown on their own.
+type previewRegistry struct {
+	waiting map[string]func()
+	lock    sync.Mutex
+}
+
+func previewKey(sender, preview string) string {
+	return sender + "/" + preview
+}
+
+// Expect waits for preview from sender, ready is called once it arrived and may be nil.
+func (r *previewRegistry) Expect(sender, preview string, ready func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.waiting[previewKey(sender, preview)] = ready
+}
+
+// Ready reports whether filename is an expected preview and marks it ready.
+func (r *previewRegistry) Ready(sender, filename string) bool {
+	key := previewKey(sender, filename)
+	r.lock.Lock()
+	ready, ok := r.waiting[key]
+	delete(r.waiting, key)
+	r.lock.Unlock()
+	if !ok {
+		return false
+	}
+	if ready != nil {
+		ready()
+	}
+	LoadImage(GetPath(sender, filename), true)
+	return true
+}
+
+var Previews = &previewRegistry{waiting: make(map[string]func())}
+
+// expectPreview waits for the preview of a received photo.
+func (m *Message) expectPreview() {
+	Previews.Expect(m.Sender, m.Preview, func() {
+		m.previewReady, m.previewChecked = true, true
+	})
+}
+
+// progressive reports whether the message is a received photo with derivatives.
+func (m *Message) progressive() bool {
+	return m.MessageType == Image && !m.isMe() && m.FileId != 0 && m.Preview != ""
+}
+
+func (m *Message) previewAvailable() bool {
+	if !m.previewChecked {
+		_, err := os.Stat(GetPath(m.Sender, m.Preview))
+		m.previewReady, m.previewChecked = err == nil, true
+	}
+	return m.previewReady
+}
+
+func (m *Message) placeholderImage() image.Image {
+	if m.placeholder == nil && len(m.Placeholder) > 0 {
+		img, err := jpeg.Decode(bytes.NewReader(m.Placeholder))
+		if err != nil {
+			log.Printf("decode placeholder failed: %v", err)
+			m.Placeholder = nil
+			return nil
+		}
+		m.placeholder = imaging.ToRGBA(img)
+	}
+	return m.placeholder
+}
+
+// bestImage returns the sharpest image loaded so far: original, preview, then placeholder.
+func (m *Message) bestImage() image.Image {
+	if m.downloaded() {
+		if img := m.loadImage(m.FilePath()); img != nil && *img != nil {
+			return *img
+		}
+	}
+	if m.previewAvailable() {
+		if img := m.loadImage(GetPath(m.Sender, m.Preview)); img != nil && *img != nil {
+			return *img
+		}
+	}
+	return m.placeholderImage()
+}
+
+// bestImagePath is the path browsed or saved, the original once it is downloaded.
+func (m *Message) bestImagePath() string {
+	if !m.downloaded() && m.previewAvailable() {
+		return GetPath(m.Sender, m.Preview)
+	}
+	return m.FilePath()
+}
+
+func (m *Message) drawProgressiveImage(gtx layout.Context) layout.Dimensions {
+	img := m.bestImage()
+	if img == nil {
+		return m.drawBlankBox(gtx)
+	}
+	v := gtx.Constraints.Max.X
+	size := image.Pt(v, v*img.Bounds().Dy()/max(1, img.Bounds().Dx()))
+	if m.Width > 0 && m.Height > 0 {
+		// keep the layout stable while the sharper stages arrive
+		size.Y = v * m.Height / m.Width
+	}
+	gtx.Constraints = layout.Exact(size)
+	macro := op.Record(gtx.Ops)
+	d := layout.Stack{}.Layout(gtx,
+		layout.Expanded(func(gtx layout.Context) layout.Dimensions {
+			return widget.Image{Src: paint.NewImageOp(img), Fit: widget.Cover, Position: layout.Center}.Layout(gtx)
+		}),
+		layout.Stacked(func(gtx layout.Context) layout.Dimensions {
+			if !m.downloading() {
+				return layout.Dimensions{}
+			}
+			return layout.UniformInset(unit.Dp(8)).Layout(gtx, m.drawDownloadBadge)
+		}),
+	)
+	call := macro.Stop()
+	m.drawBorder(gtx, d, call)
+	return d
+}
+
+func (m *Message) drawDownloadBadge(gtx layout.Context) layout.Dimensions {
+	macro := op.Record(gtx.Ops)
+	d := layout.Inset{Left: unit.Dp(6), Right: unit.Dp(6), Top: unit.Dp(2), Bottom: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		label := material.Label(m.Theme, m.TextSize*0.8, fmt.Sprintf("%d%%", m.percent()))
+		label.Color = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		return label.Layout(gtx)
+	})
+	call := macro.Stop()
+	rect := image.Rectangle{Max: d.Size}
+	paint.FillShape(gtx.Ops, color.NRGBA{A: 140}, clip.UniformRRect(rect, rect.Dy()/2).Op(gtx.Ops))
+	call.Add(gtx.Ops)
+	return d
+}
@@ -0,0 +1,43 @@
+package view
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestPlaceholder(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4032, 3024))
+	for y := 0; y < 3024; y += 8 {
+		for x := 0; x < 4032; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	data, err := encodePlaceholder(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 1024 {
+		t.Errorf("placeholder should fit in a control message, but %d bytes", len(data))
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(16, 12) {
+		t.Fatalf("placeholder size should be 16x12, but %v", got)
+	}
+	// red lines over transparent, flattened on white and averaged
+	r, g, _, _ := img.At(8, 6).RGBA()
+	if r>>8 < 240 || g>>8 < 200 || g>>8 > 240 {
+		t.Errorf("placeholder should be light red, but r=%d g=%d", r>>8, g>>8)
+	}
+	if name := previewName(0x2a); name != "0000002a.preview.jpg" {
+		t.Errorf("unexpected preview name %s", name)
+	}
+	if previewName(1) == previewName(2) {
+		t.Errorf("previews of different files should have distinct names")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"mushin/assets/icons"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -32,6 +33,9 @@ type SettingsForm struct {
 	nicknameEditor   *component.TextField
 	signEditor       *component.TextField
 	serverAddrEditor *component.TextField
+	stripMetadata    widget.Bool
+	lessVoice        widget.Clickable
+	moreVoice        widget.Clickable
 	submitButton     IconButton
 	lastItemFocused  bool
 }
@@ -153,12 +157,35 @@ func copyFile(src, dst string) error {
 	return err
 }
 
+// writeFileAtomic writes data to a temp file then renames it over path,
+// so a kill during the write keeps the old content. Every call gets its own
+// temp file, concurrent writers never mix their data.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // copyThenReloadIcon copy icon from oldUUID to newUUID
 func copyThenReloadIcon(oldUUID string, newUUID string) {
-	paths := []string{
-		GetPath(oldUUID, "icon.png"), GetPath(oldUUID, "icon.gif"),
-	}
-	for _, path := range paths {
+	for _, path := range iconPaths(oldUUID) {
 		_, err := os.Stat(path)
 		if err != nil {
 			continue
@@ -170,7 +197,7 @@ func copyThenReloadIcon(oldUUID string, newUUID string) {
 			log.Printf("copy %s failed: %v", path, err)
 		}
 		avatar := AvatarCache.LoadOrElseNew(newUUID)
-		if filepath.Ext(path) == ".gif" {
+		if animatedIcon(path) {
 			avatar.Reload(GIF_IMG)
 		} else {
 			avatar.Reload(IMG)
@@ -179,6 +206,13 @@ func copyThenReloadIcon(oldUUID string, newUUID string) {
 }
 
 func (s *SettingsForm) Layout(gtx layout.Context) layout.Dimensions {
+	s.stripMetadata.Value = Prefs.Get().StripMetadata
+	if s.stripMetadata.Update(gtx) {
+		Prefs.Update(func(p *Preferences) {
+			p.StripMetadata = s.stripMetadata.Value
+		})
+	}
+	s.processMaxVoice(gtx)
 	if len(s.nicknameEditor.Text()) == 0 && !gtx.Focused(&s.nicknameEditor.Editor) {
 		s.nicknameEditor.SetText(wi.DefaultClient.Nickname)
 	}
@@ -218,6 +252,12 @@ func (s *SettingsForm) Layout(gtx layout.Context) layout.Dimensions {
 				layout.Rigid(s.drawInputArea("Server Addr:", func(gtx layout.Context) layout.Dimensions {
 					return s.serverAddrEditor.Layout(gtx, s.Theme, "")
 				})),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(15)}.Layout),
+				layout.Rigid(s.drawInputArea("Strip Metadata:", func(gtx layout.Context) layout.Dimensions {
+					return material.Switch(s.Theme, &s.stripMetadata, "Strip location and device info from sent photos").Layout(gtx)
+				})),
+				layout.Rigid(layout.Spacer{Height: unit.Dp(15)}.Layout),
+				layout.Rigid(s.drawInputArea("Max Voice:", s.drawMaxVoice)),
 				layout.Rigid(layout.Spacer{Height: unit.Dp(25)}.Layout),
 				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 					return s.submitButton.Layout(gtx, 1.0, 0, 0)
@@ -231,6 +271,40 @@ func (s *SettingsForm) Layout(gtx layout.Context) layout.Dimensions {
 	return dimensions
 }
 
+// processMaxVoice steps the longest voice recording through maxVoiceSeconds.
+func (s *SettingsForm) processMaxVoice(gtx layout.Context) {
+	i := slices.Index(maxVoiceSeconds, Prefs.Get().MaxVoiceSeconds)
+	if s.lessVoice.Clicked(gtx) && i > 0 {
+		i--
+	}
+	if s.moreVoice.Clicked(gtx) && i < len(maxVoiceSeconds)-1 {
+		i++
+	}
+	if seconds := maxVoiceSeconds[max(i, 0)]; seconds != Prefs.Get().MaxVoiceSeconds {
+		Prefs.Update(func(p *Preferences) { p.MaxVoiceSeconds = seconds })
+	}
+}
+
+func (s *SettingsForm) drawMaxVoice(gtx layout.Context) layout.Dimensions {
+	action := func(button *widget.Clickable, icon *widget.Icon) layout.FlexChild {
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(24)
+				return icon.Layout(gtx, s.ContrastBg)
+			})
+		})
+	}
+	value := formatDuration(maxVoiceDuration())
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		action(&s.lessVoice, icons.RemoveIcon),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(56)
+			return layout.Center.Layout(gtx, material.Label(s.Theme, s.TextSize, value).Layout)
+		}),
+		action(&s.moreVoice, icons.AddIcon),
+	)
+}
+
 func (s *SettingsForm) drawInputArea(label string, widget layout.Widget) func(gtx layout.Context) layout.Dimensions {
 	return func(gtx layout.Context) layout.Dimensions {
 		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Baseline}.Layout(gtx,
@@ -0,0 +1,561 @@
+package view
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"io"
+	"log"
+	"mushin/assets/fonts"
+	"mushin/internal/imaging"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/paint"
+	"gioui.org/widget"
+	"github.com/CoyAce/wi"
+)
+
+const (
+	// maxStickerSize bounds a single sticker, they are sent inline with the chat.
+	maxStickerSize = 1 << 20
+	maxPackSize    = 64 << 20
+	maxPackLen     = 200
+	maxRecent      = 24
+	// stickerRetry is how long a fetch is left alone before it's asked again.
+	stickerRetry = 30 * time.Second
+	// stickerExpiry is how long content is accepted for a fetch, a sender
+	// that went away doesn't keep the request around.
+	stickerExpiry = 10 * time.Minute
+)
+
+var (
+	errNoManifest    = errors.New("sticker pack has no manifest.json")
+	errEmptyPack     = errors.New("sticker pack has no valid sticker")
+	errStickerTooBig = errors.New("sticker too big")
+	errPackTooBig    = errors.New("sticker pack too big")
+)
+
+// StickerRef references a sticker by the sha256 of its content, receivers
+// fetch it once and keep it in their store.
+type StickerRef struct {
+	Hash string `json:"hash"`
+	Ext  string `json:"ext"`
+	Size int64  `json:"size"`
+	// Name is the shortcode, ":name:" sends the sticker like a custom emoji.
+	Name  string `json:"name,omitempty"`
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// Filename is the name of the sticker in the store.
+func (r StickerRef) Filename() string {
+	return r.Hash + r.Ext
+}
+
+// FileId is the content id the sticker is published under.
+func (r StickerRef) FileId() uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte("sticker"))
+	_, _ = h.Write([]byte(r.Hash))
+	return nonZero(h.Sum32())
+}
+
+// Valid reports whether r can be used as a file name, refs come from peers.
+func (r StickerRef) Valid() bool {
+	sum, err := hex.DecodeString(r.Hash)
+	return err == nil && len(sum) == sha256.Size && r.Hash == strings.ToLower(r.Hash) &&
+		slices.Contains(stickerExts, r.Ext) && r.Size > 0 && r.Size <= maxStickerSize
+}
+
+// StickerControl references the sticker of a Sticker message.
+type StickerControl struct {
+	Sticker *StickerRef `json:",omitempty"`
+}
+
+// StickerPack is an imported set of stickers.
+type StickerPack struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Stickers []StickerRef `json:"stickers"`
+}
+
+// stickerManifest is the manifest.json at the root of a pack zip.
+type stickerManifest struct {
+	Name     string `json:"name"`
+	Stickers []struct {
+		File  string `json:"file"`
+		Name  string `json:"name"`
+		Emoji string `json:"emoji"`
+	} `json:"stickers"`
+}
+
+var stickerExts = []string{".png", ".apng", ".gif", ".webp"}
+
+// stickerExt sniffs the format of data, "" if it is no image a sticker can be.
+func stickerExt(data []byte) string {
+	switch imaging.AnimationFormat(data) {
+	case "gif":
+		return ".gif"
+	case "apng":
+		return ".apng"
+	case "webp":
+		return ".webp"
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return ".webp"
+	}
+	return ""
+}
+
+type stickerIndex struct {
+	Packs  []StickerPack `json:"packs"`
+	Recent []StickerRef  `json:"recent"`
+}
+
+type stickerFetch struct {
+	ref    StickerRef
+	sender string
+	at     time.Time
+}
+
+// StickerStore keeps sticker files by content hash under dir, together with
+// the imported packs and the recently used stickers in packs.json.
+// It is safe for concurrent use.
+type StickerStore struct {
+	dir   string
+	index stickerIndex
+	// stored holds the hashes of the stored stickers, files names them by
+	// the id they are published under. Ids may collide, hashes don't.
+	stored  map[string]bool
+	files   map[uint32]string
+	pending map[uint32]*stickerFetch
+	lock    sync.Mutex
+}
+
+func newStickerStore(dir string) *StickerStore {
+	return &StickerStore{
+		dir:     dir,
+		stored:  make(map[string]bool),
+		files:   make(map[uint32]string),
+		pending: make(map[uint32]*stickerFetch),
+	}
+}
+
+// add records ref as stored, the caller holds the lock.
+func (s *StickerStore) add(ref StickerRef) {
+	s.stored[ref.Hash] = true
+	s.files[ref.FileId()] = ref.Filename()
+}
+
+// LoadStickers reads the stickers and packs stored under dir.
+func LoadStickers(dir string) *StickerStore {
+	s := newStickerStore(dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return s
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		ref := StickerRef{Hash: strings.TrimSuffix(e.Name(), ext), Ext: ext, Size: 1}
+		if ref.Valid() {
+			s.add(ref)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "packs.json"))
+	if err != nil {
+		return s
+	}
+	if err = json.Unmarshal(data, &s.index); err != nil {
+		log.Printf("Unmarshall sticker packs failed: %v", err)
+	}
+	return s
+}
+
+// Packs returns the imported packs, most recent first.
+func (s *StickerStore) Packs() []StickerPack {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.index.Packs)
+}
+
+// Recent returns the recently sent stickers, most recent first.
+func (s *StickerStore) Recent() []StickerRef {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.index.Recent)
+}
+
+// Path is where the sticker of ref is stored.
+func (s *StickerStore) Path(ref StickerRef) string {
+	return filepath.Join(s.dir, ref.Filename())
+}
+
+// Has reports whether the sticker of ref is stored.
+func (s *StickerStore) Has(ref StickerRef) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stored[ref.Hash]
+}
+
+// Import adds the stickers of a pack zip, stickers already stored are
+// shared rather than written again and a pack imported twice is kept once.
+func (s *StickerStore) Import(data []byte) (*StickerPack, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[path.Clean(f.Name)] = f
+	}
+	mf, ok := files["manifest.json"]
+	if !ok {
+		return nil, errNoManifest
+	}
+	raw, err := readZipFile(mf, maxStickerSize)
+	if err != nil {
+		return nil, err
+	}
+	var manifest stickerManifest
+	if err = json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest.json: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	pack := &StickerPack{ID: hex.EncodeToString(sum[:8]), Name: manifest.Name}
+	if pack.Name == "" {
+		pack.Name = "Stickers"
+	}
+	var total int64
+	for _, item := range manifest.Stickers {
+		if len(pack.Stickers) == maxPackLen {
+			break
+		}
+		f, ok := files[path.Clean(item.File)]
+		if !ok {
+			log.Printf("sticker %s not found in pack %s", item.File, pack.Name)
+			continue
+		}
+		content, err := readZipFile(f, maxStickerSize)
+		if err != nil {
+			log.Printf("read sticker %s failed: %v", item.File, err)
+			continue
+		}
+		if total += int64(len(content)); total > maxPackSize {
+			return nil, errPackTooBig
+		}
+		ref, err := s.store(content)
+		if err != nil {
+			log.Printf("import sticker %s failed: %v", item.File, err)
+			continue
+		}
+		if slices.ContainsFunc(pack.Stickers, func(r StickerRef) bool { return r.Hash == ref.Hash }) {
+			continue
+		}
+		ref.Name, ref.Emoji = shortcode(item.Name), item.Emoji
+		pack.Stickers = append(pack.Stickers, ref)
+	}
+	if len(pack.Stickers) == 0 {
+		return nil, errEmptyPack
+	}
+	s.lock.Lock()
+	s.index.Packs = slices.DeleteFunc(s.index.Packs, func(p StickerPack) bool { return p.ID == pack.ID })
+	s.index.Packs = slices.Insert(s.index.Packs, 0, *pack)
+	s.lock.Unlock()
+	s.save()
+	return pack, nil
+}
+
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, errStickerTooBig
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errStickerTooBig
+	}
+	return data, nil
+}
+
+// shortcode keeps the characters of name usable between colons.
+func shortcode(name string) string {
+	name = strings.Trim(strings.ToLower(strings.TrimSpace(name)), ":")
+	return strings.Map(func(r rune) rune {
+		if r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// store checks that data decodes and writes it under its hash.
+func (s *StickerStore) store(data []byte) (StickerRef, error) {
+	ext := stickerExt(data)
+	if ext == "" {
+		return StickerRef{}, imaging.ErrUnsupported
+	}
+	if _, err := imaging.DecodeAnimation(data); err != nil {
+		return StickerRef{}, err
+	}
+	sum := sha256.Sum256(data)
+	ref := StickerRef{Hash: hex.EncodeToString(sum[:]), Ext: ext, Size: int64(len(data))}
+	if s.Has(ref) {
+		return ref, nil
+	}
+	if err := writeFileAtomic(s.Path(ref), data); err != nil {
+		return ref, err
+	}
+	s.lock.Lock()
+	s.add(ref)
+	s.lock.Unlock()
+	return ref, nil
+}
+
+// Remove drops a pack, its stickers stay stored for the messages showing them.
+func (s *StickerStore) Remove(id string) {
+	s.lock.Lock()
+	s.index.Packs = slices.DeleteFunc(s.index.Packs, func(p StickerPack) bool { return p.ID == id })
+	s.lock.Unlock()
+	s.save()
+}
+
+// Use moves ref to the front of the recently used stickers.
+func (s *StickerStore) Use(ref StickerRef) {
+	s.lock.Lock()
+	recent := slices.DeleteFunc(s.index.Recent, func(r StickerRef) bool { return r.Hash == ref.Hash })
+	recent = slices.Insert(recent, 0, ref)
+	s.index.Recent = recent[:min(len(recent), maxRecent)]
+	s.lock.Unlock()
+	s.save()
+}
+
+// Lookup finds the sticker whose shortcode is name.
+func (s *StickerStore) Lookup(name string) (StickerRef, bool) {
+	name = shortcode(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range s.index.Packs {
+		for _, ref := range p.Stickers {
+			if ref.Name != "" && ref.Name == name {
+				return ref, true
+			}
+		}
+	}
+	return StickerRef{}, false
+}
+
+// Find describes the stored sticker published under id, for serving it to peers.
+func (s *StickerStore) Find(id uint32) *FileDescription {
+	s.lock.Lock()
+	name, ok := s.files[id]
+	s.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	p := filepath.Join(s.dir, name)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+	return &FileDescription{ID: id, Name: name, Path: p, Size: info.Size()}
+}
+
+// Fetch asks sender for the sticker of ref unless it's stored or already asked for.
+func (s *StickerStore) Fetch(ref StickerRef, sender string) {
+	id := ref.FileId()
+	s.lock.Lock()
+	if s.stored[ref.Hash] {
+		s.lock.Unlock()
+		return
+	}
+	if f, ok := s.pending[id]; ok && time.Since(f.at) < stickerRetry {
+		s.lock.Unlock()
+		return
+	}
+	expired := s.expire()
+	s.pending[id] = &stickerFetch{ref: ref, sender: sender, at: time.Now()}
+	s.lock.Unlock()
+	go func() {
+		for id, f := range expired {
+			_ = wi.DefaultClient.UnsubscribeFile(id, f.sender)
+		}
+		if err := wi.DefaultClient.SubscribeFile(id, sender, func(int, int) {}); err != nil {
+			log.Printf("fetch sticker %s failed: %v", ref.Hash, err)
+		}
+	}()
+}
+
+// expire drops the fetches nothing arrived for, the caller holds the lock.
+func (s *StickerStore) expire() map[uint32]*stickerFetch {
+	expired := make(map[uint32]*stickerFetch)
+	for id, f := range s.pending {
+		if time.Since(f.at) > stickerExpiry {
+			expired[id] = f
+			delete(s.pending, id)
+		}
+	}
+	return expired
+}
+
+// Deliver stores a fetched sticker, it reports false unless req is the
+// sticker asked from its sender.
+func (s *StickerStore) Deliver(req wi.WriteReq) bool {
+	s.lock.Lock()
+	f, ok := s.pending[req.FileId]
+	ok = ok && f.sender == req.UUID && req.Filename == f.ref.Filename() && time.Since(f.at) <= stickerExpiry
+	if ok {
+		delete(s.pending, req.FileId)
+	}
+	s.lock.Unlock()
+	if !ok {
+		return false
+	}
+	_ = wi.DefaultClient.UnsubscribeFile(req.FileId, req.UUID)
+	p := GetPath(req.UUID, req.Filename)
+	defer os.Remove(p)
+	data, err := os.ReadFile(p)
+	if err != nil {
+		log.Printf("read sticker %s failed: %v", f.ref.Hash, err)
+		return true
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != f.ref.Hash {
+		log.Printf("sticker %s: %v", f.ref.Hash, errCorrupt)
+		return true
+	}
+	if _, err = s.store(data); err != nil {
+		log.Printf("store sticker %s failed: %v", f.ref.Hash, err)
+		return true
+	}
+	invalidate()
+	return true
+}
+
+func (s *StickerStore) save() {
+	s.lock.Lock()
+	data, err := json.Marshal(s.index)
+	s.lock.Unlock()
+	if err != nil {
+		log.Printf("Marshall sticker packs failed: %v", err)
+		return
+	}
+	if err = writeFileAtomic(filepath.Join(s.dir, "packs.json"), data); err != nil {
+		log.Printf("Write sticker packs failed: %v", err)
+	}
+}
+
+var Stickers = newStickerStore("")
+
+func NewStickerMessage(contacts Contacts, ref StickerRef) *Message {
+	return &Message{
+		State: Stateless,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		StickerControl: StickerControl{Sticker: &ref},
+		Contacts:       contacts,
+		MessageType:    Sticker,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// SendSticker sends a reference to the sticker, peers fetch its content once.
+// Older builds in the room get the sticker as an image instead.
+func SendSticker(ref StickerRef) {
+	Stickers.Use(ref)
+	if Peers.Legacy() {
+		SendPhoto(FileDescription{Name: ref.Filename(), Path: Stickers.Path(ref), Size: ref.Size}, nil)
+		return
+	}
+	message := NewStickerMessage(FromMyself(), ref)
+	MessageBox <- message
+	if err := SendControl(ControlMessage{Kind: ControlSticker, Sticker: &ref}); err != nil {
+		log.Printf("send sticker failed, %v", err)
+		message.State = Failed
+		return
+	}
+	message.State = Sent
+}
+
+// stickerEdge is the size stickers are drawn at, they have no bubble.
+const stickerEdge = 128
+
+// stickerShortcode returns the name of a ":name:" message.
+func stickerShortcode(text string) (string, bool) {
+	if len(text) < 3 || text[0] != ':' || text[len(text)-1] != ':' {
+		return "", false
+	}
+	name := text[1 : len(text)-1]
+	if strings.ContainsAny(name, ": \t\n") {
+		return "", false
+	}
+	return name, true
+}
+
+// drawSticker draws the sticker without a bubble, a missing one is fetched
+// from the sender and takes its place once it arrives.
+func (m *Message) drawSticker(gtx layout.Context) layout.Dimensions {
+	edge := min(gtx.Dp(stickerEdge), gtx.Constraints.Max.X)
+	gtx.Constraints = layout.Exact(image.Pt(edge, edge))
+	ref := *m.Sticker
+	if !Stickers.Has(ref) {
+		if !m.isMe() {
+			Stickers.Fetch(ref, m.Sender)
+		}
+		return layout.Dimensions{Size: gtx.Constraints.Max}
+	}
+	return layoutSticker(gtx, ref)
+}
+
+// layoutSticker draws a stored sticker to fit the constraints.
+func layoutSticker(gtx layout.Context, ref StickerRef) layout.Dimensions {
+	p := Stickers.Path(ref)
+	animated, known := animatedState(p)
+	if !known {
+		return layout.Dimensions{Size: gtx.Constraints.Max}
+	}
+	if animated {
+		// composed once at the size of the chat, the picker draws them smaller
+		gifImg := LoadSticker(p, gtx.Dp(stickerEdge))
+		if gifImg.Animation == nil {
+			return layout.Dimensions{Size: gtx.Constraints.Max}
+		}
+		// gifs are laid out by their width, narrow ones are shrunk to fit the height
+		edge := gtx.Constraints.Max
+		if gifImg.Height > gifImg.Width {
+			gtx.Constraints.Min.X = edge.X * gifImg.Width / gifImg.Height
+		}
+		x := (edge.X - gtx.Constraints.Min.X) / 2
+		defer op.Offset(image.Pt(x, 0)).Push(gtx.Ops).Pop()
+		gifImg.Layout(gtx, WidthFixed)
+		return layout.Dimensions{Size: edge}
+	}
+	img := LoadImage(p, false)
+	if img == nil || *img == nil {
+		return layout.Dimensions{Size: gtx.Constraints.Max}
+	}
+	return widget.Image{Src: paint.NewImageOp(*img), Fit: widget.Contain, Position: layout.Center}.Layout(gtx)
+}
@@ -0,0 +1,216 @@
+package view
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"mushin/assets/fonts"
+	"mushin/assets/icons"
+	"time"
+
+	modal "mushin/ui/layout"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	"golang.org/x/exp/shiny/materialdesign/colornames"
+)
+
+// stickerCell is the size of a sticker in the picker.
+const stickerCell = 64
+
+// StickerPanel picks a sticker to send and imports or removes sticker packs.
+type StickerPanel struct {
+	*material.Theme
+	modalContent *modal.ModalContent
+	importButton widget.Clickable
+	// keyed by section and hash, a sticker may show in recents and in its pack
+	stickerButtons map[string]*widget.Clickable
+	removeButtons  map[string]*widget.Clickable
+}
+
+func NewStickerPanel() *StickerPanel {
+	p := &StickerPanel{
+		Theme:          fonts.DefaultTheme,
+		stickerButtons: make(map[string]*widget.Clickable),
+		removeButtons:  make(map[string]*widget.Clickable),
+	}
+	p.modalContent = modal.NewModalContent(fonts.DefaultTheme, func() {
+		modal.DefaultModal.Dismiss(nil)
+	})
+	p.modalContent.SetTitle("Stickers")
+	return p
+}
+
+func (p *StickerPanel) update(gtx layout.Context) {
+	if p.importButton.Clicked(gtx) {
+		go importStickerPack()
+	}
+	for id, button := range p.removeButtons {
+		if button.Clicked(gtx) {
+			Stickers.Remove(id)
+		}
+	}
+}
+
+// importStickerPack asks for a pack zip and adds it to the store.
+func importStickerPack() {
+	file, err := Picker.ChooseFile(".zip")
+	if err != nil {
+		log.Printf("choose sticker pack failed: %v", err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxPackSize+1))
+	if err == nil && len(data) > maxPackSize {
+		err = errPackTooBig
+	}
+	if err == nil {
+		_, err = Stickers.Import(data)
+	}
+	if err != nil {
+		log.Printf("import sticker pack failed: %v", err)
+		HintRequest <- "❌表情包导入失败"
+		return
+	}
+	HintRequest <- "✅完成"
+}
+
+// stickerRow is one element of the panel list, a title or a row of stickers.
+type stickerRow struct {
+	top     unit.Dp
+	title   string
+	remove  *widget.Clickable
+	section string
+	refs    []StickerRef
+}
+
+// rows splits the recent stickers and the packs into rows of cols stickers,
+// the panel only lays out the rows in view.
+func (p *StickerPanel) rows(cols int) []stickerRow {
+	// the first row holds the import button
+	rows := []stickerRow{{}}
+	section := func(top unit.Dp, title, id string, remove *widget.Clickable, refs []StickerRef) {
+		rows = append(rows, stickerRow{top: top, title: title, remove: remove})
+		for i := 0; i < len(refs); i += cols {
+			rows = append(rows, stickerRow{section: id, refs: refs[i:min(i+cols, len(refs))]})
+		}
+	}
+	if recent := Stickers.Recent(); len(recent) > 0 {
+		section(12, "Recent", "recent", nil, recent)
+	}
+	for _, pack := range Stickers.Packs() {
+		section(16, pack.Name, pack.ID, p.button(p.removeButtons, pack.ID), pack.Stickers)
+	}
+	return rows
+}
+
+func (p *StickerPanel) drawRow(gtx layout.Context, row stickerRow, first, last bool) layout.Dimensions {
+	margins := layout.Inset{Top: row.top, Left: unit.Dp(16), Right: unit.Dp(16)}
+	if first {
+		margins.Top = unit.Dp(12)
+	}
+	if last {
+		margins.Bottom = unit.Dp(24)
+	}
+	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		gtx.Constraints.Min.X = gtx.Constraints.Max.X
+		switch {
+		case first:
+			return layout.Flex{Spacing: layout.SpaceStart}.Layout(gtx, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Button(p.Theme, &p.importButton, "Import pack").Layout(gtx)
+			}))
+		case row.title != "":
+			return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, p.drawTitle(row.title, row.remove))
+		default:
+			return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, p.drawStickers(row.section, row.refs))
+		}
+	})
+}
+
+func (p *StickerPanel) button(buttons map[string]*widget.Clickable, key string) *widget.Clickable {
+	b, ok := buttons[key]
+	if !ok {
+		b = new(widget.Clickable)
+		buttons[key] = b
+	}
+	return b
+}
+
+func (p *StickerPanel) drawTitle(title string, remove *widget.Clickable) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		children := []layout.FlexChild{
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(p.Theme, p.TextSize*0.85, title)
+				label.Font.Weight = font.Bold
+				label.MaxLines = 1
+				return label.Layout(gtx)
+			}),
+		}
+		if remove != nil {
+			children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return remove.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(24)
+					return icons.ClearIcon.Layout(gtx, color.NRGBA(colornames.Red400))
+				})
+			}))
+		}
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx, children...)
+	}
+}
+
+func (p *StickerPanel) drawStickers(section string, refs []StickerRef) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		cell, gap := gtx.Dp(stickerCell), gtx.Dp(8)
+		for i, ref := range refs {
+			button := p.button(p.stickerButtons, section+"/"+ref.Hash)
+			if button.Clicked(gtx) {
+				go SendSticker(ref)
+				modal.DefaultModal.Dismiss(nil)
+			}
+			stack := op.Offset(image.Pt(i*(cell+gap), 0)).Push(gtx.Ops)
+			gtx := gtx
+			gtx.Constraints = layout.Exact(image.Pt(cell, cell))
+			button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				if button.Hovered() {
+					bg := p.ContrastBg
+					bg.A = 60
+					paint.FillShape(gtx.Ops, bg, clip.UniformRRect(image.Rectangle{Max: gtx.Constraints.Max}, gtx.Dp(6)).Op(gtx.Ops))
+				}
+				if !Stickers.Has(ref) {
+					return layout.Dimensions{Size: gtx.Constraints.Max}
+				}
+				return layoutSticker(gtx, ref)
+			})
+			stack.Pop()
+		}
+		return layout.Dimensions{Size: image.Pt(gtx.Constraints.Max.X, cell)}
+	}
+}
+
+func (p *StickerPanel) ShowWithModal() {
+	modal.DefaultModal.Show(p.ZoomInWithModalContent, nil, component.VisibilityAnimation{
+		Duration: time.Millisecond * 250,
+		State:    component.Invisible,
+		Started:  time.Time{},
+	})
+}
+
+func (p *StickerPanel) ZoomInWithModalContent(gtx layout.Context) layout.Dimensions {
+	gtx.Constraints.Max.X = int(float32(gtx.Constraints.Max.X) * 0.85)
+	gtx.Constraints.Max.Y = int(float32(gtx.Constraints.Max.Y) * 0.85)
+	p.update(gtx)
+	cell, gap := gtx.Dp(stickerCell), gtx.Dp(8)
+	cols := max((gtx.Constraints.Max.X-gtx.Dp(32)+gap)/(cell+gap), 1)
+	rows := p.rows(cols)
+	return p.modalContent.DrawList(gtx, len(rows), func(gtx layout.Context, index int) layout.Dimensions {
+		return p.drawRow(gtx, rows[index], index == 0, index == len(rows)-1)
+	})
+}
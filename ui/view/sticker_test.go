@@ -0,0 +1,132 @@
+package view
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func stickerPNG(t *testing.T, c color.NRGBA) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func stickerPack(t *testing.T, manifest any, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	data, _ := json.Marshal(manifest)
+	files["manifest.json"] = data
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStickerImport(t *testing.T) {
+	red := stickerPNG(t, color.NRGBA{R: 255, A: 255})
+	manifest := map[string]any{
+		"name": "cats",
+		"stickers": []map[string]string{
+			{"file": "a.png", "name": "Cat Wave", "emoji": "👋"},
+			{"file": "b.png", "name": "same"},
+			{"file": "broken.png"},
+			{"file": "missing.png"},
+		},
+	}
+	files := map[string][]byte{"a.png": red, "b.png": red, "broken.png": []byte("not an image")}
+	dir := t.TempDir()
+	s := LoadStickers(dir)
+	pack, err := s.Import(stickerPack(t, manifest, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pack.Stickers) != 1 || pack.Stickers[0].Ext != ".png" || pack.Stickers[0].Name != "cat_wave" {
+		t.Fatalf("the pack should hold one png named cat_wave, but %+v", pack.Stickers)
+	}
+	if _, err = s.Import(stickerPack(t, manifest, files)); err != nil || len(s.Packs()) != 1 {
+		t.Errorf("a pack imported twice should be kept once, but %d packs, %v", len(s.Packs()), err)
+	}
+	if _, err = s.Import(stickerPack(t, map[string]any{"stickers": []any{}}, map[string][]byte{})); err != errEmptyPack {
+		t.Errorf("a pack without stickers should fail, but %v", err)
+	}
+
+	ref := pack.Stickers[0]
+	s.Use(ref)
+	s = LoadStickers(dir)
+	if !s.Has(ref) || len(s.Recent()) != 1 || len(s.Packs()) != 1 {
+		t.Fatalf("the sticker, recents and packs should be reloaded")
+	}
+	if found, ok := s.Lookup(":Cat Wave:"); !ok || found.Hash != ref.Hash {
+		t.Errorf("the sticker should be found by its shortcode")
+	}
+	if fd := s.Find(ref.FileId()); fd == nil || fd.Size != ref.Size {
+		t.Errorf("the sticker should be served under its file id, but %+v", fd)
+	}
+	s.Remove(pack.ID)
+	if len(s.Packs()) != 0 || !s.Has(ref) {
+		t.Errorf("removing a pack should keep its stickers for the messages showing them")
+	}
+}
+
+func TestStickerHasByHash(t *testing.T) {
+	s := newStickerStore(t.TempDir())
+	ref := StickerRef{Hash: strings.Repeat("ab", 32), Ext: ".png", Size: 1}
+	// another sticker published under the same id
+	s.files[ref.FileId()] = strings.Repeat("cd", 32) + ".png"
+	if s.Has(ref) {
+		t.Errorf("a sticker sharing only the file id should not be stored")
+	}
+}
+
+func TestStickerRecent(t *testing.T) {
+	s := LoadStickers(t.TempDir())
+	for i := range maxRecent + 4 {
+		s.Use(StickerRef{Hash: string(rune('a' + i))})
+	}
+	s.Use(StickerRef{Hash: "z"})
+	recent := s.Recent()
+	if len(recent) != maxRecent || recent[0].Hash != "z" || recent[1].Hash != string(rune('a'+maxRecent+3)) {
+		t.Errorf("recents should be capped and most recent first, but %d, %v", len(recent), recent[:2])
+	}
+}
+
+func TestStickerRefValid(t *testing.T) {
+	ref := StickerRef{Hash: "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9", Ext: ".gif", Size: 10}
+	if !ref.Valid() {
+		t.Errorf("%+v should be valid", ref)
+	}
+	for _, bad := range []StickerRef{
+		{Hash: ref.Hash, Ext: "/../x", Size: 10},
+		{Hash: "../../etc/passwd", Ext: ".png", Size: 10},
+		{Hash: ref.Hash, Ext: ".png", Size: maxStickerSize + 1},
+	} {
+		if bad.Valid() {
+			t.Errorf("%+v should be invalid", bad)
+		}
+	}
+	if name, ok := stickerShortcode(":cat_wave:"); !ok || name != "cat_wave" {
+		t.Errorf("shortcode should be cat_wave, but %q", name)
+	}
+	if _, ok := stickerShortcode(":not a code:"); ok {
+		t.Errorf("text with spaces is no shortcode")
+	}
+}
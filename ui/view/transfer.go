@@ -0,0 +1,668 @@
+package view
+
+import (
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"gioui.org/widget"
+	"github.com/CoyAce/wi"
+)
+
+var (
+	errPaused   = errors.New("transfer paused")
+	errCanceled = errors.New("transfer canceled")
+)
+
+type TransferKind int
+
+const (
+	Upload TransferKind = iota
+	Download
+)
+
+type TransferState int
+
+const (
+	Queued TransferState = iota
+	Active
+	Paused
+	Completed
+	Failed
+	Canceled
+)
+
+func (s TransferState) String() string {
+	switch s {
+	case Queued:
+		return "queued"
+	case Active:
+		return "active"
+	case Paused:
+		return "paused"
+	case Completed:
+		return "completed"
+	case Failed:
+		return "failed"
+	case Canceled:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
+
+func (s TransferState) finished() bool {
+	return s == Completed || s == Failed || s == Canceled
+}
+
+// Transfer is one upload or download shown in the transfer manager.
+// Chunked uploads of the same file are accounted to a single transfer.
+type Transfer struct {
+	Kind     TransferKind
+	ID       uint32
+	Filename string
+	Size     int64
+	state    TransferState
+	done     int64
+	speed    int
+	err      error
+	meter    speedMeter
+	// resumed is closed while the transfer isn't paused
+	resumed  chan struct{}
+	canceled chan struct{}
+	onPause  func()
+	onResume func()
+	onCancel func()
+	onRetry  func()
+	// publishes not read to the end yet, by content id
+	unsent map[uint32]*upload
+	lock   sync.Mutex
+
+	pauseButton  widget.Clickable
+	resumeButton widget.Clickable
+	cancelButton widget.Clickable
+	retryButton  widget.Clickable
+}
+
+// upload is one publish accounted to a transfer, sent counts its bytes so far.
+type upload struct {
+	content func() (io.ReadSeekCloser, error)
+	name    string
+	size    uint64
+	sent    int64
+}
+
+func newTransfer(kind TransferKind, id uint32, filename string, size int64) *Transfer {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &Transfer{Kind: kind, ID: id, Filename: filename, Size: size, resumed: resumed, canceled: make(chan struct{})}
+}
+
+func (t *Transfer) State() TransferState {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.state
+}
+
+func (t *Transfer) Err() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.err
+}
+
+func (t *Transfer) Progress() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state == Completed {
+		return 100
+	}
+	if t.Size == 0 {
+		return 0
+	}
+	return int(min(t.done*100/t.Size, 99))
+}
+
+func (t *Transfer) Speed() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state != Active {
+		return 0
+	}
+	return t.speed
+}
+
+func (t *Transfer) setState(s TransferState, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.state = s
+	t.err = err
+	if s != Active {
+		t.speed = 0
+	}
+}
+
+// report updates a download from the progress callback of wi.
+func (t *Transfer) report(progress int, speed int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done = t.Size * int64(progress) / 100
+	t.speed = speed
+}
+
+// add accounts n bytes of content id sent, it completes the transfer once every byte is sent.
+func (t *Transfer) add(id uint32, n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if u := t.unsent[id]; u != nil {
+		u.sent += int64(n)
+	}
+	t.done += int64(n)
+	t.speed = t.meter.add(n)
+	if t.Size > 0 && t.done >= t.Size && !t.state.finished() {
+		t.state = Completed
+		t.speed = 0
+	}
+}
+
+// queue records a publish of content id until it was read to the end.
+func (t *Transfer) queue(id uint32, u *upload) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.unsent == nil {
+		t.unsent = make(map[uint32]*upload)
+	}
+	t.unsent[id] = u
+}
+
+// sent forgets the publish of content id once it was read to the end.
+func (t *Transfer) sent(id uint32) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.unsent, id)
+}
+
+// pending returns the publishes that weren't read to the end.
+func (t *Transfer) pending() map[uint32]*upload {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return maps.Clone(t.unsent)
+}
+
+// skip accounts bytes sent by an earlier session of a resumed upload.
+func (t *Transfer) skip(offset int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done = max(t.done, offset)
+}
+
+func (t *Transfer) pause() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state.finished() {
+		return
+	}
+	select {
+	case <-t.resumed:
+		t.resumed = make(chan struct{})
+	default:
+	}
+	t.state = Paused
+	t.speed = 0
+}
+
+func (t *Transfer) resume() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.resumed:
+	default:
+		close(t.resumed)
+	}
+	if t.state == Paused {
+		t.state = Active
+	}
+}
+
+func (t *Transfer) cancel() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.canceled:
+	default:
+		close(t.canceled)
+	}
+	t.state = Canceled
+	t.speed = 0
+}
+
+// restart makes a finished transfer runnable again.
+func (t *Transfer) restart() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.canceled:
+		t.canceled = make(chan struct{})
+	default:
+	}
+	select {
+	case <-t.resumed:
+	default:
+		close(t.resumed)
+	}
+	t.state = Queued
+	t.err = nil
+	if t.Kind == Download {
+		t.done = 0
+		return
+	}
+	// only the publishes sent again start over, finished chunks stay accounted
+	for _, u := range t.unsent {
+		t.done -= u.sent
+		u.sent = 0
+	}
+	t.done = max(t.done, 0)
+}
+
+// wait blocks while the transfer is paused, it fails once the transfer is canceled.
+func (t *Transfer) wait() error {
+	t.lock.Lock()
+	resumed, canceled := t.resumed, t.canceled
+	t.lock.Unlock()
+	select {
+	case <-canceled:
+		return errCanceled
+	default:
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-canceled:
+		return errCanceled
+	}
+}
+
+func (t *Transfer) cancellation() <-chan struct{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.canceled
+}
+
+// handle sets what the transfer manager buttons do.
+func (t *Transfer) handle(pause, resume, cancel, retry func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.onPause, t.onResume, t.onCancel, t.onRetry = pause, resume, cancel, retry
+}
+
+// action returns the handler picked by get, read under the lock.
+func (t *Transfer) action(get func(t *Transfer) func()) func() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return get(t)
+}
+
+func (t *Transfer) Pause() {
+	if f := t.action(func(t *Transfer) func() { return t.onPause }); f != nil {
+		f()
+	}
+}
+
+func (t *Transfer) Resume() {
+	if f := t.action(func(t *Transfer) func() { return t.onResume }); f != nil {
+		f()
+	}
+}
+
+func (t *Transfer) Cancel() {
+	if f := t.action(func(t *Transfer) func() { return t.onCancel }); f != nil {
+		f()
+	}
+}
+
+func (t *Transfer) Retry() {
+	if f := t.action(func(t *Transfer) func() { return t.onRetry }); f != nil {
+		f()
+	}
+}
+
+// speedMeter averages throughput over one second windows.
+type speedMeter struct {
+	start time.Time
+	bytes int
+	rate  int
+}
+
+func (m *speedMeter) add(n int) int {
+	now := time.Now()
+	if m.start.IsZero() {
+		m.start = now
+	}
+	m.bytes += n
+	if elapsed := now.Sub(m.start); elapsed >= time.Second {
+		m.rate = int(float64(m.bytes) / elapsed.Seconds())
+		m.start, m.bytes = now, 0
+	}
+	return m.rate
+}
+
+// slots is a semaphore whose limit can change while it is in use.
+type slots struct {
+	limit   int
+	used    int
+	waiters []chan struct{}
+	lock    sync.Mutex
+}
+
+// acquire waits for a free slot, it gives up when cancel is closed.
+func (s *slots) acquire(cancel <-chan struct{}) bool {
+	s.lock.Lock()
+	if s.used < s.limit && len(s.waiters) == 0 {
+		s.used++
+		s.lock.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	s.waiters = append(s.waiters, ch)
+	s.lock.Unlock()
+	select {
+	case <-ch:
+		return true
+	case <-cancel:
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if i := slices.Index(s.waiters, ch); i >= 0 {
+			s.waiters = slices.Delete(s.waiters, i, i+1)
+			return false
+		}
+		// granted while canceling, hand it on
+		s.used--
+		s.grant()
+		return false
+	}
+}
+
+func (s *slots) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.used--
+	s.grant()
+}
+
+func (s *slots) setLimit(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.limit = max(n, 1)
+	s.grant()
+}
+
+func (s *slots) grant() {
+	for s.used < s.limit && len(s.waiters) > 0 {
+		ch := s.waiters[0]
+		s.waiters = s.waiters[1:]
+		s.used++
+		close(ch)
+	}
+}
+
+// rateLimiter is a token bucket shared by all uploads, a zero rate disables it.
+type rateLimiter struct {
+	rate   int64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func (l *rateLimiter) setRate(rate int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rate = rate
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// burst bounds a single read, so that a slow limit is still smooth.
+func (l *rateLimiter) burst(n int) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.rate == 0 {
+		return n
+	}
+	return max(min(n, int(l.rate/10)), 1)
+}
+
+func (l *rateLimiter) wait(n int) {
+	for {
+		l.lock.Lock()
+		if l.rate == 0 {
+			l.lock.Unlock()
+			return
+		}
+		now := time.Now()
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+		l.last = now
+		if l.tokens >= float64(n) {
+			l.tokens -= float64(n)
+			l.lock.Unlock()
+			return
+		}
+		delay := time.Duration((float64(n) - l.tokens) / float64(l.rate) * float64(time.Second))
+		l.lock.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader feeds upload content to wi, honoring pause, cancel and the upload limit.
+type throttledReader struct {
+	io.ReadSeekCloser
+	transfer *Transfer
+	limiter  *rateLimiter
+	id       uint32
+	pos      int64
+	sent     int64
+	done     func()
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if err := r.transfer.wait(); err != nil {
+		r.done()
+		return 0, err
+	}
+	p = p[:r.limiter.burst(len(p))]
+	r.limiter.wait(len(p))
+	n, err := r.ReadSeekCloser.Read(p)
+	r.pos += int64(n)
+	// data sent again after a seek back is not progress
+	if r.pos > r.sent {
+		r.transfer.add(r.id, int(r.pos-r.sent))
+		r.sent = r.pos
+	}
+	if errors.Is(err, io.EOF) {
+		r.transfer.sent(r.id)
+		r.done()
+	}
+	return n, err
+}
+
+func (r *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeekCloser.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+func (r *throttledReader) Close() error {
+	r.done()
+	return r.ReadSeekCloser.Close()
+}
+
+// TransferManager tracks uploads and downloads and enforces the concurrency
+// and upload limits of Preferences.
+type TransferManager struct {
+	transfers []*Transfer
+	uploads   *slots
+	downloads *slots
+	limiter   *rateLimiter
+	lock      sync.Mutex
+}
+
+func NewTransferManager() *TransferManager {
+	p := Prefs.Get()
+	m := &TransferManager{
+		uploads:   &slots{limit: p.MaxTransfers},
+		downloads: &slots{limit: p.MaxTransfers},
+		limiter:   &rateLimiter{},
+	}
+	m.limiter.setRate(p.UploadLimit)
+	return m
+}
+
+// Apply updates limits from p, queued transfers start if slots became free.
+func (m *TransferManager) Apply(p Preferences) {
+	m.uploads.setLimit(p.MaxTransfers)
+	m.downloads.setLimit(p.MaxTransfers)
+	m.limiter.setRate(p.UploadLimit)
+}
+
+// Track returns the unfinished transfer of kind for id, or registers a new one.
+func (m *TransferManager) Track(kind TransferKind, id uint32, filename string, size int64) *Transfer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, t := range m.transfers {
+		if t.Kind == kind && t.ID == id && !t.State().finished() {
+			return t
+		}
+	}
+	t := newTransfer(kind, id, filename, size)
+	m.transfers = append(m.transfers, t)
+	invalidate()
+	return t
+}
+
+// Restart makes a finished transfer runnable again, listing it again if it was cleared.
+func (m *TransferManager) Restart(t *Transfer) {
+	t.restart()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !slices.Contains(m.transfers, t) {
+		m.transfers = append(m.transfers, t)
+	}
+}
+
+// Complete marks the download of id completed, used by plain downloads.
+func (m *TransferManager) Complete(kind TransferKind, id uint32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, t := range m.transfers {
+		if t.Kind == kind && t.ID == id && !t.State().finished() {
+			t.setState(Completed, nil)
+		}
+	}
+}
+
+// Transfers returns unfinished transfers first, then finished ones, newest first.
+func (m *TransferManager) Transfers() []*Transfer {
+	m.lock.Lock()
+	ret := slices.Clone(m.transfers)
+	m.lock.Unlock()
+	slices.Reverse(ret)
+	slices.SortStableFunc(ret, func(a, b *Transfer) int {
+		return int(a.State()) - int(b.State())
+	})
+	return ret
+}
+
+// Bandwidth returns the aggregate upload and download speed in bytes per second.
+func (m *TransferManager) Bandwidth() (up int, down int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, t := range m.transfers {
+		if t.Kind == Upload {
+			up += t.Speed()
+		} else {
+			down += t.Speed()
+		}
+	}
+	return up, down
+}
+
+// ClearFinished forgets completed, failed and canceled transfers.
+func (m *TransferManager) ClearFinished() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.transfers = slices.DeleteFunc(m.transfers, func(t *Transfer) bool {
+		return t.State().finished()
+	})
+}
+
+// Publish sends content as wi content id once an upload slot is free.
+// The content is accounted to t, which may span several publishes, retrying
+// t publishes again every one of them that wasn't sent completely.
+func (m *TransferManager) Publish(t *Transfer, content func() (io.ReadSeekCloser, error), name string, size uint64, id uint32) {
+	t.queue(id, &upload{content: content, name: name, size: size})
+	t.handle(func() {
+		t.pause()
+		invalidate()
+	}, func() {
+		t.resume()
+		invalidate()
+	}, func() {
+		t.cancel()
+		invalidate()
+	}, func() {
+		m.retry(t)
+	})
+	if t.State().finished() {
+		m.Restart(t)
+	}
+	if !m.uploads.acquire(t.cancellation()) {
+		return
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(m.uploads.release)
+	}
+	if t.State() == Queued {
+		t.setState(Active, nil)
+	}
+	invalidate()
+	throttled := func() (io.ReadSeekCloser, error) {
+		r, err := content()
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return &throttledReader{ReadSeekCloser: r, transfer: t, limiter: m.limiter, id: id, done: release}, nil
+	}
+	err := wi.DefaultClient.PublishContent(throttled, name, size, id)
+	if err != nil {
+		release()
+		log.Printf("Publish %s failed: %v", name, err)
+		t.setState(Failed, err)
+		invalidate()
+	}
+}
+
+// retry publishes the unsent content of t again.
+func (m *TransferManager) retry(t *Transfer) {
+	if t.State().finished() {
+		m.Restart(t)
+	}
+	for id, u := range t.pending() {
+		go m.Publish(t, u.content, u.name, u.size, id)
+	}
+}
+
+var Transfers = NewTransferManager()
+
+// invalidate requests a redraw without blocking the caller.
+func invalidate() {
+	select {
+	case InvalidateRequest <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,254 @@
+package view
+
+import (
+	"fmt"
+	"image/color"
+	"mushin/assets/fonts"
+	"mushin/assets/icons"
+	"slices"
+	"strconv"
+	"time"
+
+	modal "mushin/ui/layout"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+	"golang.org/x/exp/shiny/materialdesign/colornames"
+)
+
+// uploadSteps are the upload limits offered in the panel, 0 means unlimited.
+var uploadSteps = []int64{64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 0}
+
+const maxTransfersLimit = 8
+
+// TransferPanel lists uploads and downloads and edits the transfer limits.
+type TransferPanel struct {
+	*material.Theme
+	modalContent    *modal.ModalContent
+	lessConcurrency widget.Clickable
+	moreConcurrency widget.Clickable
+	lessUpload      widget.Clickable
+	moreUpload      widget.Clickable
+	clearButton     widget.Clickable
+}
+
+func NewTransferPanel() *TransferPanel {
+	p := &TransferPanel{Theme: fonts.DefaultTheme}
+	p.modalContent = modal.NewModalContent(fonts.DefaultTheme, func() {
+		modal.DefaultModal.Dismiss(nil)
+	})
+	p.modalContent.SetTitle("Transfers")
+	return p
+}
+
+func (p *TransferPanel) update(gtx layout.Context) {
+	prefs := Prefs.Get()
+	changed := false
+	if p.lessConcurrency.Clicked(gtx) && prefs.MaxTransfers > 1 {
+		prefs.MaxTransfers--
+		changed = true
+	}
+	if p.moreConcurrency.Clicked(gtx) && prefs.MaxTransfers < maxTransfersLimit {
+		prefs.MaxTransfers++
+		changed = true
+	}
+	step := slices.Index(uploadSteps, prefs.UploadLimit)
+	if step < 0 {
+		step = len(uploadSteps) - 1
+	}
+	if p.lessUpload.Clicked(gtx) && step > 0 {
+		prefs.UploadLimit = uploadSteps[step-1]
+		changed = true
+	}
+	if p.moreUpload.Clicked(gtx) && step < len(uploadSteps)-1 {
+		prefs.UploadLimit = uploadSteps[step+1]
+		changed = true
+	}
+	if changed {
+		Prefs.Update(func(v *Preferences) {
+			v.MaxTransfers = prefs.MaxTransfers
+			v.UploadLimit = prefs.UploadLimit
+		})
+		Transfers.Apply(prefs)
+	}
+	if p.clearButton.Clicked(gtx) {
+		Transfers.ClearFinished()
+	}
+}
+
+func (p *TransferPanel) Layout(gtx layout.Context) layout.Dimensions {
+	p.update(gtx)
+	transfers := Transfers.Transfers()
+	// speeds are sampled, keep redrawing while something moves
+	if slices.ContainsFunc(transfers, func(t *Transfer) bool { return t.State() == Active }) {
+		gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(time.Second)})
+	}
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	children := []layout.FlexChild{
+		layout.Rigid(p.drawBandwidth),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(12)}.Layout),
+		layout.Rigid(p.drawLimits),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+	}
+	for _, t := range transfers {
+		children = append(children,
+			layout.Rigid(p.drawTransfer(t)),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(12)}.Layout),
+		)
+	}
+	if slices.ContainsFunc(transfers, func(t *Transfer) bool { return t.State().finished() }) {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Spacing: layout.SpaceStart}.Layout(gtx, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return material.Button(p.Theme, &p.clearButton, "Clear finished").Layout(gtx)
+			}))
+		}))
+	}
+	margins := layout.Inset{Top: unit.Dp(12), Bottom: unit.Dp(24), Left: unit.Dp(16), Right: unit.Dp(16)}
+	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+	})
+}
+
+func (p *TransferPanel) drawBandwidth(gtx layout.Context) layout.Dimensions {
+	up, down := Transfers.Bandwidth()
+	text := fmt.Sprintf("↑ %s/s   ↓ %s/s", toHumanReadable(float32(up)), toHumanReadable(float32(down)))
+	label := material.Label(p.Theme, p.TextSize, text)
+	label.Font.Weight = font.Bold
+	return label.Layout(gtx)
+}
+
+func (p *TransferPanel) drawLimits(gtx layout.Context) layout.Dimensions {
+	prefs := Prefs.Get()
+	limit := "unlimited"
+	if prefs.UploadLimit > 0 {
+		limit = toHumanReadable(float32(prefs.UploadLimit)) + "/s"
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(p.drawStepper("Concurrent transfers", strconv.Itoa(prefs.MaxTransfers), &p.lessConcurrency, &p.moreConcurrency)),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
+		layout.Rigid(p.drawStepper("Upload limit", limit, &p.lessUpload, &p.moreUpload)),
+	)
+}
+
+func (p *TransferPanel) drawStepper(title string, value string, less, more *widget.Clickable) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Flexed(1, material.Label(p.Theme, p.TextSize*0.85, title).Layout),
+			layout.Rigid(p.drawAction(less, icons.RemoveIcon, p.ContrastBg)),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(96)
+				return layout.Center.Layout(gtx, material.Label(p.Theme, p.TextSize*0.85, value).Layout)
+			}),
+			layout.Rigid(p.drawAction(more, icons.AddIcon, p.ContrastBg)),
+		)
+	}
+}
+
+func (p *TransferPanel) drawAction(button *widget.Clickable, icon *widget.Icon, c color.NRGBA) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(24)
+			return icon.Layout(gtx, c)
+		})
+	}
+}
+
+func (p *TransferPanel) drawTransfer(t *Transfer) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		state := t.State()
+		if t.pauseButton.Clicked(gtx) {
+			t.Pause()
+		}
+		if t.resumeButton.Clicked(gtx) {
+			t.Resume()
+		}
+		if t.retryButton.Clicked(gtx) {
+			t.Retry()
+		}
+		if t.cancelButton.Clicked(gtx) {
+			t.Cancel()
+		}
+		direction := "↑"
+		if t.Kind == Download {
+			direction = "↓"
+		}
+		var actions []layout.FlexChild
+		switch state {
+		case Queued, Active:
+			actions = append(actions, layout.Rigid(p.drawAction(&t.pauseButton, icons.PauseIcon, p.ContrastBg)))
+		case Paused:
+			actions = append(actions, layout.Rigid(p.drawAction(&t.resumeButton, icons.PlayIcon, p.ContrastBg)))
+		case Failed, Canceled:
+			actions = append(actions, layout.Rigid(p.drawAction(&t.retryButton, icons.RefreshIcon, p.ContrastBg)))
+		}
+		if !state.finished() {
+			actions = append(actions,
+				layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+				layout.Rigid(p.drawAction(&t.cancelButton, icons.ClearIcon, color.NRGBA(colornames.Red400))),
+			)
+		}
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					append([]layout.FlexChild{
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+							label := material.Label(p.Theme, p.TextSize*0.85, direction+" "+t.Filename)
+							label.Font.Weight = font.Bold
+							label.MaxLines = 1
+							return label.Layout(gtx)
+						}),
+					}, actions...)...,
+				)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(4)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				bar := material.ProgressBar(p.Theme, float32(t.Progress())/100)
+				if state == Failed || state == Canceled {
+					bar.Color = color.NRGBA(colornames.Red400)
+				}
+				return bar.Layout(gtx)
+			}),
+			layout.Rigid(layout.Spacer{Height: unit.Dp(4)}.Layout),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(p.Theme, p.TextSize*0.7, p.status(t, state))
+				label.Font.Style = font.Italic
+				label.Color.A = 160
+				return label.Layout(gtx)
+			}),
+		)
+	}
+}
+
+func (p *TransferPanel) status(t *Transfer, state TransferState) string {
+	size := toHumanReadable(float32(t.Size))
+	switch state {
+	case Active:
+		return fmt.Sprintf("%d%% of %s · %s/s", t.Progress(), size, toHumanReadable(float32(t.Speed())))
+	case Failed:
+		if err := t.Err(); err != nil {
+			return fmt.Sprintf("%s · %v", state, err)
+		}
+	case Completed:
+		return fmt.Sprintf("%s · %s", state, size)
+	}
+	return fmt.Sprintf("%s · %d%% of %s", state, t.Progress(), size)
+}
+
+func (p *TransferPanel) ShowWithModal() {
+	modal.DefaultModal.Show(p.ZoomInWithModalContent, nil, component.VisibilityAnimation{
+		Duration: time.Millisecond * 250,
+		State:    component.Invisible,
+		Started:  time.Time{},
+	})
+}
+
+func (p *TransferPanel) ZoomInWithModalContent(gtx layout.Context) layout.Dimensions {
+	gtx.Constraints.Max.X = int(float32(gtx.Constraints.Max.X) * 0.85)
+	gtx.Constraints.Max.Y = int(float32(gtx.Constraints.Max.Y) * 0.85)
+	return p.modalContent.DrawContent(gtx, p.Layout)
+}
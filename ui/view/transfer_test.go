@@ -0,0 +1,74 @@
+package view
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlots(t *testing.T) {
+	s := &slots{limit: 1}
+	if !s.acquire(nil) {
+		t.Fatalf("first acquire should succeed")
+	}
+	cancel := make(chan struct{})
+	close(cancel)
+	if s.acquire(cancel) {
+		t.Errorf("acquire beyond limit should wait until canceled")
+	}
+	granted := make(chan bool)
+	go func() {
+		granted <- s.acquire(nil)
+	}()
+	select {
+	case <-granted:
+		t.Fatalf("acquire should wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.setLimit(2)
+	select {
+	case ok := <-granted:
+		if !ok {
+			t.Errorf("raising the limit should grant the waiting acquire")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("raising the limit should wake the waiting acquire")
+	}
+	s.release()
+	s.release()
+	if s.used != 0 {
+		t.Errorf("slots used should be 0, but %d", s.used)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{}
+	l.setRate(100 << 10)
+	start := time.Now()
+	for range 5 {
+		l.wait(l.burst(32 << 10))
+	}
+	// 5 reads of 10KB at 100KB/s from an empty bucket
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("reads should be throttled, took %v", elapsed)
+	}
+}
+
+func TestTransferRestart(t *testing.T) {
+	tr := newTransfer(Upload, 1, "file", 300)
+	tr.queue(10, &upload{size: 100})
+	tr.queue(11, &upload{size: 100})
+	tr.add(10, 100)
+	tr.sent(10)
+	tr.add(11, 40)
+	tr.setState(Failed, nil)
+	if pending := tr.pending(); len(pending) != 1 || pending[11] == nil {
+		t.Fatalf("only the interrupted chunk should be pending, but %v", pending)
+	}
+	tr.restart()
+	if tr.done != 100 {
+		t.Errorf("the sent chunk should stay accounted, but %d bytes done", tr.done)
+	}
+	if tr.State() != Queued {
+		t.Errorf("state should be queued, but %v", tr.State())
+	}
+}
@@ -0,0 +1,219 @@
+package view
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mushin/assets/fonts"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"github.com/CoyAce/wi"
+)
+
+type Activity uint8
+
+const (
+	Idle Activity = iota
+	Typing
+	RecordingVoice
+)
+
+func (a Activity) String() string {
+	switch a {
+	case Typing:
+		return "typing…"
+	case RecordingVoice:
+		return "recording voice…"
+	default:
+		return ""
+	}
+}
+
+const (
+	// activityTTL bounds how long a start event is trusted without refresh,
+	// so a peer that vanishes without sending stop is cleared automatically.
+	activityTTL = 8 * time.Second
+	// activityRefresh re-sends an ongoing activity shortly before it expires.
+	activityRefresh = 5 * time.Second
+	// activityIdle is the quiet period after the last edit before stop is sent.
+	activityIdle = 3 * time.Second
+)
+
+// Composing tracks what other members of the current room are composing.
+type Composing struct {
+	sign       string
+	activities map[string]composingEntry
+	lock       sync.Mutex
+}
+
+type composingEntry struct {
+	Activity
+	expires time.Time
+}
+
+func NewComposing() *Composing {
+	return &Composing{activities: make(map[string]composingEntry)}
+}
+
+func (c *Composing) Update(uuid string, activity Activity, at time.Time) {
+	expires := at.Add(activityTTL)
+	if time.Now().After(expires) {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.resetIfSignChanged()
+	if activity == Idle {
+		delete(c.activities, uuid)
+	} else {
+		c.activities[uuid] = composingEntry{Activity: activity, expires: expires}
+		time.AfterFunc(time.Until(expires), func() {
+			InvalidateRequest <- struct{}{}
+		})
+	}
+	select {
+	case InvalidateRequest <- struct{}{}:
+	default:
+	}
+}
+
+// resetIfSignChanged drops the activities of the room that was left.
+func (c *Composing) resetIfSignChanged() {
+	if c.sign != wi.DefaultClient.Sign {
+		c.sign = wi.DefaultClient.Sign
+		clear(c.activities)
+	}
+}
+
+// Line describes current activities, e.g. "alice is typing…".
+func (c *Composing) Line() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.resetIfSignChanged()
+	now := time.Now()
+	groups := make(map[Activity][]string)
+	for uuid, e := range c.activities {
+		if now.After(e.expires) {
+			delete(c.activities, uuid)
+			continue
+		}
+		groups[e.Activity] = append(groups[e.Activity], nicknameOf(uuid))
+	}
+	var parts []string
+	for _, activity := range []Activity{Typing, RecordingVoice} {
+		names := groups[activity]
+		slices.Sort(names)
+		switch {
+		case len(names) == 0:
+		case len(names) == 1:
+			parts = append(parts, fmt.Sprintf("%s is %s", names[0], activity))
+		case len(names) <= 3:
+			parts = append(parts, fmt.Sprintf("%s are %s", strings.Join(names, ", "), activity))
+		default:
+			parts = append(parts, fmt.Sprintf("several people are %s", activity))
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+func (c *Composing) Layout(gtx layout.Context) layout.Dimensions {
+	line := c.Line()
+	if line == "" {
+		return layout.Dimensions{}
+	}
+	margins := layout.Inset{Left: unit.Dp(16), Right: unit.Dp(16), Bottom: unit.Dp(2)}
+	return margins.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		label := material.Label(fonts.DefaultTheme, fonts.DefaultTheme.TextSize*0.65, line)
+		label.Font.Style = font.Italic
+		label.Color = fonts.DefaultTheme.ContrastBg
+		label.MaxLines = 1
+		return label.Layout(gtx)
+	})
+}
+
+func nicknameOf(uuid string) string {
+	if idx := strings.Index(uuid, "#"); idx > 0 {
+		return uuid[:idx]
+	}
+	return uuid
+}
+
+// ActivityNotifier throttles local activity so that only start and stop
+// events (plus a refresh before expiry) go over the wire. Events are sent in
+// order by one goroutine, a state replaced before it was sent is skipped.
+type ActivityNotifier struct {
+	current  Activity
+	lastSent time.Time
+	idle     *time.Timer
+	pending  chan struct{}
+	once     sync.Once
+	lock     sync.Mutex
+}
+
+// Notify reports that activity is ongoing, it is cheap to call on every edit or frame.
+func (n *ActivityNotifier) Notify(activity Activity) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.idle == nil {
+		n.idle = time.AfterFunc(activityIdle, n.Stop)
+	} else {
+		n.idle.Reset(activityIdle)
+	}
+	if activity == n.current && time.Since(n.lastSent) < activityRefresh {
+		return
+	}
+	n.current, n.lastSent = activity, time.Now()
+	n.wake()
+}
+
+// Stop sends a stop event if an activity was announced.
+func (n *ActivityNotifier) Stop() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.idle != nil {
+		n.idle.Stop()
+	}
+	if n.current == Idle {
+		return
+	}
+	n.current, n.lastSent = Idle, time.Now()
+	n.wake()
+}
+
+// wake has the sender send the current state, the caller holds the lock.
+func (n *ActivityNotifier) wake() {
+	n.once.Do(func() {
+		n.pending = make(chan struct{}, 1)
+		go n.run()
+	})
+	select {
+	case n.pending <- struct{}{}:
+	default:
+	}
+}
+
+func (n *ActivityNotifier) run() {
+	for range n.pending {
+		n.lock.Lock()
+		activity := n.current
+		n.lock.Unlock()
+		n.send(activity)
+	}
+}
+
+func (n *ActivityNotifier) send(activity Activity) {
+	err := SendControl(ControlMessage{Kind: ControlActivity, Activity: activity})
+	if err != nil && !errors.Is(err, errOldPeers) {
+		log.Printf("send activity failed: %v", err)
+	}
+}
+
+var DefaultComposing = NewComposing()
+var DefaultActivity = &ActivityNotifier{}
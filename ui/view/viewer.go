@@ -0,0 +1,466 @@
+package view
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"mushin/assets/fonts"
+	"mushin/assets/icons"
+	"mushin/internal/imaging"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	modal "mushin/ui/layout"
+
+	"gioui.org/f32"
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/component"
+)
+
+const (
+	maxZoom        = 8
+	doubleTapDelay = 300 * time.Millisecond
+	// viewerMaxEdge bounds the decoded image, larger photos cost too much
+	// memory and exceed the texture size of many GPUs
+	viewerMaxEdge = 4096
+)
+
+// viewerItem is an image or gif of the chat shown by the viewer.
+type viewerItem struct {
+	path     string
+	filename string
+	gif      bool
+}
+
+// galleryItems lists the images and gifs of messages in chat order, albums expanded.
+func galleryItems(messages []*Message) []viewerItem {
+	var items []viewerItem
+	for _, m := range messages {
+		switch m.MessageType {
+		case Image, GIF:
+			items = append(items, viewerItem{path: m.OptimizedFilePath(), filename: m.Filename, gif: m.MessageType == GIF})
+		case Album:
+			for _, item := range m.Album {
+				path := m.albumItemPath(item)
+				// the album drew its cells, so their kind is known by now
+				animated, _ := animatedState(path)
+				items = append(items, viewerItem{path: path, filename: item.Filename, gif: animated})
+			}
+		}
+	}
+	return items
+}
+
+// ImageViewer shows the images of the chat full screen. Scroll or pinch zooms,
+// drag pans, double tap toggles fit and fill, swipe or arrow keys navigate.
+type ImageViewer struct {
+	*material.Theme
+	Source      *MessageList
+	items       []viewerItem
+	index       int
+	closeButton widget.Clickable
+	prevButton  widget.Clickable
+	nextButton  widget.Clickable
+	// control reuses the save and browse handlers of file messages
+	control FileControl
+	zoom    float32
+	offset  f32.Point
+	fill    bool
+	swipe   float32
+	// pointers tracks touches for pinch zoom
+	pointers   map[pointer.ID]f32.Point
+	lastTap    time.Time
+	lastTapPos f32.Point
+	size       image.Point
+	focused    bool
+	full       image.Image
+	fullOp     paint.ImageOp
+	fullPath   string
+	broken     bool
+	lock       sync.Mutex
+}
+
+func NewImageViewer() *ImageViewer {
+	return &ImageViewer{Theme: fonts.DefaultTheme, zoom: 1, pointers: make(map[pointer.ID]f32.Point)}
+}
+
+// Open shows the gallery of the current chat starting at path.
+func (v *ImageViewer) Open(path string) {
+	var items []viewerItem
+	if v.Source != nil {
+		items = galleryItems(*v.Source.Messages.Load())
+	}
+	index := -1
+	for i, item := range items {
+		if item.path == path {
+			index = i
+		}
+	}
+	if index < 0 {
+		animated, _ := animatedState(path)
+		items = []viewerItem{{path: path, filename: filepath.Base(path), gif: animated}}
+		index = 0
+	}
+	v.items, v.focused = items, false
+	v.show(index)
+	modal.DefaultModal.Show(v.Layout, nil, component.VisibilityAnimation{
+		Duration: time.Millisecond * 250,
+		State:    component.Invisible,
+		Started:  time.Time{},
+	})
+}
+
+func (v *ImageViewer) close() {
+	modal.DefaultModal.Dismiss(func() {
+		v.lock.Lock()
+		v.full, v.fullOp, v.fullPath = nil, paint.ImageOp{}, ""
+		v.lock.Unlock()
+	})
+}
+
+// show moves to item i and resets the zoom.
+func (v *ImageViewer) show(i int) {
+	if i < 0 || i >= len(v.items) {
+		return
+	}
+	v.index = i
+	v.zoom, v.offset, v.fill, v.swipe = 1, f32.Point{}, false, 0
+	item := v.items[i]
+	v.control.Filename = item.filename
+	if !item.gif {
+		v.load(item.path)
+	}
+}
+
+// load decodes the current image up to viewerMaxEdge, the cache keeps a smaller copy.
+// The image op is built once so the texture is uploaded once per image.
+func (v *ImageViewer) load(path string) {
+	v.lock.Lock()
+	v.full, v.fullOp, v.fullPath, v.broken = nil, paint.ImageOp{}, path, false
+	v.lock.Unlock()
+	go func() {
+		var img image.Image
+		file, err := Open(path)
+		if err == nil {
+			img, err = decodeImage(file)
+			_ = file.Close()
+		}
+		if err != nil {
+			log.Printf("viewer load %s failed: %v", path, err)
+		}
+		var imgOp paint.ImageOp
+		if err == nil {
+			img = imaging.Fit(img, viewerMaxEdge, viewerMaxEdge, imaging.Area)
+			imgOp = paint.NewImageOp(img)
+		}
+		v.lock.Lock()
+		if v.fullPath == path {
+			v.full, v.fullOp, v.broken = img, imgOp, err != nil
+		}
+		v.lock.Unlock()
+		invalidate()
+	}()
+}
+
+func (v *ImageViewer) current() (image.Image, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.full, v.broken
+}
+
+func (v *ImageViewer) update(gtx layout.Context) {
+	if !v.focused {
+		gtx.Execute(key.FocusCmd{Tag: v})
+		v.focused = true
+	}
+	if v.closeButton.Clicked(gtx) {
+		v.close()
+	}
+	if v.prevButton.Clicked(gtx) {
+		v.show(v.index - 1)
+	}
+	if v.nextButton.Clicked(gtx) {
+		v.show(v.index + 1)
+	}
+	path := v.items[v.index].path
+	if runtime.GOOS == "ios" {
+		v.control.processPhotoSave(gtx, path)
+	} else {
+		v.control.processFileSave(gtx, path)
+	}
+	v.control.processFileBrowse(gtx, path)
+	for {
+		e, ok := gtx.Event(
+			key.Filter{Focus: v, Name: key.NameLeftArrow},
+			key.Filter{Focus: v, Name: key.NameRightArrow},
+			key.Filter{Focus: v, Name: key.NameEscape},
+		)
+		if !ok {
+			break
+		}
+		if e, ok := e.(key.Event); ok && e.State == key.Press {
+			switch e.Name {
+			case key.NameLeftArrow:
+				v.show(v.index - 1)
+			case key.NameRightArrow:
+				v.show(v.index + 1)
+			case key.NameEscape:
+				v.close()
+			}
+		}
+	}
+	for {
+		e, ok := gtx.Event(pointer.Filter{
+			Target:  v,
+			Kinds:   pointer.Press | pointer.Drag | pointer.Release | pointer.Cancel | pointer.Scroll,
+			ScrollY: pointer.ScrollRange{Min: math.MinInt32, Max: math.MaxInt32},
+		})
+		if !ok {
+			break
+		}
+		if e, ok := e.(pointer.Event); ok {
+			v.handlePointer(gtx, e)
+		}
+	}
+}
+
+func (v *ImageViewer) handlePointer(gtx layout.Context, e pointer.Event) {
+	switch e.Kind {
+	case pointer.Press:
+		v.pointers[e.PointerID] = e.Position
+		if len(v.pointers) > 1 {
+			return
+		}
+		now := gtx.Now
+		if now.Sub(v.lastTap) < doubleTapDelay && distance(e.Position, v.lastTapPos) < float32(gtx.Dp(24)) {
+			v.toggleFill()
+			v.lastTap = time.Time{}
+			return
+		}
+		v.lastTap, v.lastTapPos = now, e.Position
+	case pointer.Drag:
+		last, ok := v.pointers[e.PointerID]
+		if !ok {
+			return
+		}
+		if len(v.pointers) == 2 {
+			v.pinch(e.PointerID, e.Position)
+			return
+		}
+		v.pointers[e.PointerID] = e.Position
+		delta := e.Position.Sub(last)
+		if v.zoomed() {
+			v.offset = v.offset.Add(delta)
+			v.clampOffset()
+		} else {
+			v.swipe += delta.X
+		}
+	case pointer.Release, pointer.Cancel:
+		delete(v.pointers, e.PointerID)
+		if len(v.pointers) > 0 {
+			return
+		}
+		threshold := float32(v.size.X) / 5
+		switch {
+		case v.swipe < -threshold:
+			v.show(v.index + 1)
+		case v.swipe > threshold:
+			v.show(v.index - 1)
+		}
+		v.swipe = 0
+	case pointer.Scroll:
+		v.zoomAt(e.Position, float32(math.Exp(float64(-e.Scroll.Y)*0.005)))
+	}
+}
+
+// pinch zooms by the change of distance between the two touches, around their middle.
+func (v *ImageViewer) pinch(id pointer.ID, pos f32.Point) {
+	var other f32.Point
+	for pid, p := range v.pointers {
+		if pid != id {
+			other = p
+		}
+	}
+	before := distance(v.pointers[id], other)
+	v.pointers[id] = pos
+	after := distance(pos, other)
+	if before < 1 {
+		return
+	}
+	mid := pos.Add(other).Mul(0.5)
+	v.zoomAt(mid, after/before)
+}
+
+func distance(a, b f32.Point) float32 {
+	d := a.Sub(b)
+	return float32(math.Hypot(float64(d.X), float64(d.Y)))
+}
+
+func (v *ImageViewer) zoomed() bool {
+	return v.zoom > 1 || v.fill
+}
+
+func (v *ImageViewer) toggleFill() {
+	if v.zoomed() {
+		v.zoom, v.offset, v.fill = 1, f32.Point{}, false
+		return
+	}
+	v.fill = true
+}
+
+// zoomAt scales by factor keeping the image point under p in place.
+func (v *ImageViewer) zoomAt(p f32.Point, factor float32) {
+	zoom := min(max(v.zoom*factor, 1), maxZoom)
+	factor = zoom / v.zoom
+	v.zoom = zoom
+	center := layout.FPt(v.size).Mul(0.5)
+	// offset' = p - center - (p - center - offset) * factor
+	v.offset = p.Sub(center).Sub(p.Sub(center).Sub(v.offset).Mul(factor))
+	v.clampOffset()
+}
+
+// clampOffset keeps the image edges from leaving the screen edges.
+func (v *ImageViewer) clampOffset() {
+	size := v.imageSize()
+	limit := func(o float32, img float32, screen int) float32 {
+		m := max(0, (img-float32(screen))/2)
+		return min(max(o, -m), m)
+	}
+	v.offset.X = limit(v.offset.X, size.X, v.size.X)
+	v.offset.Y = limit(v.offset.Y, size.Y, v.size.Y)
+}
+
+// bounds returns the size of the current image in pixels.
+func (v *ImageViewer) bounds() image.Point {
+	item := v.items[v.index]
+	if item.gif {
+		if g := LoadGif(item.path, false); g.Animation != nil {
+			return image.Pt(g.Width, g.Height)
+		}
+		return image.Point{}
+	}
+	if img, _ := v.current(); img != nil {
+		return img.Bounds().Size()
+	}
+	return image.Point{}
+}
+
+// scale is the ratio from image to screen pixels.
+func (v *ImageViewer) scale() float32 {
+	b := v.bounds()
+	if b.X == 0 || b.Y == 0 || v.size.X == 0 || v.size.Y == 0 {
+		return 1
+	}
+	sx, sy := float32(v.size.X)/float32(b.X), float32(v.size.Y)/float32(b.Y)
+	if v.fill {
+		return max(sx, sy) * v.zoom
+	}
+	return min(sx, sy) * v.zoom
+}
+
+func (v *ImageViewer) imageSize() f32.Point {
+	return layout.FPt(v.bounds()).Mul(v.scale())
+}
+
+func (v *ImageViewer) Layout(gtx layout.Context) layout.Dimensions {
+	if len(v.items) == 0 {
+		return layout.Dimensions{}
+	}
+	v.size = gtx.Constraints.Max
+	v.update(gtx)
+	gtx.Constraints.Min = gtx.Constraints.Max
+	defer clip.Rect{Max: v.size}.Push(gtx.Ops).Pop()
+	paint.Fill(gtx.Ops, color.NRGBA{A: 240})
+	event.Op(gtx.Ops, v)
+	v.drawImage(gtx)
+	v.drawNavigation(gtx)
+	layout.N.Layout(gtx, v.drawToolbar)
+	return layout.Dimensions{Size: v.size}
+}
+
+func (v *ImageViewer) drawImage(gtx layout.Context) {
+	item := v.items[v.index]
+	size := v.imageSize()
+	if size.X == 0 || size.Y == 0 {
+		if _, broken := v.current(); broken || item.gif {
+			layout.Center.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				gtx.Constraints.Min.X = gtx.Dp(96)
+				return icons.ImageBrokenIcon.Layout(gtx, v.ContrastFg)
+			})
+		}
+		return
+	}
+	origin := layout.FPt(v.size).Sub(size).Mul(0.5).Add(v.offset)
+	origin.X += v.swipe
+	if item.gif {
+		g := LoadGif(item.path, false)
+		defer op.Offset(origin.Round()).Push(gtx.Ops).Pop()
+		gtx.Constraints.Min.X = int(size.X)
+		g.Layout(gtx, WidthFixed)
+		return
+	}
+	v.lock.Lock()
+	imgOp := v.fullOp
+	v.lock.Unlock()
+	s := v.scale()
+	defer op.Affine(f32.AffineId().Scale(f32.Point{}, f32.Pt(s, s)).Offset(origin)).Push(gtx.Ops).Pop()
+	imgOp.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+}
+
+func (v *ImageViewer) drawToolbar(gtx layout.Context) layout.Dimensions {
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	fg := color.NRGBA{R: 255, G: 255, B: 255, A: 230}
+	return layout.UniformInset(unit.Dp(12)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(v.drawAction(&v.closeButton, icons.ClearIcon, fg)),
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				label := material.Label(v.Theme, v.TextSize, fmt.Sprintf("%d / %d", v.index+1, len(v.items)))
+				label.Color = fg
+				return layout.Center.Layout(gtx, label.Layout)
+			}),
+			layout.Rigid(v.drawAction(&v.control.browseButton, icons.ShareIcon, fg)),
+			layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+			layout.Rigid(v.drawAction(&v.control.saveButton, icons.FileExportIcon, fg)),
+		)
+	})
+}
+
+// drawNavigation places the previous and next buttons at the middle of the screen sides.
+func (v *ImageViewer) drawNavigation(gtx layout.Context) {
+	fg := color.NRGBA{R: 255, G: 255, B: 255, A: 180}
+	if v.index > 0 {
+		layout.W.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.UniformInset(unit.Dp(8)).Layout(gtx, v.drawAction(&v.prevButton, icons.PrevIcon, fg))
+		})
+	}
+	if v.index < len(v.items)-1 {
+		layout.E.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			return layout.UniformInset(unit.Dp(8)).Layout(gtx, v.drawAction(&v.nextButton, icons.NextIcon, fg))
+		})
+	}
+}
+
+func (v *ImageViewer) drawAction(button *widget.Clickable, icon *widget.Icon, c color.NRGBA) layout.Widget {
+	return func(gtx layout.Context) layout.Dimensions {
+		return button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+			gtx.Constraints.Min.X = gtx.Dp(32)
+			return icon.Layout(gtx, c)
+		})
+	}
+}
+
+var DefaultViewer = NewImageViewer()
@@ -1,26 +1,35 @@
 package view
 
 import (
-	"bytes"
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"log"
 	"math"
 	"mushin/assets/fonts"
+	"mushin/assets/icons"
 	"mushin/internal/audio"
 	"mushin/ui/native"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
 	"gioui.org/op/paint"
 	"gioui.org/unit"
-	"github.com/CoyAce/opus"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
 	"github.com/CoyAce/opus/ogg"
 	"github.com/CoyAce/wi"
 	"github.com/gen2brain/malgo"
@@ -31,21 +40,57 @@ type VoiceRecorder struct {
 	InteractiveSpan
 	ExpandButton
 	cancel    context.CancelFunc
-	buf       *bytes.Buffer
+	take      *voiceTake
 	startTime time.Time
-	waveform  []float32
+	// levels are published by the capture callback every 10ms
+	levels audio.LevelRing
+	recent []audio.Level
+	// recording is set from the press that starts a recording until it is sent or dropped
+	recording bool
+	// locked recordings go on without holding, swipe tracks the gesture that locks them
+	locked  bool
+	swipe   swipeState
+	paused  atomic.Bool
+	preview atomic.Pointer[Message]
+	// recovered are takes a crash left behind, previewed once preview is free
+	recovered     []*Message
+	recoveredLock sync.Mutex
+	pauseButton   widget.Clickable
+	resumeButton  widget.Clickable
+	stopButton    widget.Clickable
+	discardButton widget.Clickable
+	sendButton    widget.Clickable
 }
 
+// swipeState tracks the press that may swipe up to lock the recording.
+type swipeState struct {
+	startY float32
+}
+
+const (
+	// levelsPerBar groups 10ms levels so that bars scroll at a readable pace
+	levelsPerBar = 5
+	// meterFloor is the quietest level drawn, in dBFS
+	meterFloor = -60
+	// silentLevel is below what any working microphone picks up, in dBFS
+	silentLevel = -70
+	// lockDistance is how far up a holding press swipes to lock the recording
+	lockDistance = unit.Dp(40)
+	// stopWarning is how long before the maximum duration the countdown shows
+	stopWarning = 10 * time.Second
+)
+
+var warningColor = color.NRGBA{R: 255, G: 82, B: 82, A: 255}
+
 func (v *VoiceRecorder) Layout(gtx layout.Context) layout.Dimensions {
 	// Initialize recording state
 	if v.longPressing && v.startTime.IsZero() {
 		v.startTime = gtx.Now
-		v.waveform = make([]float32, 0)
 	}
 
 	// Animate background when recording
 	bgColor := fonts.DefaultTheme.ContrastBg
-	if v.longPressing {
+	if v.longPressing || v.locked {
 		// Pulsing neon effect during recording
 		elapsed := float32(time.Now().Sub(v.startTime).Seconds())
 		pulse := (float32(math.Sin(float64(elapsed*4*math.Pi)))+1)/2 + 0.5 // 0.5 to 1.5
@@ -60,20 +105,34 @@ func (v *VoiceRecorder) Layout(gtx layout.Context) layout.Dimensions {
 		if !ok {
 			break
 		}
-		if e.Type == Press {
+		if v.locked || v.preview.Load() != nil {
+			// the controls drive a locked recording and the preview
+			continue
+		}
+		if e.Type == Press && !v.recording {
+			v.recording = true
 			v.recordAsync()
 		}
 		if e.Type == LongPress {
 			gtx.Execute(op.InvalidateCmd{})
-		} else if e.Type == LongPressRelease {
-			v.cancel()
-			v.encodeAndSendAsync()
-		} else if e.Type == Click || e.Type == Cancel {
-			v.cancel()
+		} else if e.Type == LongPressRelease && v.recording {
+			v.stop(false)
+		} else if (e.Type == Click || e.Type == Cancel) && v.recording {
+			v.discard()
 		}
 	}
+	v.processSwipe(gtx)
+	v.processControls(gtx)
+	if v.take != nil && v.take.failed.Load() {
+		v.discard()
+	}
+	if v.recording && v.elapsed() >= maxVoiceDuration() {
+		// a locked recording is previewed, a held one is sent as on release
+		v.stop(v.locked)
+	}
 	// Ensure continuous animation for cancellation feedback
-	if v.longPressing {
+	if v.longPressing || v.locked {
+		DefaultActivity.Notify(RecordingVoice)
 		gtx.Execute(op.InvalidateCmd{})
 	}
 	macro := op.Record(gtx.Ops)
@@ -87,13 +146,20 @@ func (v *VoiceRecorder) Layout(gtx layout.Context) layout.Dimensions {
 			// voice input with geek-style waveform visualization
 			layout.Flexed(1.0, func(gtx layout.Context) layout.Dimensions {
 				gtx.Constraints.Max.Y = gtx.Dp(42)
+				if m := v.preview.Load(); m != nil {
+					return m.MediaControl.Layout(gtx, m.FilePath(), true)
+				}
+				// the swipe handler wraps the press area to see its drags
+				area := clip.Rect{Max: gtx.Constraints.Max}.Push(gtx.Ops)
+				event.Op(gtx.Ops, &v.swipe)
 				v.InteractiveSpan.Layout(gtx)
+				area.Pop()
 
 				// Record drawing operations for rounded rectangle with effects
 				defer clip.UniformRRect(image.Rectangle{Max: gtx.Constraints.Max}, gtx.Dp(21)).Push(gtx.Ops).Pop()
 
 				// Draw waveform visualization when recording
-				if v.longPressing && v.click.Hovered() {
+				if v.locked || v.longPressing && v.click.Hovered() {
 					v.drawWaveform(gtx)
 				} else if v.longPressing && !v.click.Hovered() {
 					// Show cancellation feedback
@@ -108,6 +174,9 @@ func (v *VoiceRecorder) Layout(gtx layout.Context) layout.Dimensions {
 			}),
 			// expand button
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if v.locked || v.preview.Load() != nil {
+					return v.drawControls(gtx)
+				}
 				if v.longPressing {
 					return layout.Dimensions{}
 				}
@@ -129,7 +198,7 @@ func (v *VoiceRecorder) Layout(gtx layout.Context) layout.Dimensions {
 	var topGlowColor color.NRGBA
 	var bottomGlowColor color.NRGBA
 
-	if v.longPressing && v.click.Hovered() {
+	if v.locked || v.longPressing && v.click.Hovered() {
 		// Normal recording state - cyan glow
 		topGlowColor = fonts.BrightCyan
 		topGlowColor.A = 180
@@ -172,52 +241,78 @@ func (v *VoiceRecorder) Layout(gtx layout.Context) layout.Dimensions {
 }
 
 func (v *VoiceRecorder) drawWaveform(gtx layout.Context) {
-	// Simulate real-time waveform based on recording time
-	elapsed := float32(time.Now().Sub(v.startTime).Seconds())
-	barCount := gtx.Constraints.Max.X / gtx.Dp(3)
-
-	if barCount > 0 {
-		centerY := gtx.Constraints.Max.Y / 2
-		maxBarHeight := gtx.Dp(28)
-
-		// Draw animated waveform bars
-		for i := 0; i < int(barCount); i++ {
-			// Calculate bar position with left-to-right animation
-			progress := float32(i) / float32(barCount)
-			animationDelay := elapsed - progress*0.5
-
-			if animationDelay < 0 {
-				continue // Bars haven't reached this position yet
-			}
+	// Draw the measured input level, newest bar at the right
+	barStep := gtx.Dp(3)
+	barCount := gtx.Constraints.Max.X / barStep
+	if barCount <= 0 {
+		return
+	}
+	v.recent = v.levels.Recent(v.recent[:0], barCount*levelsPerBar)
+	centerY := gtx.Constraints.Max.Y / 2
+	maxBarHeight := gtx.Dp(28)
+	barWidth := gtx.Dp(2)
+
+	clipped, silent := false, len(v.recent) >= 150
+	bars := (len(v.recent) + levelsPerBar - 1) / levelsPerBar
+	for i := 0; i < bars; i++ {
+		// the oldest group may be partial, the newest ones are whole
+		end := len(v.recent) - (bars-1-i)*levelsPerBar
+		var level audio.Level
+		for _, l := range v.recent[max(0, end-levelsPerBar):end] {
+			level.RMS = max(level.RMS, l.RMS)
+			level.Peak = max(level.Peak, l.Peak)
+		}
+		db := float64(level.DB())
+		if i >= bars-100/levelsPerBar {
+			// judged on the last second only
+			clipped = clipped || level.Clipped()
+		}
+		if i >= bars-150/levelsPerBar && db > silentLevel {
+			silent = false
+		}
+		norm := max(0, min(1, (db-meterFloor)/-meterFloor))
+		barHeight := max(gtx.Dp(2), int(norm*float64(maxBarHeight)))
 
-			// Generate bar height using sine wave combination for organic look
-			phase := float32(i) * 0.3
-			barHeight := float32(maxBarHeight) * float32(
-				0.3+
-					0.4*math.Abs(math.Sin(float64(phase+elapsed*2)))+
-					0.3*math.Abs(math.Sin(float64(phase*2-elapsed*3))),
-			)
-
-			// Gradient color from cyan to purple
-			var barColor color.NRGBA
-			if i%2 == 0 {
-				barColor = fonts.BrightCyan
-				barColor.A = uint8(float32(180) * float32(math.Sin(float64(animationDelay*math.Pi))))
-			} else {
-				barColor = fonts.BrightPurple
-				barColor.A = uint8(float32(180) * float32(math.Sin(float64(animationDelay*math.Pi))))
-			}
+		// Gradient color from cyan to purple, red where the input clipped
+		barColor := fonts.BrightCyan
+		if i%2 == 1 {
+			barColor = fonts.BrightPurple
+		}
+		if level.Clipped() {
+			barColor = warningColor
+		}
+		x := gtx.Constraints.Max.X - (bars-i)*barStep
+		y := centerY - barHeight/2
+		paint.FillShape(gtx.Ops, barColor, clip.Rect{
+			Min: image.Point{X: x, Y: y},
+			Max: image.Point{X: x + barWidth, Y: y + barHeight},
+		}.Op())
+	}
 
-			// Draw bar centered vertically
-			barWidth := gtx.Dp(2)
-			x := i * gtx.Dp(3)
-			y := centerY - int(barHeight)/2
+	elapsed := v.elapsed()
+	timeLabel := material.Label(fonts.DefaultTheme, unit.Sp(11), formatDuration(elapsed))
+	timeLabel.Color = fonts.DimWhite
+	layout.W.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Inset{Left: unit.Dp(12)}.Layout(gtx, timeLabel.Layout)
+	})
 
-			paint.FillShape(gtx.Ops, barColor, clip.Rect{
-				Min: image.Point{X: x, Y: y},
-				Max: image.Point{X: x + barWidth, Y: y + int(barHeight)},
-			}.Op())
-		}
+	warning, warningFg := "", warningColor
+	switch left := maxVoiceDuration() - elapsed; {
+	case left <= stopWarning:
+		warning = fmt.Sprintf("%d 秒后自动停止", int(math.Ceil(left.Seconds())))
+	case v.paused.Load():
+		warning = "已暂停"
+	case clipped:
+		warning = "音量过大"
+	case silent:
+		warning = "未检测到声音，请检查麦克风"
+	case !v.locked:
+		warning, warningFg = "↑ 上滑锁定", fonts.DimWhite
+	}
+	if warning != "" {
+		label := material.Label(fonts.DefaultTheme, unit.Sp(12), warning)
+		label.Color = warningFg
+		layout.Center.Layout(gtx, label.Layout)
 	}
 }
 
@@ -464,66 +559,332 @@ func (v *VoiceRecorder) drawGlitchMicrophoneIcon(gtx layout.Context) {
 	}
 }
 
-func (v *VoiceRecorder) encodeAndSendAsync() {
-	go func() {
-		loc, _ := time.LoadLocation("Asia/Shanghai")
-		timeNow := time.Now().In(loc).Format("20060102150405")
-		filePath := GetDataPath(timeNow + ".opus")
-		log.Printf("audio file path %s", filePath)
-		w, err := os.Create(filePath)
-		if err != nil {
-			log.Printf("create file %s failed, %s", filePath, err)
-			return
+// elapsed is how much was recorded, pauses left out.
+func (v *VoiceRecorder) elapsed() time.Duration {
+	return time.Duration(v.levels.Count()) * 10 * time.Millisecond
+}
+
+// maxVoiceSeconds are the choices for the longest voice recording.
+var maxVoiceSeconds = []int{30, 60, 120, 300}
+
+func maxVoiceDuration() time.Duration {
+	return time.Duration(Prefs.Get().MaxVoiceSeconds) * time.Second
+}
+
+func formatDuration(d time.Duration) string {
+	sec := int(d.Seconds())
+	return fmt.Sprintf("%d:%02d", sec/60, sec%60)
+}
+
+// processSwipe locks a held recording once the press swipes up far enough.
+func (v *VoiceRecorder) processSwipe(gtx layout.Context) {
+	for {
+		ev, ok := gtx.Event(pointer.Filter{Target: &v.swipe, Kinds: pointer.Press | pointer.Drag})
+		if !ok {
+			break
 		}
-		pcm := v.buf.Bytes()
-		samples := len(pcm) / 4
-		processed, err := enhancer.ProcessBatch(audio.ToFloat32(pcm))
-		if err != nil {
-			log.Printf("process audio failed, %s", err)
+		e, ok := ev.(pointer.Event)
+		if !ok {
+			continue
 		}
-		output := ogg.ToBytes(audio.Float32ToInt16(processed))
-		err = ogg.NewEncoder(ogg.FrameSize, 1, opus.AppVoIP).Encode(w, output)
-		if err != nil {
-			log.Printf("encode file %s failed, %s", filePath, err)
+		switch e.Kind {
+		case pointer.Press:
+			v.swipe.startY = e.Position.Y
+		case pointer.Drag:
+			if v.recording && v.longPressing && !v.locked && v.swipe.startY-e.Position.Y > float32(gtx.Dp(lockDistance)) {
+				v.locked = true
+				gtx.Execute(op.InvalidateCmd{})
+			}
+		}
+	}
+}
+
+func (v *VoiceRecorder) processControls(gtx layout.Context) {
+	if v.pauseButton.Clicked(gtx) {
+		v.paused.Store(true)
+	}
+	if v.resumeButton.Clicked(gtx) {
+		v.paused.Store(false)
+	}
+	if v.stopButton.Clicked(gtx) && v.recording {
+		v.stop(true)
+	}
+	if v.discardButton.Clicked(gtx) {
+		if v.recording {
+			v.discard()
+		} else if m := v.preview.Swap(nil); m != nil {
+			m.MediaControl.playback.stop()
+			_ = os.Remove(m.FilePath())
+			_ = os.Remove(peaksPath(m.FilePath()))
+			v.previewRecovered()
 		}
-		v.buf = nil
-		duration := uint32(ogg.GetDuration(samples) / time.Millisecond)
-		message := Message{
-			State: Stateless,
-			MessageStyle: MessageStyle{
-				Theme: fonts.DefaultTheme,
-			},
-			Contacts:     FromMyself(),
-			MessageType:  Voice,
-			FileControl:  FileControl{Filename: filepath.Base(filePath)},
-			CreatedAt:    time.Now(),
-			MediaControl: MediaControl{StreamConfig: v.StreamConfig, Duration: duration},
+	}
+	if v.sendButton.Clicked(gtx) {
+		if m := v.preview.Swap(nil); m != nil {
+			m.MediaControl.playback.stop()
+			go sendVoice(m)
+			v.previewRecovered()
 		}
-		message.Format = malgo.FormatS16
-		MessageBox <- &message
-		err = wi.DefaultClient.SendVoice(filePath, duration)
+	}
+}
+
+// drawControls shows pause, stop and discard while a recording is locked,
+// send and discard while it is previewed.
+func (v *VoiceRecorder) drawControls(gtx layout.Context) layout.Dimensions {
+	action := func(button *widget.Clickable, icon *widget.Icon, c color.NRGBA) layout.FlexChild {
+		return layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return button.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.UniformInset(unit.Dp(9)).Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					gtx.Constraints.Min.X = gtx.Dp(24)
+					return icon.Layout(gtx, c)
+				})
+			})
+		})
+	}
+	fg := fonts.DefaultTheme.ContrastFg
+	if v.preview.Load() != nil {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			action(&v.discardButton, icons.ClearIcon, warningColor),
+			action(&v.sendButton, icons.SubmitIcon, fg),
+		)
+	}
+	pause := action(&v.pauseButton, icons.PauseIcon, fg)
+	if v.paused.Load() {
+		pause = action(&v.resumeButton, icons.PlayIcon, fg)
+	}
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		action(&v.discardButton, icons.ClearIcon, warningColor),
+		pause,
+		action(&v.stopButton, icons.CheckCircleIcon, fg),
+	)
+}
+
+// stop ends the recording and sends it, or shows it for preview first.
+func (v *VoiceRecorder) stop(preview bool) {
+	v.recording, v.locked = false, false
+	v.cancel()
+	DefaultActivity.Stop()
+	take, config := v.take, v.StreamConfig
+	v.take = nil
+	if take == nil {
+		return
+	}
+	go func() {
+		duration, err := take.keep()
 		if err != nil {
-			log.Printf("Send voice %s failed, %s", filePath, err)
-		} else {
-			message.State = Sent
+			log.Printf("encode file %s failed, %s", take.path, err)
+			return
+		}
+		m := newVoiceMessage(take.path, duration, config)
+		if !preview {
+			sendVoice(m)
+			return
+		}
+		if old := v.preview.Swap(m); old != nil {
+			// a recovered take waits for its turn again
+			v.queueRecovered(old)
 		}
+		invalidate()
 	}()
 }
 
+// discard ends the recording and drops it.
+func (v *VoiceRecorder) discard() {
+	v.recording, v.locked = false, false
+	v.cancel()
+	DefaultActivity.Stop()
+	if take := v.take; take != nil {
+		v.take = nil
+		go take.drop()
+	}
+}
+
+func newVoiceMessage(filePath string, duration time.Duration, config audio.StreamConfig) *Message {
+	message := &Message{
+		State: Stateless,
+		MessageStyle: MessageStyle{
+			Theme: fonts.DefaultTheme,
+		},
+		Contacts:     FromMyself(),
+		MessageType:  Voice,
+		FileControl:  FileControl{Filename: filepath.Base(filePath)},
+		CreatedAt:    time.Now(),
+		MediaControl: MediaControl{StreamConfig: config, Duration: uint32(duration / time.Millisecond)},
+	}
+	message.Format = malgo.FormatS16
+	return message
+}
+
+func sendVoice(message *Message) {
+	message.CreatedAt = time.Now()
+	MessageBox <- message
+	filePath := message.FilePath()
+	err := wi.DefaultClient.SendVoice(filePath, message.Duration)
+	if err != nil {
+		log.Printf("Send voice %s failed, %s", filePath, err)
+	} else {
+		message.State = Sent
+	}
+}
+
+// pauseWriter drops what is written to it while paused.
+type pauseWriter struct {
+	w      io.Writer
+	paused *atomic.Bool
+}
+
+func (p pauseWriter) Write(b []byte) (int, error) {
+	if p.paused.Load() {
+		return len(b), nil
+	}
+	return p.w.Write(b)
+}
+
 func (v *VoiceRecorder) recordAsync() {
+	var ctx context.Context
+	ctx, v.cancel = context.WithCancel(context.Background())
+	take, err := newVoiceTake(cmp.Or(v.Channels, 1))
+	if err != nil {
+		log.Printf("create voice file failed, %s", err)
+		v.recording = false
+		return
+	}
+	v.take = take
+	v.levels.Reset()
+	v.paused.Store(false)
+	v.StreamConfig.Format = malgo.FormatF32
+	config := v.StreamConfig
 	go func() {
+		defer take.finish()
 		native.Tool.AskMicrophonePermission()
-		var ctx context.Context
-		ctx, v.cancel = context.WithCancel(context.Background())
-		v.buf = new(bytes.Buffer)
-		v.StreamConfig.Format = malgo.FormatF32
-		err := audio.Capture(ctx, v.buf, v.StreamConfig)
+		meter := audio.NewMeter(take.enc, &v.levels, cmp.Or(config.SampleRate, ogg.SampleRate), cmp.Or(config.Channels, 1))
+		err := audio.Capture(ctx, pauseWriter{w: meter, paused: &v.paused}, config)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
 			log.Printf("capture audio failed, %s", err)
-			v.cancel()
+			// the next frame drops the take and resets the recorder
+			take.failed.Store(true)
+			invalidate()
+			HintRequest <- "❌录音失败"
 		}
 	}()
 }
+
+// partSuffix marks a voice file still being recorded, one left behind was cut
+// short by a crash and is recovered on the next start.
+const partSuffix = ".part"
+
+// voiceTake is one recording, enhanced and encoded into its file while it is
+// captured so that it is ready as soon as the recording stops.
+type voiceTake struct {
+	path string
+	file *os.File
+	opus *audio.OpusWriter
+	enc  *audio.RecordEncoder
+	// done is closed once the capture ended and the file is complete
+	done chan struct{}
+	err  error
+	// failed is set when the capture stopped on an error
+	failed atomic.Bool
+}
+
+func newVoiceTake(channels int) (*voiceTake, error) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	timeNow := time.Now().In(loc).Format("20060102150405")
+	path := GetDataPath(timeNow + ".opus")
+	log.Printf("audio file path %s", path)
+	file, err := os.Create(path + partSuffix)
+	if err != nil {
+		return nil, err
+	}
+	w, err := audio.NewOpusWriter(file, channels)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(path + partSuffix)
+		return nil, err
+	}
+	return &voiceTake{
+		path: path,
+		file: file,
+		opus: w,
+		enc:  audio.NewRecordEncoder(w, enhancer.ProcessAudio),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// finish completes the file once nothing is captured into it any more.
+func (t *voiceTake) finish() {
+	t.err = t.enc.Close()
+	if err := t.file.Close(); t.err == nil {
+		t.err = err
+	}
+	close(t.done)
+}
+
+// keep waits for the file to be complete and gives it its final name.
+func (t *voiceTake) keep() (time.Duration, error) {
+	<-t.done
+	if t.err != nil {
+		return 0, t.err
+	}
+	return ogg.GetDuration(t.opus.Frames()), os.Rename(t.path+partSuffix, t.path)
+}
+
+func (t *voiceTake) drop() {
+	<-t.done
+	_ = os.Remove(t.path + partSuffix)
+}
+
+// recoverTakes keeps the recordings a crash left unfinished, readable up to
+// their last whole page, and previews them one after another.
+func (v *VoiceRecorder) recoverTakes() {
+	parts, _ := filepath.Glob(GetDataPath("*.opus" + partSuffix))
+	for _, part := range parts {
+		duration, err := voiceDuration(part)
+		if err != nil || duration == 0 {
+			log.Printf("drop unfinished voice file %s, %v", part, err)
+			_ = os.Remove(part)
+			continue
+		}
+		path := strings.TrimSuffix(part, partSuffix)
+		if err = os.Rename(part, path); err != nil {
+			log.Printf("recover voice file %s failed, %s", part, err)
+			continue
+		}
+		log.Printf("recovered voice file %s", path)
+		v.queueRecovered(newVoiceMessage(path, duration, v.StreamConfig))
+	}
+	v.previewRecovered()
+}
+
+func (v *VoiceRecorder) queueRecovered(m *Message) {
+	v.recoveredLock.Lock()
+	defer v.recoveredLock.Unlock()
+	v.recovered = append(v.recovered, m)
+}
+
+// previewRecovered shows the oldest recovered take unless something is previewed.
+func (v *VoiceRecorder) previewRecovered() {
+	v.recoveredLock.Lock()
+	defer v.recoveredLock.Unlock()
+	if len(v.recovered) == 0 || !v.preview.CompareAndSwap(nil, v.recovered[0]) {
+		return
+	}
+	v.recovered = v.recovered[1:]
+	invalidate()
+}
+
+func voiceDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, err := audio.NewOpusReader(f)
+	if err != nil {
+		return 0, err
+	}
+	return ogg.GetDuration(r.Frames()), nil
+}